@@ -17,25 +17,31 @@ limitations under the License.
 package main
 
 import (
+	"context"
 	"fmt"
 	"strings"
 
 	"github.com/jpinkney/stack-tests/util"
 )
 
+//CheRunner runs the steps of the features against runner
 type CheRunner struct {
-	runner util.CheAPI
+	runner util.CheClient
+	//ctx bounds every Che request and wait of the current scenario
+	ctx context.Context
+	//createdStacks are the IDs of the stacks the current scenario created, by name
+	createdStacks map[string]string
 }
 
 func (c *CheRunner) weTryToGetTheStacksInformation() error {
 
-	workspaces, stackErr := c.runner.GetStackInformation()
+	workspaces, stackErr := c.runner.GetStackInformation(c.ctx)
 
 	if stackErr != nil {
 		return stackErr
 	}
 
-	samples, samplesErr := c.runner.GetSamplesInformation()
+	samples, samplesErr := c.runner.GetSamplesInformation(c.ctx)
 
 	if samplesErr != nil {
 		return samplesErr
@@ -55,15 +61,23 @@ func (c *CheRunner) theStacksShouldNotBeEmpty() error {
 
 func (c *CheRunner) startingAWorkspaceWithStackSucceeds(stackName string) error {
 	stackStartEnvironment := c.runner.GetStackConfigMap()[stackName]
-	workspace, err := c.runner.StartWorkspace(stackStartEnvironment.Config.EnvironmentConfig, stackStartEnvironment.ID)
+	c.runner.SetStackName(stackName)
+
+	var workspace util.Workspace2
+	var err error
+	if stackStartEnvironment.Devfile != nil {
+		workspace, err = c.runner.StartDevfileWorkspace(c.ctx, *stackStartEnvironment.Devfile, stackStartEnvironment.ID)
+	} else {
+		workspace, err = c.runner.StartWorkspace(c.ctx, stackStartEnvironment.Config.EnvironmentConfig, stackStartEnvironment.ID)
+	}
+	if workspace.ID != "" {
+		c.runner.SetWorkspaceID(workspace.ID)
+	}
 	if err != nil {
 		return err
 	}
 
-	c.runner.SetWorkspaceID(workspace.ID)
-	c.runner.SetStackName(stackName)
-
-	agents, err := c.runner.GetHTTPAgents(workspace.ID)
+	agents, err := c.runner.GetHTTPAgents(c.ctx, workspace.ID)
 	if err != nil {
 		return err
 	}
@@ -73,12 +87,15 @@ func (c *CheRunner) startingAWorkspaceWithStackSucceeds(stackName string) error
 }
 
 func (c *CheRunner) workspaceShouldHaveState(expectedState string) error {
-	currentState, err := c.runner.GetWorkspaceStatusByID(c.runner.WorkspaceID)
+	currentState, err := c.runner.GetWorkspaceStatusByID(c.ctx, c.runner.GetWorkspaceID())
 	if err != nil {
 		return err
 	}
 
 	if strings.Compare(strings.ToLower(currentState.WorkspaceStatus), strings.ToLower(expectedState)) != 0 {
+		if transitions := util.DescribeTransitions(c.runner.GetStatusHistory(), c.runner.GetWorkspaceID()); transitions != "" {
+			return fmt.Errorf("Not in expected state. Current state is: %s. Expected state is: %s. Transitions: %s", currentState.WorkspaceStatus, expectedState, transitions)
+		}
 		return fmt.Errorf("Not in expected state. Current state is: %s. Expected state is: %s", currentState.WorkspaceStatus, expectedState)
 	}
 
@@ -87,7 +104,7 @@ func (c *CheRunner) workspaceShouldHaveState(expectedState string) error {
 
 func (c *CheRunner) importingTheSampleProjectSucceeds(projectURL string) error {
 	sample := c.runner.GetSamplesConfigMap()[projectURL]
-	err := c.runner.AddSamplesToProject([]util.Sample{sample})
+	err := c.runner.AddSamplesToProject(c.ctx, []util.Sample{sample})
 	if err != nil {
 		return err
 	}
@@ -95,7 +112,7 @@ func (c *CheRunner) importingTheSampleProjectSucceeds(projectURL string) error {
 }
 
 func (c *CheRunner) workspaceShouldHaveProject(numOfProjects int) error {
-	numOfProjects, err := c.runner.GetNumberOfProjects()
+	numOfProjects, err := c.runner.GetNumberOfProjects(c.ctx)
 	if err != nil {
 		return err
 	}
@@ -110,28 +127,83 @@ func (c *CheRunner) workspaceShouldHaveProject(numOfProjects int) error {
 func (c *CheRunner) userRunsCommandOnSample(projectURL string) error {
 	stackConfigMap := c.runner.GetStackConfigMap()
 	sampleConfigMap := c.runner.GetSamplesConfigMap()
+
+	var sampleCommand util.Command
 	if len(sampleConfigMap[projectURL].Commands) > 0 {
-		sampleCommand := sampleConfigMap[projectURL].Commands[0]
-		c.runner.PID, _ = c.runner.PostCommandToWorkspace(sampleCommand)
-	} else if len(stackConfigMap[c.runner.StackName].Command) > 0 {
-		sampleCommand := stackConfigMap[c.runner.StackName].Command[0]
-		c.runner.PID, _ = c.runner.PostCommandToWorkspace(sampleCommand)
+		sampleCommand = sampleConfigMap[projectURL].Commands[0]
+	} else if len(stackConfigMap[c.runner.GetStackName()].Command) > 0 {
+		sampleCommand = stackConfigMap[c.runner.GetStackName()].Command[0]
 	} else {
 		return fmt.Errorf("There are no sample commands give by the stack or the sample")
 	}
 
-	return nil
+	//A command that failed still has its output, the report of the scenario attaches it
+	process, err := c.runner.PostCommandToWorkspace(c.ctx, sampleCommand)
+	c.runner.SetProcess(process)
+
+	return err
+}
+
+//aCompatibleSampleForStackImportsAndBuilds lets the resolver pick the sample for stackName, imports it
+//into the workspace of the scenario, starting one when there is none yet, and builds it
+func (c *CheRunner) aCompatibleSampleForStackImportsAndBuilds(stackName string) error {
+	if len(c.runner.GetStackConfigMap()) == 0 {
+		if err := c.weTryToGetTheStacksInformation(); err != nil {
+			return err
+		}
+	}
+
+	match, candidates, err := c.runner.CompatibleSample(stackName)
+	for _, candidate := range candidates {
+		c.runner.Logf("%s", candidate.Explain())
+	}
+	if err != nil {
+		return err
+	}
+
+	if c.runner.GetWorkspaceID() == "" {
+		if err := c.startingAWorkspaceWithStackSucceeds(stackName); err != nil {
+			return err
+		}
+	}
+
+	if err := c.runner.AddSamplesToProject(c.ctx, []util.Sample{match.Sample}); err != nil {
+		return err
+	}
+
+	buildCommand, _ := util.BuildCommand(c.runner.GetStackConfigMap()[stackName], match.Sample)
+	process, err := c.runner.PostCommandToWorkspace(c.ctx, buildCommand)
+	c.runner.SetProcess(process)
+	if err != nil {
+		return err
+	}
+
+	return c.exitCodeShouldBe(0)
 }
 
+//exitCodeShouldBe checks the exit code of the last command. A long running command whose
+//readiness probe passed counts as a success since it has not exited.
 func (c *CheRunner) exitCodeShouldBe(code int) error {
-	if c.runner.PID != code {
-		return fmt.Errorf("return command was not 0")
+	process := c.runner.GetProcess()
+	if process.Pid == 0 {
+		return fmt.Errorf("No command has been run")
+	}
+
+	if process.Alive {
+		if process.Ready && code == 0 {
+			return nil
+		}
+		return fmt.Errorf("Command %q is still running after %s", process.CommandLine, process.Duration)
+	}
+
+	if process.ExitCode != code {
+		return fmt.Errorf("Command %q exited with %d after %s, expected %d. Output:\n%s", process.CommandLine, process.ExitCode, process.Duration, code, process.OutputText())
 	}
 	return nil
 }
 
 func (c *CheRunner) userStopsWorkspace() error {
-	err := c.runner.StopWorkspace(c.runner.WorkspaceID)
+	err := c.runner.StopWorkspace(c.ctx, c.runner.GetWorkspaceID())
 	if err != nil {
 		return err
 	}
@@ -139,7 +211,7 @@ func (c *CheRunner) userStopsWorkspace() error {
 }
 
 func (c *CheRunner) workspaceIsRemoved() error {
-	err := c.runner.RemoveWorkspace(c.runner.WorkspaceID)
+	err := c.runner.RemoveWorkspace(c.ctx, c.runner.GetWorkspaceID())
 	if err != nil {
 		return err
 	}
@@ -148,10 +220,85 @@ func (c *CheRunner) workspaceIsRemoved() error {
 
 func (c *CheRunner) workspaceRemovalShouldBeSuccessful() error {
 
-	err := c.runner.CheckWorkspaceDeletion(c.runner.WorkspaceID)
+	err := c.runner.CheckWorkspaceDeletion(c.ctx, c.runner.GetWorkspaceID())
 	if err != nil {
 		return err
 	}
 
 	return nil
 }
+
+func (c *CheRunner) creatingStackFromStackWithTagSucceeds(stackName, baseStackName, tag string) error {
+	base, ok := c.runner.GetStackConfigMap()[baseStackName]
+	if !ok {
+		return fmt.Errorf("Stack %q was not found", baseStackName)
+	}
+
+	stack := base
+	stack.ID = ""
+	stack.Name = stackName
+	stack.Tags = append(append([]string(nil), base.Tags...), tag)
+	stack.StackIcon = nil
+
+	created, err := c.runner.CreateStack(c.ctx, stack)
+	if err != nil {
+		return err
+	}
+	if c.createdStacks == nil {
+		c.createdStacks = make(map[string]string)
+	}
+	c.createdStacks[stackName] = created.ID
+
+	return nil
+}
+
+func (c *CheRunner) searchingTheStacksForTagReturnsStack(tag, stackName string) error {
+	found, err := c.searchStacks(tag, stackName)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return fmt.Errorf("Searching the stacks for tag %q did not return stack %q", tag, stackName)
+	}
+	return nil
+}
+
+func (c *CheRunner) searchingTheStacksForTagDoesNotReturnStack(tag, stackName string) error {
+	found, err := c.searchStacks(tag, stackName)
+	if err != nil {
+		return err
+	}
+	if found {
+		return fmt.Errorf("Searching the stacks for tag %q returned stack %q", tag, stackName)
+	}
+	return nil
+}
+
+//searchStacks tells whether searching the stacks for tag returns the stack stackName
+func (c *CheRunner) searchStacks(tag, stackName string) (bool, error) {
+	stacks, err := c.runner.SearchStacks(c.ctx, tag)
+	if err != nil {
+		return false, err
+	}
+
+	for _, stack := range stacks {
+		if stack.Name == stackName {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (c *CheRunner) deletingStackSucceeds(stackName string) error {
+	stackID, ok := c.createdStacks[stackName]
+	if !ok {
+		return fmt.Errorf("Stack %q was not created by this scenario", stackName)
+	}
+
+	if err := c.runner.DeleteStack(c.ctx, stackID); err != nil {
+		return err
+	}
+	delete(c.createdStacks, stackName)
+
+	return nil
+}
@@ -0,0 +1,229 @@
+/*
+Copyright (C) 2017 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/jpinkney/stack-tests/util"
+)
+
+const consoleJavaSimple = "https://github.com/che-samples/console-java-simple.git"
+
+//newRecordedRunner returns a runner with the stacks and samples of the fake server already loaded
+func newRecordedRunner(t *testing.T) (*CheRunner, *util.RecordingClient) {
+	recorder := util.NewRecordingClient(util.FakeStacks(), util.FakeSamples())
+	runner := &CheRunner{runner: recorder, ctx: context.Background()}
+	if err := runner.weTryToGetTheStacksInformation(); err != nil {
+		t.Fatal(err)
+	}
+	return runner, recorder
+}
+
+func TestUserRunsTheCommandOfTheSample(t *testing.T) {
+	runner, recorder := newRecordedRunner(t)
+	runner.runner.SetStackName("Java CentOS")
+
+	if err := runner.userRunsCommandOnSample(consoleJavaSimple); err != nil {
+		t.Fatal(err)
+	}
+
+	posted := recorder.CallsTo("PostCommandToWorkspace")
+	if len(posted) != 1 || posted[0].Args[0].(util.Command).Name != "console-java-simple:build" {
+		t.Errorf("expected the command of the sample, got %+v", posted)
+	}
+	if recorder.GetProcess().Pid == 0 {
+		t.Error("the process of the command should be kept for the exit code step")
+	}
+}
+
+func TestFailedCommandIsKeptForTheReport(t *testing.T) {
+	runner, recorder := newRecordedRunner(t)
+	runner.runner.SetStackName("Java CentOS")
+	recorder.Processes["mvn clean install -f ${current.project.path}"] = util.ProcessRecord{Alive: true, Output: []string{"[INFO] Building"}}
+	recorder.Errors["PostCommandToWorkspace"] = &util.ReadinessError{Command: "mvn clean install", Reason: "not ready within 2m0s"}
+
+	if err := runner.userRunsCommandOnSample(consoleJavaSimple); err == nil {
+		t.Fatal("expected the readiness failure")
+	}
+	if process := recorder.GetProcess(); process.Pid == 0 || process.OutputText() != "[INFO] Building" {
+		t.Errorf("the process of the failed command should be kept with its output, got %+v", process)
+	}
+
+	recorder.SetProcess(util.ProcessRecord{})
+	if err := runner.aCompatibleSampleForStackImportsAndBuilds("Java CentOS"); err == nil {
+		t.Fatal("expected the readiness failure of the build")
+	}
+	if recorder.GetProcess().Pid == 0 {
+		t.Error("the process of the failed build should be kept for the report")
+	}
+}
+
+func TestUserRunsTheCommandOfTheStackWhenTheSampleHasNone(t *testing.T) {
+	samples := util.FakeSamples()
+	for index := range samples {
+		samples[index].Commands = nil
+	}
+	recorder := util.NewRecordingClient(util.FakeStacks(), samples)
+	runner := &CheRunner{runner: recorder, ctx: context.Background()}
+	if err := runner.weTryToGetTheStacksInformation(); err != nil {
+		t.Fatal(err)
+	}
+
+	runner.runner.SetStackName("Java CentOS")
+	if err := runner.userRunsCommandOnSample(consoleJavaSimple); err != nil {
+		t.Fatal(err)
+	}
+	posted := recorder.CallsTo("PostCommandToWorkspace")
+	if len(posted) != 1 || posted[0].Args[0].(util.Command).Name != "build" {
+		t.Errorf("expected the command of the stack, got %+v", posted)
+	}
+
+	runner.runner.SetStackName("Unknown")
+	if err := runner.userRunsCommandOnSample(consoleJavaSimple); err == nil {
+		t.Error("without a sample or stack command the step should fail")
+	}
+}
+
+func TestWorkspaceStateMismatchListsTransitions(t *testing.T) {
+	runner, recorder := newRecordedRunner(t)
+
+	if err := runner.startingAWorkspaceWithStackSucceeds("Java CentOS"); err != nil {
+		t.Fatal(err)
+	}
+	if err := runner.workspaceShouldHaveState("running"); err != nil {
+		t.Error(err)
+	}
+
+	recorder.SetStatus(recorder.GetWorkspaceID(), "STOPPED")
+	err := runner.workspaceShouldHaveState("running")
+	if err == nil || !strings.Contains(err.Error(), "STARTING -> RUNNING -> STOPPED") {
+		t.Errorf("expected the transitions in the error, got %v", err)
+	}
+}
+
+func TestStartFailureKeepsTheWorkspaceForTeardown(t *testing.T) {
+	runner, recorder := newRecordedRunner(t)
+	recorder.Errors["GetHTTPAgents"] = fmt.Errorf("no agents")
+
+	if err := runner.startingAWorkspaceWithStackSucceeds("Java CentOS"); err == nil {
+		t.Fatal("expected the failure of GetHTTPAgents")
+	}
+	if recorder.GetWorkspaceID() == "" {
+		t.Error("the started workspace should still be known so it gets removed")
+	}
+	if len(recorder.CallsTo("SetAgentsURL")) != 0 {
+		t.Error("no agents should be set after a failure")
+	}
+}
+
+func TestCompatibleSampleImportsAndBuilds(t *testing.T) {
+	runner, recorder := newRecordedRunner(t)
+	recorder.Processes["mvn clean install -f ${current.project.path}"] = util.ProcessRecord{ExitCode: 1, Output: []string{"BUILD FAILURE"}}
+
+	err := runner.aCompatibleSampleForStackImportsAndBuilds("Java CentOS")
+	if err == nil || !strings.Contains(err.Error(), "BUILD FAILURE") {
+		t.Errorf("expected the failed build with its output, got %v", err)
+	}
+	if len(recorder.CallsTo("StartWorkspace")) != 1 || len(recorder.CallsTo("AddSamplesToProject")) != 1 {
+		t.Errorf("expected a workspace to be started and the sample imported, got %+v", recorder.Calls)
+	}
+
+	delete(recorder.Processes, "mvn clean install -f ${current.project.path}")
+	if err := runner.aCompatibleSampleForStackImportsAndBuilds("Java CentOS"); err != nil {
+		t.Fatal(err)
+	}
+	if len(recorder.CallsTo("StartWorkspace")) != 1 {
+		t.Error("the workspace of the scenario should be reused")
+	}
+}
+
+func TestExitCodeOfLongRunningCommands(t *testing.T) {
+	runner, recorder := newRecordedRunner(t)
+
+	if err := runner.exitCodeShouldBe(0); err == nil {
+		t.Error("without a command the step should fail")
+	}
+
+	recorder.SetProcess(util.ProcessRecord{Pid: 1, CommandLine: "mvn vertx:run", Alive: true, Ready: true})
+	if err := runner.exitCodeShouldBe(0); err != nil {
+		t.Errorf("a ready server counts as a success: %v", err)
+	}
+
+	recorder.SetProcess(util.ProcessRecord{Pid: 1, CommandLine: "mvn vertx:run", Alive: true})
+	if err := runner.exitCodeShouldBe(0); err == nil {
+		t.Error("a command that is still running and not ready should fail")
+	}
+}
+
+func TestStopAndRemoveWorkspace(t *testing.T) {
+	runner, _ := newRecordedRunner(t)
+
+	if err := runner.startingAWorkspaceWithStackSucceeds("Eclipse Vert.x"); err != nil {
+		t.Fatal(err)
+	}
+	if err := runner.userStopsWorkspace(); err != nil {
+		t.Fatal(err)
+	}
+	if err := runner.workspaceShouldHaveState("stopped"); err != nil {
+		t.Error(err)
+	}
+	if err := runner.workspaceRemovalShouldBeSuccessful(); err == nil {
+		t.Error("the workspace has not been removed yet")
+	}
+	if err := runner.workspaceIsRemoved(); err != nil {
+		t.Fatal(err)
+	}
+	if err := runner.workspaceRemovalShouldBeSuccessful(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestStackCatalogSteps(t *testing.T) {
+	runner, recorder := newRecordedRunner(t)
+
+	if err := runner.creatingStackFromStackWithTagSucceeds("Java CentOS stack-test", "Unknown", "stack-test"); err == nil {
+		t.Error("a stack cannot be based on a stack that does not exist")
+	}
+	if err := runner.creatingStackFromStackWithTagSucceeds("Java CentOS stack-test", "Java CentOS", "stack-test"); err != nil {
+		t.Fatal(err)
+	}
+	created := recorder.CallsTo("CreateStack")[0].Args[0].(util.Workspace)
+	if created.ID != "" || len(created.Tags) != 5 || created.Config.Name != "default" {
+		t.Errorf("expected a copy of Java CentOS with the tag, got %+v", created)
+	}
+
+	if err := runner.searchingTheStacksForTagReturnsStack("stack-test", "Java CentOS stack-test"); err != nil {
+		t.Error(err)
+	}
+	if err := runner.searchingTheStacksForTagReturnsStack("stack-test", "Java CentOS"); err == nil {
+		t.Error("the stack the new one is based on does not have the tag")
+	}
+
+	if err := runner.deletingStackSucceeds("Java CentOS"); err == nil {
+		t.Error("only the stacks of the scenario should be deleted")
+	}
+	if err := runner.deletingStackSucceeds("Java CentOS stack-test"); err != nil {
+		t.Fatal(err)
+	}
+	if err := runner.searchingTheStacksForTagDoesNotReturnStack("stack-test", "Java CentOS stack-test"); err != nil {
+		t.Error(err)
+	}
+}
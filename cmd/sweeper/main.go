@@ -0,0 +1,75 @@
+/*
+Copyright (C) 2017 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+//Command sweeper stops and removes the test workspaces crashed stack test runs left on a Che server.
+//It reads the same config as the suite, e.g.
+//
+//	sweeper -che.endpoint=http://che:8080/api -che.sweep-max-age=2h -che.sweep-dry-run=true
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/jpinkney/stack-tests/util"
+)
+
+func main() {
+	configFlags := util.RegisterConfigFlags(flag.CommandLine)
+	flag.Parse()
+
+	cfg, cfgErr := configFlags.Load()
+	if cfgErr != nil {
+		fmt.Fprintln(os.Stderr, cfgErr)
+		os.Exit(2)
+	}
+
+	cheAPI := cfg.NewCheAPI()
+	swept, sweepErr := cheAPI.SweepWorkspaces(context.Background(), cfg.Sweep)
+
+	action := "removed"
+	if cfg.Sweep.DryRun {
+		action = "would remove"
+	}
+	for _, workspace := range swept {
+		fmt.Printf("%s %s\n", action, describe(workspace))
+	}
+
+	if sweepErr != nil {
+		var failed *util.SweepError
+		if !errors.As(sweepErr, &failed) {
+			fmt.Fprintln(os.Stderr, sweepErr)
+			os.Exit(1)
+		}
+		for _, failure := range failed.Failures {
+			fmt.Fprintf(os.Stderr, "could not remove %s: %v\n", describe(failure.Workspace), failure.Err)
+		}
+		os.Exit(1)
+	}
+}
+
+//describe tells the ID, name, status and age of workspace
+func describe(workspace util.WorkspaceSummary) string {
+	age := "unknown age"
+	if created := workspace.Created(); !created.IsZero() {
+		age = time.Since(created).Round(time.Second).String() + " old"
+	}
+	return fmt.Sprintf("%s %s (%s, %s)", workspace.ID, workspace.Name(), workspace.Status, age)
+}
@@ -0,0 +1,21 @@
+@che @che7
+Feature: Che add-on
+  Che addon starts Eclipse Che from the devfiles of its registry
+
+  Scenario Outline: User starts devfile workspace, imports projects, checks run commands
+    When we try to get the stacks information
+    Then the stacks should not be empty
+    When starting a workspace with stack "<stack>" succeeds
+    Then workspace should have state "RUNNING"
+    When importing the sample project "<sample>" succeeds
+    Then workspace should have 1 project
+    When user runs command on sample "<sample>"
+    Then exit code should be 0
+    When user stops workspace
+    Then workspace should have state "STOPPED"
+    When workspace is removed
+    Then workspace removal should be successful
+
+    Examples:
+    | stack                 | sample                                                                   |
+    | Java Maven            | https://github.com/che-samples/console-java-simple.git                   |
@@ -0,0 +1,16 @@
+@che @che6
+Feature: Compatible samples
+  Samples are picked from the catalog by the stack they run on
+
+  Scenario Outline: User builds the sample the resolver picks for a stack
+    When we try to get the stacks information
+    Then the stacks should not be empty
+    Then a compatible sample for stack "<stack>" imports and builds
+    When user stops workspace
+    Then workspace should have state "STOPPED"
+    When workspace is removed
+    Then workspace removal should be successful
+
+    Examples:
+    | stack                 |
+    | Java CentOS           |
@@ -0,0 +1,10 @@
+@che @che5 @che6
+Feature: Stack catalog
+  Stacks can be added to and removed from the catalog of the server
+
+  Scenario: User creates a stack and finds it by its tag
+    When we try to get the stacks information
+    Then creating stack "Java CentOS stack-test" from stack "Java CentOS" with tag "stack-test" succeeds
+    Then searching the stacks for tag "stack-test" returns stack "Java CentOS stack-test"
+    When deleting stack "Java CentOS stack-test" succeeds
+    Then searching the stacks for tag "stack-test" does not return stack "Java CentOS stack-test"
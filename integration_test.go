@@ -17,38 +17,248 @@ limitations under the License.
 package main
 
 import (
+	"context"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
 	"os"
 	"testing"
+	"time"
 
 	"github.com/DATA-DOG/godog"
+	"github.com/DATA-DOG/godog/gherkin"
 
 	"github.com/jpinkney/stack-tests/util"
 )
 
+var configFlags = util.RegisterConfigFlags(flag.CommandLine)
+
+var suiteConfig util.Config
+
+var suiteReport *util.Report
+
+//suiteLocalStacks are the local stacks every scenario adds to the stacks of the server
+var suiteLocalStacks []util.Workspace
+
+var suiteMetrics = util.NewMetrics()
+
+//teardownTimeout bounds cleaning up after a scenario, which also runs when the scenario ran out of time
+const teardownTimeout = 5 * time.Minute
+
 func TestMain(m *testing.M) {
+	flag.Parse()
+
+	//go test -short only runs the unit tests of the steps, which need no Che server
+	if testing.Short() {
+		os.Exit(m.Run())
+	}
+
+	os.Exit(runSuite(m))
+}
+
+//runSuite runs the features and the tests and returns the exit code. Whatever it set up is
+//cleaned up on every return, also when it could not get as far as running the features.
+func runSuite(m *testing.M) int {
+	cfg, cfgErr := configFlags.Load()
+	if cfgErr != nil {
+		fmt.Fprintln(os.Stderr, cfgErr)
+		return 2
+	}
+	suiteConfig = cfg
+
+	//Run the whole suite offline against an in-process fake server
+	if suiteConfig.Fake != 0 {
+		fake := util.NewFakeChe(suiteConfig.Fake)
+		defer fake.Close()
+		suiteConfig = fake.Config(suiteConfig)
+		if suiteConfig.Tags == "" && !suiteConfig.Matrix.Generate {
+			suiteConfig.Tags = fmt.Sprintf("@che%d", suiteConfig.Fake)
+		}
+	}
+
+	//Detect the version once, the scenarios get the client for it from the config.
+	//Without it every scenario tries to detect it again and reports why it could not.
+	cheVersion := suiteConfig.CheVersion
+	if cheVersion == "" {
+		detector := suiteConfig.NewCheAPI()
+		if version, detectErr := detector.DetectVersion(context.Background()); detectErr != nil {
+			fmt.Fprintln(os.Stderr, detectErr)
+		} else {
+			cheVersion = version
+			suiteConfig.CheVersion = detector.CheVersion
+		}
+	}
+
+	//Clean up what crashed runs left behind before starting new workspaces
+	if suiteConfig.Sweep.Before && suiteConfig.Sweep.MaxAge.Duration == 0 {
+		fmt.Fprintln(os.Stderr, "Not sweeping before the run: without sweep.maxAge the workspaces of runs in progress would be removed too")
+	} else if suiteConfig.Sweep.Before {
+		sweeper := suiteConfig.NewCheAPI()
+		if _, sweepErr := sweeper.SweepWorkspaces(context.Background(), suiteConfig.Sweep); sweepErr != nil {
+			fmt.Fprintln(os.Stderr, sweepErr)
+		}
+	}
+
+	//Stacks that are not on the server yet are tested by name next to the ones that are
+	var localStacks []util.LocalStack
+	if suiteConfig.LocalStacks.Dir != "" {
+		loaded, loadErr := util.LoadStackDir(suiteConfig.LocalStacks.Dir)
+		if loadErr != nil {
+			fmt.Fprintln(os.Stderr, loadErr)
+			return 2
+		}
+		localStacks = loaded
+	}
 
+	featurePaths := suiteConfig.Features
+
+	//Test every stack in the server's catalog instead of the hand written features
+	if suiteConfig.Matrix.Generate {
+		matrixDir, dirErr := ioutil.TempDir("", "stack-matrix")
+		if dirErr != nil {
+			fmt.Fprintln(os.Stderr, dirErr)
+			return 2
+		}
+		defer os.RemoveAll(matrixDir)
+		generator := suiteConfig.NewCheAPI()
+		generator.LocalStacks = util.StackDefinitions(localStacks)
+		entries, matrixErr := generator.GenerateMatrix(context.Background(), suiteConfig.Matrix, matrixDir)
+		if matrixErr != nil {
+			fmt.Fprintln(os.Stderr, matrixErr)
+			return 2
+		}
+		generator.Logger.Printf("Testing %d stack and sample pairs from the server catalogs", len(entries))
+		featurePaths = []string{matrixDir}
+	}
+
+	//godog runs features concurrently, so give every scenario a feature of its own
+	if suiteConfig.Concurrency > 1 {
+		splitDir, dirErr := ioutil.TempDir("", "stack-tests")
+		if dirErr != nil {
+			fmt.Fprintln(os.Stderr, dirErr)
+			return 2
+		}
+		defer os.RemoveAll(splitDir)
+		if _, splitErr := util.SplitFeatures(featurePaths, splitDir); splitErr != nil {
+			fmt.Fprintln(os.Stderr, splitErr)
+			return 2
+		}
+		featurePaths = []string{splitDir}
+	}
+
+	//Registered stacks are on the server for the run, the others are only added to what it lists
+	var registeredStacks []util.Workspace
+	if suiteConfig.LocalStacks.Register && len(localStacks) > 0 {
+		registrar := suiteConfig.NewCheAPI()
+		registered, registerErr := registrar.RegisterStacks(context.Background(), localStacks)
+		if registerErr != nil {
+			fmt.Fprintln(os.Stderr, registerErr)
+			return 2
+		}
+		registeredStacks = registered
+	} else {
+		suiteLocalStacks = util.StackDefinitions(localStacks)
+	}
+
+	suiteReport = util.NewReport(suiteConfig.CheAPIEndpoint)
+	suiteReport.CheVersion = cheVersion
+	if source, sourceErr := util.ParseSamplesSource(suiteConfig.SamplesURL); sourceErr == nil {
+		suiteReport.SamplesSource = source.String()
+	}
 	status := godog.RunWithOptions("godog", func(s *godog.Suite) {
 		FeatureContext(s)
 	}, godog.Options{
-		Format: "progress",
+		Format:      suiteConfig.Format,
+		Paths:       featurePaths,
+		Tags:        suiteConfig.Tags,
+		Concurrency: suiteConfig.Concurrency,
 	})
 
 	if st := m.Run(); st > status {
 		status = st
 	}
-	os.Exit(status)
+
+	suiteReport.Finish()
+	if reportErr := suiteReport.WriteFiles(suiteConfig.JUnitReport, suiteConfig.JSONReport); reportErr != nil {
+		fmt.Fprintln(os.Stderr, reportErr)
+		if status == 0 {
+			status = 2
+		}
+	}
+	if metricsErr := suiteMetrics.WriteFiles(suiteConfig.MetricsCSV, suiteConfig.MetricsPrometheus); metricsErr != nil {
+		fmt.Fprintln(os.Stderr, metricsErr)
+		if status == 0 {
+			status = 2
+		}
+	}
+
+	if len(registeredStacks) > 0 {
+		registrar := suiteConfig.NewCheAPI()
+		if unregisterErr := registrar.UnregisterStacks(context.Background(), registeredStacks); unregisterErr != nil {
+			fmt.Fprintln(os.Stderr, unregisterErr)
+			if status == 0 {
+				status = 2
+			}
+		}
+	}
+
+	return status
 }
 
 func FeatureContext(s *godog.Suite) {
 
-	// steps for testing che addon
-	cheAPI := util.CheAPI{
-		CheAPIEndpoint: "http://localhost:8081/api",
-	}
+	//steps for testing che addon, every concurrently running feature gets its own runner
+	cheAPIRunner := &CheRunner{}
+	var cheAPI *util.CheAPI
 
-	cheAPIRunner := &CheRunner{
-		runner: cheAPI,
-	}
+	var featureName string
+	var caseReport *util.CaseReport
+	var cancelScenario context.CancelFunc
+
+	s.BeforeFeature(func(feature *gherkin.Feature) {
+		featureName = feature.Name
+	})
+
+	//Every scenario starts from a fresh CheAPI so nothing leaks from the scenario before it.
+	//The log output is prefixed with the scenario so concurrent scenarios can be told apart.
+	s.BeforeScenario(func(scenario interface{}) {
+		api := suiteConfig.NewCheAPI()
+		api.Logger = log.New(os.Stderr, "["+scenarioName(scenario)+"] ", log.LstdFlags)
+		api.Metrics = suiteMetrics
+		api.LocalStacks = suiteLocalStacks
+		cheAPI = &api
+		cheAPIRunner.runner = cheAPI
+		cheAPIRunner.createdStacks = nil
+
+		//Requests and waits still going when the scenario is out of time are aborted
+		if suiteConfig.ScenarioTimeout.Duration > 0 {
+			cheAPIRunner.ctx, cancelScenario = context.WithTimeout(context.Background(), suiteConfig.ScenarioTimeout.Duration)
+		} else {
+			cheAPIRunner.ctx, cancelScenario = context.WithCancel(context.Background())
+		}
+		caseReport = suiteReport.StartCase(featureName, scenarioName(scenario))
+	})
+
+	s.BeforeStep(func(step *gherkin.Step) {
+		caseReport.StartStep()
+	})
+
+	s.AfterStep(func(step *gherkin.Step, err error) {
+		caseReport.EndStep(step.Text, err)
+	})
+
+	//Report the scenario, then stop and remove whatever it created, whether it passed or not
+	s.AfterScenario(func(scenario interface{}, err error) {
+		cancelScenario()
+		caseReport.End(cheAPI, err)
+
+		ctx, cancel := context.WithTimeout(context.Background(), teardownTimeout)
+		defer cancel()
+		if teardownErr := cheAPI.Teardown(ctx); teardownErr != nil {
+			cheAPI.Logger.Println(teardownErr)
+		}
+	})
 
 	s.Step(`^we try to get the stacks information$`, cheAPIRunner.weTryToGetTheStacksInformation)
 	s.Step(`^the stacks should not be empty$`, cheAPIRunner.theStacksShouldNotBeEmpty)
@@ -58,8 +268,24 @@ func FeatureContext(s *godog.Suite) {
 	s.Step(`^workspace should have (\d+) project$`, cheAPIRunner.workspaceShouldHaveProject)
 	s.Step(`^user runs command on sample "([^"]*)"$`, cheAPIRunner.userRunsCommandOnSample)
 	s.Step(`^exit code should be (\d+)$`, cheAPIRunner.exitCodeShouldBe)
+	s.Step(`^a compatible sample for stack "([^"]*)" imports and builds$`, cheAPIRunner.aCompatibleSampleForStackImportsAndBuilds)
+	s.Step(`^creating stack "([^"]*)" from stack "([^"]*)" with tag "([^"]*)" succeeds$`, cheAPIRunner.creatingStackFromStackWithTagSucceeds)
+	s.Step(`^searching the stacks for tag "([^"]*)" returns stack "([^"]*)"$`, cheAPIRunner.searchingTheStacksForTagReturnsStack)
+	s.Step(`^searching the stacks for tag "([^"]*)" does not return stack "([^"]*)"$`, cheAPIRunner.searchingTheStacksForTagDoesNotReturnStack)
+	s.Step(`^deleting stack "([^"]*)" succeeds$`, cheAPIRunner.deletingStackSucceeds)
 	s.Step(`^user stops workspace$`, cheAPIRunner.userStopsWorkspace)
 	s.Step(`^workspace is removed$`, cheAPIRunner.workspaceIsRemoved)
 	s.Step(`^workspace removal should be successful$`, cheAPIRunner.workspaceRemovalShouldBeSuccessful)
 
 }
+
+//scenarioName returns the name of the scenario or scenario outline godog passes to hooks
+func scenarioName(scenario interface{}) string {
+	switch sc := scenario.(type) {
+	case *gherkin.Scenario:
+		return sc.Name
+	case *gherkin.ScenarioOutline:
+		return sc.Name
+	}
+	return ""
+}
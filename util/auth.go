@@ -0,0 +1,191 @@
+/*
+Copyright (C) 2017 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+//tokenExpiryLeeway is how long before the reported expiry a token is considered expired
+const tokenExpiryLeeway = 30 * time.Second
+
+//TokenSource provides the bearer token sent on every request to the Che master, wsagent and exec agent
+type TokenSource interface {
+	Token() (string, error)
+}
+
+//StaticToken is a TokenSource that always returns the same token
+type StaticToken string
+
+//Token returns the static token
+func (t StaticToken) Token() (string, error) {
+	return string(t), nil
+}
+
+//OIDCTokenSource gets tokens from a Keycloak/OIDC token endpoint and refreshes them when they expire.
+//When Username is set the password grant is used, otherwise the client credentials grant.
+type OIDCTokenSource struct {
+	TokenURL     string
+	ClientID     string
+	ClientSecret string
+	Username     string
+	Password     string
+
+	mu                 sync.Mutex
+	accessToken        string
+	accessTokenExpiry  time.Time
+	refreshToken       string
+	refreshTokenExpiry time.Time
+}
+
+type oidcTokenResponse struct {
+	AccessToken      string `json:"access_token"`
+	ExpiresIn        int    `json:"expires_in"`
+	RefreshToken     string `json:"refresh_token"`
+	RefreshExpiresIn int    `json:"refresh_expires_in"`
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+//Token returns a valid access token, logging in or refreshing when needed
+func (o *OIDCTokenSource) Token() (string, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	now := time.Now()
+	if o.accessToken != "" && now.Add(tokenExpiryLeeway).Before(o.accessTokenExpiry) {
+		return o.accessToken, nil
+	}
+
+	if o.refreshToken != "" && (o.refreshTokenExpiry.IsZero() || now.Add(tokenExpiryLeeway).Before(o.refreshTokenExpiry)) {
+		refreshErr := o.requestToken(url.Values{
+			"grant_type":    {"refresh_token"},
+			"refresh_token": {o.refreshToken},
+		})
+		if refreshErr == nil {
+			return o.accessToken, nil
+		}
+		//The refresh token may have been revoked, so fall through to a full login
+	}
+
+	var form url.Values
+	if o.Username != "" {
+		form = url.Values{
+			"grant_type": {"password"},
+			"username":   {o.Username},
+			"password":   {o.Password},
+		}
+	} else {
+		form = url.Values{
+			"grant_type": {"client_credentials"},
+		}
+	}
+
+	if loginErr := o.requestToken(form); loginErr != nil {
+		return "", loginErr
+	}
+
+	return o.accessToken, nil
+}
+
+//requestToken posts form to the token endpoint and stores the tokens it returns
+func (o *OIDCTokenSource) requestToken(form url.Values) error {
+	form.Set("client_id", o.ClientID)
+	if o.ClientSecret != "" {
+		form.Set("client_secret", o.ClientSecret)
+	}
+
+	client := http.Client{
+		Timeout: time.Second * 60,
+	}
+
+	res, postErr := client.Post(o.TokenURL, "application/x-www-form-urlencoded", strings.NewReader(form.Encode()))
+	if postErr != nil {
+		return postErr
+	}
+	defer res.Body.Close()
+
+	body, readErr := ioutil.ReadAll(res.Body)
+	if readErr != nil {
+		return readErr
+	}
+
+	var tokenResponse oidcTokenResponse
+	jsonErr := json.Unmarshal(body, &tokenResponse)
+
+	if res.StatusCode != http.StatusOK {
+		if jsonErr == nil && tokenResponse.Error != "" {
+			return fmt.Errorf("Token request with %s grant failed: %s %s", form.Get("grant_type"), tokenResponse.Error, tokenResponse.ErrorDescription)
+		}
+		return fmt.Errorf("Token request with %s grant failed with status %d", form.Get("grant_type"), res.StatusCode)
+	}
+
+	if jsonErr != nil {
+		return jsonErr
+	}
+
+	if tokenResponse.AccessToken == "" {
+		return fmt.Errorf("Token endpoint did not return an access token")
+	}
+
+	now := time.Now()
+	o.accessToken = tokenResponse.AccessToken
+	o.accessTokenExpiry = now.Add(time.Duration(tokenResponse.ExpiresIn) * time.Second)
+	o.refreshToken = tokenResponse.RefreshToken
+	o.refreshTokenExpiry = time.Time{}
+	if tokenResponse.RefreshExpiresIn > 0 {
+		o.refreshTokenExpiry = now.Add(time.Duration(tokenResponse.RefreshExpiresIn) * time.Second)
+	}
+
+	return nil
+}
+
+//AuthConfig configures how the suite authenticates against a multi user Che
+type AuthConfig struct {
+	Token        string `json:"token" yaml:"token"`
+	TokenURL     string `json:"tokenURL" yaml:"tokenURL"`
+	ClientID     string `json:"clientID" yaml:"clientID"`
+	ClientSecret string `json:"clientSecret" yaml:"clientSecret"`
+	Username     string `json:"username" yaml:"username"`
+	Password     string `json:"password" yaml:"password"`
+}
+
+//TokenSource returns the TokenSource described by the config, or nil when no auth is configured
+func (a AuthConfig) TokenSource() TokenSource {
+	if a.Token != "" {
+		return StaticToken(a.Token)
+	}
+
+	if a.TokenURL != "" {
+		return &OIDCTokenSource{
+			TokenURL:     a.TokenURL,
+			ClientID:     a.ClientID,
+			ClientSecret: a.ClientSecret,
+			Username:     a.Username,
+			Password:     a.Password,
+		}
+	}
+
+	return nil
+}
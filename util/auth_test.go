@@ -0,0 +1,200 @@
+/*
+Copyright (C) 2017 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+)
+
+//fakeTokenEndpoint is a minimal Keycloak token endpoint that hands out numbered tokens
+type fakeTokenEndpoint struct {
+	mu        sync.Mutex
+	grants    []string
+	issued    int
+	expiresIn int
+}
+
+func (f *fakeTokenEndpoint) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	r.ParseForm()
+	grant := r.PostForm.Get("grant_type")
+	f.grants = append(f.grants, grant)
+
+	if r.PostForm.Get("client_id") != "che-public" {
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(map[string]string{"error": "unauthorized_client", "error_description": "unknown client"})
+		return
+	}
+
+	if grant == "password" && r.PostForm.Get("password") != "secret" {
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant", "error_description": "Invalid user credentials"})
+		return
+	}
+
+	f.issued++
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"access_token":       fmt.Sprintf("token-%d", f.issued),
+		"expires_in":         f.expiresIn,
+		"refresh_token":      fmt.Sprintf("refresh-%d", f.issued),
+		"refresh_expires_in": 1800,
+	})
+}
+
+func TestOIDCPasswordGrantAndRefresh(t *testing.T) {
+	//Tokens that expire within the leeway are refreshed on every call
+	endpoint := &fakeTokenEndpoint{expiresIn: 1}
+	server := httptest.NewServer(endpoint)
+	defer server.Close()
+
+	source := &OIDCTokenSource{TokenURL: server.URL, ClientID: "che-public", Username: "admin", Password: "secret"}
+
+	first, err := source.Token()
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := source.Token()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if first != "token-1" || second != "token-2" {
+		t.Errorf("unexpected tokens %s, %s", first, second)
+	}
+	if len(endpoint.grants) != 2 || endpoint.grants[0] != "password" || endpoint.grants[1] != "refresh_token" {
+		t.Errorf("unexpected grants %v", endpoint.grants)
+	}
+}
+
+func TestOIDCTokenIsCached(t *testing.T) {
+	endpoint := &fakeTokenEndpoint{expiresIn: 300}
+	server := httptest.NewServer(endpoint)
+	defer server.Close()
+
+	source := &OIDCTokenSource{TokenURL: server.URL, ClientID: "che-public", ClientSecret: "s3cr3t"}
+
+	for i := 0; i < 3; i++ {
+		token, err := source.Token()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if token != "token-1" {
+			t.Errorf("expected the cached token, got %s", token)
+		}
+	}
+
+	if len(endpoint.grants) != 1 || endpoint.grants[0] != "client_credentials" {
+		t.Errorf("unexpected grants %v", endpoint.grants)
+	}
+}
+
+func TestCheAPIsShareTheLogin(t *testing.T) {
+	endpoint := &fakeTokenEndpoint{expiresIn: 300}
+	server := httptest.NewServer(endpoint)
+	defer server.Close()
+
+	os.Setenv("CHE_TEST_AUTH_TOKEN_URL", server.URL)
+	os.Setenv("CHE_TEST_AUTH_CLIENT_ID", "che-public")
+	defer os.Unsetenv("CHE_TEST_AUTH_TOKEN_URL")
+	defer os.Unsetenv("CHE_TEST_AUTH_CLIENT_ID")
+
+	cfg, err := RegisterConfigFlags(flag.NewFlagSet("test", flag.ContinueOnError)).Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 3; i++ {
+		c := cfg.NewCheAPI()
+		if _, err := c.Auth.Token(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(endpoint.grants) != 1 {
+		t.Errorf("expected a single login for every CheAPI, got %v", endpoint.grants)
+	}
+}
+
+func TestOIDCLoginFailure(t *testing.T) {
+	server := httptest.NewServer(&fakeTokenEndpoint{expiresIn: 300})
+	defer server.Close()
+
+	source := &OIDCTokenSource{TokenURL: server.URL, ClientID: "che-public", Username: "admin", Password: "wrong"}
+	if _, err := source.Token(); err == nil {
+		t.Error("expected the login to fail")
+	}
+}
+
+func TestBearerTokenIsSentOnRequests(t *testing.T) {
+	var authHeaders []string
+	var mu sync.Mutex
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		authHeaders = append(authHeaders, r.Header.Get("Authorization"))
+		mu.Unlock()
+		w.Write([]byte(`[]`))
+	}))
+	defer server.Close()
+
+	c := CheAPI{CheAPIEndpoint: server.URL, WSAgentURL: server.URL, Auth: StaticToken("abc")}
+
+	if _, err := c.GetStackInformation(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.GetNumberOfProjects(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, header := range authHeaders {
+		if header != "Bearer abc" {
+			t.Errorf("unexpected Authorization header %q", header)
+		}
+	}
+}
+
+func TestBearerTokenIsOnlySentToChe(t *testing.T) {
+	var catalogAuth string
+	catalog := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		catalogAuth = r.Header.Get("Authorization")
+		w.Write([]byte(`[]`))
+	}))
+	defer catalog.Close()
+
+	fake, c := newFakeCheAPI(6)
+	defer fake.Close()
+	c.Auth = StaticToken("abc")
+	c.SamplesURL = catalog.URL
+
+	if c.isCheURL(catalog.URL) || !c.isCheURL(c.CheAPIEndpoint+"/stack") {
+		t.Errorf("only the Che master %s should get the token", c.CheAPIEndpoint)
+	}
+	if _, err := c.GetSamplesInformation(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if catalogAuth != "" {
+		t.Errorf("the token leaked to the samples catalog: %q", catalogAuth)
+	}
+}
@@ -0,0 +1,168 @@
+/*
+Copyright (C) 2017 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStuckWorkspaceStartIsAborted(t *testing.T) {
+	fake, c := newFakeCheAPI(6)
+	defer fake.Close()
+
+	//A workspace that never leaves STARTING
+	fake.SetStartTransitions(FakeTransition{Status: "STARTING"})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	started := time.Now()
+	stack := FakeStacks()[0]
+	_, err := c.StartWorkspace(ctx, stack.Config.EnvironmentConfig, stack.ID)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected the deadline to abort the start, got %v", err)
+	}
+	if time.Since(started) > time.Second {
+		t.Errorf("the start should have been aborted at the deadline, took %s", time.Since(started))
+	}
+}
+
+func TestWaitForStatus(t *testing.T) {
+	fake, c := newFakeCheAPI(6)
+	defer fake.Close()
+
+	stack := FakeStacks()[0]
+	workspace, err := c.StartWorkspace(context.Background(), stack.Config.EnvironmentConfig, stack.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, err := c.doRequest(context.Background(), http.MethodDelete, c.CheAPIEndpoint+"/workspace/"+workspace.ID+"/runtime", ""); err != nil {
+		t.Fatal(err)
+	}
+	status, err := c.WaitForStatus(context.Background(), workspace.ID, []string{"STOPPED"}, nil, 0)
+	if err != nil || status.WorkspaceStatus != "STOPPED" {
+		t.Errorf("expected STOPPED, got %+v %v", status, err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := c.WaitForStatus(ctx, workspace.ID, []string{"RUNNING"}, nil, 0); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("a status that never comes should end at the deadline, got %v", err)
+	}
+}
+
+func TestWaitForStatusFailsFast(t *testing.T) {
+	fake, c := newFakeCheAPI(6)
+	defer fake.Close()
+
+	//A start that fails long before the timeout
+	fake.SetStartTransitions(
+		FakeTransition{Status: "STARTING"},
+		FakeTransition{Status: "STOPPED", After: 30 * time.Millisecond, Error: "Unrecoverable event occurred: 'OOMKilled'"},
+	)
+
+	started := time.Now()
+	stack := FakeStacks()[0]
+	workspace, err := c.StartWorkspace(context.Background(), stack.Config.EnvironmentConfig, stack.ID)
+
+	statusErr, ok := err.(*WorkspaceStatusError)
+	if !ok {
+		t.Fatalf("expected a WorkspaceStatusError, got %v", err)
+	}
+	if time.Since(started) > time.Second {
+		t.Errorf("the failed start should have ended straight away, took %s", time.Since(started))
+	}
+	if statusErr.WorkspaceID != workspace.ID || statusErr.Status != "STOPPED" {
+		t.Errorf("unexpected workspace and status in %+v", statusErr)
+	}
+	if statusErr.Message != "Unrecoverable event occurred: 'OOMKilled'" || statusErr.Attributes[StoppedAbnormallyAttribute] != "true" {
+		t.Errorf("expected the error and attributes of the workspace, got %+v", statusErr)
+	}
+	if !strings.Contains(err.Error(), "became STOPPED instead of RUNNING: Unrecoverable event occurred") ||
+		!strings.Contains(err.Error(), "STARTING -> STOPPED") {
+		t.Errorf("unexpected message %q", err.Error())
+	}
+}
+
+func TestWaitForStatusTimeout(t *testing.T) {
+	fake, c := newFakeCheAPI(6)
+	defer fake.Close()
+
+	//A workspace that never leaves STARTING
+	fake.SetStartTransitions(FakeTransition{Status: "STARTING"})
+	c.StartTimeout = 50 * time.Millisecond
+
+	stack := FakeStacks()[0]
+	_, err := c.StartWorkspace(context.Background(), stack.Config.EnvironmentConfig, stack.ID)
+
+	statusErr, ok := err.(*WorkspaceStatusError)
+	if !ok {
+		t.Fatalf("expected a WorkspaceStatusError, got %v", err)
+	}
+	if statusErr.Status != "STARTING" || !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected the start to time out in STARTING, got %+v", statusErr)
+	}
+	if !strings.Contains(err.Error(), "did not become RUNNING within 50ms") {
+		t.Errorf("unexpected message %q", err.Error())
+	}
+}
+
+func TestRunningProcessWaitIsCancelled(t *testing.T) {
+	for _, version := range []int{5, 6} {
+		fake, c := newFakeCheAPI(version)
+		startFakeWorkspace(t, fake, &c)
+
+		fake.SetProcess("tail -f /dev/null", FakeProcess{Forever: true})
+		process, err := c.StartProcess(context.Background(), Command{Name: "tail", CommandLine: "tail -f /dev/null"})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		time.AfterFunc(50*time.Millisecond, cancel)
+
+		if _, err := c.WaitForProcess(ctx, process); !errors.Is(err, context.Canceled) {
+			t.Errorf("Che%d: expected the wait to be cancelled, got %v", version, err)
+		}
+		fake.Close()
+	}
+}
+
+func TestCancelledRequestsAreNotRetried(t *testing.T) {
+	fake, c := newFakeCheAPI(6)
+	defer fake.Close()
+
+	fake.Fail(http.MethodGet, "/api/stack", http.StatusServiceUnavailable, "Service unavailable", 100)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Millisecond)
+	defer cancel()
+	c.Retry = RetryPolicy{MaxAttempts: 100, InitialBackoff: Duration{time.Second}, MaxBackoff: Duration{time.Second}}
+
+	started := time.Now()
+	if _, err := c.GetStackInformation(ctx); !IsStatus(err, http.StatusServiceUnavailable) {
+		t.Errorf("expected the last failure, got %v", err)
+	}
+	if time.Since(started) > 500*time.Millisecond {
+		t.Errorf("the backoff should end with the context, took %s", time.Since(started))
+	}
+}
@@ -18,22 +18,36 @@ package util
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
+	"log"
 	"net/http"
+	neturl "net/url"
 	"regexp"
 	"strconv"
+	"strings"
 	"time"
 )
 
 type Workspace struct {
-	ID      string              `json:"id"`
-	Config  WorkspaceConfig     `json:"workspaceConfig"`
-	Source  WorkspaceSourceType `json:"source"`
-	Tags    []string            `json:"tags"`
-	Command []Command           `json:"commands,omitempty"`
-	Name    string              `json:"name"`
+	ID          string              `json:"id"`
+	Config      WorkspaceConfig     `json:"workspaceConfig"`
+	Source      WorkspaceSourceType `json:"source"`
+	Tags        []string            `json:"tags"`
+	Components  []StackComponent    `json:"components,omitempty"`
+	Command     []Command           `json:"commands,omitempty"`
+	Name        string              `json:"name"`
+	Description string              `json:"description,omitempty"`
+	Scope       string              `json:"scope,omitempty"`
+	Creator     string              `json:"creator,omitempty"`
+	StackIcon   *StackIcon          `json:"stackIcon,omitempty"`
+	//Devfile is set on the stacks of a Che7 devfile registry
+	Devfile *Devfile `json:"-"`
+	//raw is the JSON the stack was read from, see MarshalJSON
+	raw json.RawMessage
 }
 
 type Workspace2 struct {
@@ -104,17 +118,25 @@ type Che5Runtime struct {
 }
 
 type Servers struct {
-	Servers map[string]ServerURL `json:"servers"`
+	Servers    map[string]ServerURL `json:"servers"`
+	Attributes map[string]string    `json:"attributes,omitempty"`
 }
 
 type ServerURL struct {
-	URL string `json:"url"`
-	Ref string `json:"ref,omitempty"`
+	URL        string            `json:"url"`
+	Ref        string            `json:"ref,omitempty"`
+	Attributes map[string]string `json:"attributes,omitempty"`
 }
 
 type Agent struct {
-	execAgentURL string
-	wsAgentURL   string
+	execAgentURL   string
+	execAgentWSURL string
+	wsAgentURL     string
+	machineExecURL string
+	editorURL      string
+	devMachine     string
+	servers        map[string]ServerURL
+	cheVersion     string
 }
 
 type ProcessStruct struct {
@@ -162,117 +184,201 @@ type WorkspaceStatus struct {
 }
 
 type CheAPI struct {
-	CheAPIEndpoint string
-	WorkspaceID    string
-	ExecAgentURL   string
-	WSAgentURL     string
-	PID            int
-	StackName      string
+	CheAPIEndpoint        string
+	SamplesURL            string
+	Namespace             string
+	RequestTimeout        time.Duration
+	WorkspacePollInterval time.Duration
+	ProcessPollInterval   time.Duration
+	StartTimeout          time.Duration
+	Auth                  TokenSource
+	WorkspaceID           string
+	ExecAgentURL          string
+	ExecAgentWSURL        string
+	WSAgentURL            string
+	MachineExecURL        string
+	EditorURL             string
+	DevMachine            string
+	DevfileRegistryURL    string
+	PluginRegistryURL     string
+	LocalStacks           []Workspace
+	Servers               map[string]ServerURL
+	Readiness             map[string]ReadinessProbe
+	Process               ProcessRecord
+	StackName             string
+	CurrentProject        string
+	SampleLocation        string
+	CheVersion            string
+	CheBuild              string
+	Metrics               *Metrics
+	Budgets               []Budget
+	Retry                 RetryPolicy
+	StatusHistory         []WorkspaceEvent
+	Logger                *log.Logger
+	masterWSUnavailable   bool
+	versioned             VersionedClient
+	stackConfigMap        map[string]Workspace
+	sampleConfigMap       map[string]Sample
+	createdWorkspaces     []string
+	createdStacks         []string
 }
 
 var samples = "https://raw.githubusercontent.com/eclipse/che/master/ide/che-core-ide-templates/src/main/resources/samples.json"
-var stackConfigMap map[string]Workspace
-var sampleConfigMap map[string]Sample
 
-//doRequest does an new request with type requestType on url with data
-func doRequest(requestType, url, data string) ([]byte, int, error) {
+//doRequest does an new request with type requestType on url with data, retrying transient failures as c.Retry allows.
+//Failed requests and error statuses are returned as a CheAPIError.
+func (c *CheAPI) doRequest(ctx context.Context, requestType, url, data string) ([]byte, int, error) {
+	body, _, statusCode, err := c.doContentRequest(ctx, requestType, url, "application/json", []byte(data))
+	return body, statusCode, err
+}
+
+//doContentRequest is doRequest for data of any contentType, it also returns the headers of the response.
+//The bearer token of c.Auth is only sent when url is on the Che master or one of the agents of the workspace.
+func (c *CheAPI) doContentRequest(ctx context.Context, requestType, url, contentType string, data []byte) ([]byte, http.Header, int, error) {
+	return c.sendRequest(ctx, requestType, url, contentType, data, c.isCheURL(url))
+}
+
+//doPublicRequest GETs url without the credentials of the user, for the catalogs and registries that are not part of Che
+func (c *CheAPI) doPublicRequest(ctx context.Context, url string) ([]byte, int, error) {
+	body, _, statusCode, err := c.sendRequest(ctx, http.MethodGet, url, "application/json", nil, false)
+	return body, statusCode, err
+}
+
+//sendRequest does the request with retries, authorized tells whether the bearer token of c.Auth is sent
+func (c *CheAPI) sendRequest(ctx context.Context, requestType, url, contentType string, data []byte, authorized bool) ([]byte, http.Header, int, error) {
 
 	client := http.Client{
-		Timeout: time.Second * 60,
+		Timeout: durationOrDefault(c.RequestTimeout, 60*time.Second),
 	}
 
-	req, err := http.NewRequest(requestType, url, bytes.NewBufferString(data))
-	req.Header.Set("Content-Type", "application/json")
+	for attempt := 1; ; attempt++ {
+		body, header, statusCode, err := c.attemptRequest(ctx, &client, requestType, url, contentType, data, authorized)
+		if attempt >= c.Retry.MaxAttempts || !shouldRetry(requestType, statusCode, err) {
+			return body, header, statusCode, requestError(requestType, url, statusCode, body, err)
+		}
+
+		reason := fmt.Sprintf("status %d", statusCode)
+		if err != nil {
+			reason = err.Error()
+		}
+		backoff := c.Retry.Backoff(attempt)
+		c.logf("Retrying %s %s in %s, attempt %d of %d failed: %s", requestType, url, backoff, attempt, c.Retry.MaxAttempts, reason)
+		if sleepErr := sleep(ctx, backoff); sleepErr != nil {
+			return body, header, statusCode, requestError(requestType, url, statusCode, body, err)
+		}
+	}
+}
+
+//attemptRequest makes a single attempt at a request with type requestType on url with data
+func (c *CheAPI) attemptRequest(ctx context.Context, client *http.Client, requestType, url, contentType string, data []byte, authorized bool) ([]byte, http.Header, int, error) {
+	req, err := http.NewRequest(requestType, url, bytes.NewReader(data))
 
 	if err != nil {
-		return []byte{}, -1, err
+		return []byte{}, nil, -1, err
+	}
+	req = req.WithContext(ctx)
+
+	req.Header.Set("Content-Type", contentType)
+
+	if authorized && c.Auth != nil {
+		token, tokenErr := c.Auth.Token()
+		if tokenErr != nil {
+			return []byte{}, nil, -1, tokenErr
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
 	}
 
 	res, doErr := client.Do(req)
 	if doErr != nil {
-		return nil, res.StatusCode, doErr
+		//There is no response when the request failed
+		return nil, nil, 0, doErr
 	}
+	defer res.Body.Close()
 
 	body, readErr := ioutil.ReadAll(res.Body)
 	if readErr != nil {
-		return nil, res.StatusCode, readErr
+		return nil, res.Header, res.StatusCode, readErr
 	}
 
-	return body, res.StatusCode, nil
-
+	return body, res.Header, res.StatusCode, nil
 }
 
-//GetExecLogs takes in the Process ID of the process you would like to get the logs for
-func (c *CheAPI) GetExecLogs(Pid int) (LogArray, error) {
-	execLogsJSON, _, reqErr := doRequest(http.MethodGet, c.ExecAgentURL+"/"+strconv.Itoa(Pid)+"/logs", "")
-
-	if reqErr != nil {
-		return LogArray{}, reqErr
+//isCheURL tells whether rawURL is on the host of the Che master or of one of the agents of the workspace
+func (c *CheAPI) isCheURL(rawURL string) bool {
+	host := urlHost(rawURL)
+	if host == "" {
+		return false
 	}
 
-	var execLogData LogArray
-	jsonErr := json.Unmarshal(execLogsJSON, &execLogData)
-	if jsonErr != nil {
-		return execLogData, jsonErr
+	cheURLs := []string{c.CheAPIEndpoint, c.ExecAgentURL, c.ExecAgentWSURL, c.WSAgentURL, c.MachineExecURL, c.EditorURL}
+	for _, server := range c.Servers {
+		cheURLs = append(cheURLs, server.URL)
 	}
-
-	return execLogData, nil
+	for _, cheURL := range cheURLs {
+		if cheURL != "" && urlHost(cheURL) == host {
+			return true
+		}
+	}
+	return false
 }
 
-//isLongLivedProcess takes in the Process ID of the process you would like to check if its long running
-func (c *CheAPI) isLongLivedProcess(Pid int) (bool, error) {
-	lastLogData, execErr := c.GetLastLog(Pid)
-	if execErr != nil {
-		return false, execErr
+//urlHost returns the host and port of rawURL in lower case, empty when it cannot be parsed
+func urlHost(rawURL string) string {
+	parsed, parseErr := neturl.Parse(rawURL)
+	if parseErr != nil {
+		return ""
 	}
+	return strings.ToLower(parsed.Host)
+}
 
-	commandExitCode, err := c.GetCommandExitCode(Pid)
+//requestError returns the CheAPIError for a request that failed with err or an error statusCode, nil when it succeeded
+func requestError(method, url string, statusCode int, body []byte, err error) error {
 	if err != nil {
-		return false, err
+		return &CheAPIError{Method: method, URL: url, Err: err}
 	}
+	if statusCode >= 400 {
+		return newCheAPIError(method, url, statusCode, body)
+	}
+	return nil
+}
 
-	equalsLastLogCount := 0
-	time.Sleep(15 * time.Second)
-
-	for equalsLastLogCount != 3 && commandExitCode.ExitCode != 0 && commandExitCode.ExitCode != -1 {
-
-		newLastLogData, execErr := c.GetLastLog(Pid)
-
-		if execErr != nil {
-			return false, nil
-		}
-
-		if newLastLogData.Kind == lastLogData.Kind && newLastLogData.Text == lastLogData.Text && newLastLogData.Time == lastLogData.Time {
-			equalsLastLogCount++
-		}
+//GetExecLogs takes in the Process ID of the process you would like to get the logs for
+func (c *CheAPI) GetExecLogs(ctx context.Context, Pid int) (LogArray, error) {
+	execLogsJSON, _, reqErr := c.doRequest(ctx, http.MethodGet, c.ExecAgentURL+"/"+strconv.Itoa(Pid)+"/logs", "")
 
-		time.Sleep(15 * time.Second)
+	if reqErr != nil {
+		return LogArray{}, reqErr
 	}
 
-	if equalsLastLogCount == 3 {
-		return true, nil
+	var execLogData LogArray
+	jsonErr := json.Unmarshal(execLogsJSON, &execLogData)
+	if jsonErr != nil {
+		return execLogData, jsonErr
 	}
 
-	return false, nil
-
+	return execLogData, nil
 }
 
 //GetLastLog takes in the Process ID of the process you would like to get the logs for
-func (c *CheAPI) GetLastLog(Pid int) (LogItem, error) {
-	execLogData, execErr := c.GetExecLogs(Pid)
+func (c *CheAPI) GetLastLog(ctx context.Context, Pid int) (LogItem, error) {
+	execLogData, execErr := c.GetExecLogs(ctx, Pid)
 
 	if execErr != nil {
 		return LogItem{}, execErr
 	}
 
+	if len(execLogData) == 0 {
+		return LogItem{}, nil
+	}
+
 	newLastLogData := execLogData[len(execLogData)-1]
 
 	return newLastLogData, nil
 }
 
 //GetCommandExitCode takes in the Process ID of the process you would like to get the Process data for
-func (c *CheAPI) GetCommandExitCode(Pid int) (ProcessStruct, error) {
-	commandExitCodeJSON, _, reqErr := doRequest(http.MethodGet, c.ExecAgentURL+"/"+strconv.Itoa(Pid), "")
+func (c *CheAPI) GetCommandExitCode(ctx context.Context, Pid int) (ProcessStruct, error) {
+	commandExitCodeJSON, _, reqErr := c.doRequest(ctx, http.MethodGet, c.ExecAgentURL+"/"+strconv.Itoa(Pid), "")
 
 	if reqErr != nil {
 		return ProcessStruct{}, reqErr
@@ -287,41 +393,46 @@ func (c *CheAPI) GetCommandExitCode(Pid int) (ProcessStruct, error) {
 	return processInfo, nil
 }
 
-//PostCommandToWorkspace creates and runs sampleCommand using the Exec Agent
-func (c *CheAPI) PostCommandToWorkspace(sampleCommand Command) (int, error) {
-	sampleCommandMarshalled, marshalErr := json.MarshalIndent(sampleCommand, "", "    ")
-
-	if marshalErr != nil {
-		return -1, marshalErr
+//PostCommandToWorkspace expands the macros in sampleCommand and runs it using the Exec Agent.
+//Commands with a readiness probe are returned once the probe passes, anything else is waited for until it exits.
+func (c *CheAPI) PostCommandToWorkspace(ctx context.Context, sampleCommand Command) (ProcessRecord, error) {
+	started := time.Now()
+	process, runErr := c.runCommand(ctx, sampleCommand)
+	if runErr != nil {
+		return process, runErr
 	}
 
-	processJSON, _, reqErr := doRequest(http.MethodPost, c.ExecAgentURL, string(sampleCommandMarshalled))
+	return process, c.recordPhase(PhaseCommand, started)
+}
 
-	if reqErr != nil {
-		return -1, reqErr
+//runCommand runs sampleCommand until it exits or, for a long running command, until it is ready
+func (c *CheAPI) runCommand(ctx context.Context, sampleCommand Command) (ProcessRecord, error) {
+	resolvedCommand, resolveErr := c.ResolveCommand(ctx, sampleCommand)
+	if resolveErr != nil {
+		return ProcessRecord{Name: sampleCommand.Name, CommandLine: sampleCommand.CommandLine}, resolveErr
 	}
 
-	var processData ProcessStruct
-	unmarshalErr := json.Unmarshal(processJSON, &processData)
-	if unmarshalErr != nil {
-		return -1, unmarshalErr
+	if c.MachineExecURL != "" {
+		if probe, ok := c.Readiness[sampleCommand.Name]; ok {
+			return c.RunMachineExecUntilReady(ctx, resolvedCommand.Name, resolvedCommand.CommandLine, probe)
+		}
+		return c.RunMachineExec(ctx, resolvedCommand.Name, resolvedCommand.CommandLine)
 	}
 
-	longLived, longLivedErr := c.isLongLivedProcess(processData.Pid)
-	if longLivedErr != nil {
-		return -1, longLivedErr
+	process, startErr := c.StartProcess(ctx, resolvedCommand)
+	if startErr != nil {
+		return process, startErr
 	}
 
-	if longLived {
-		return 0, nil
+	if probe, ok := c.Readiness[sampleCommand.Name]; ok {
+		return c.WaitUntilReady(ctx, process, probe)
 	}
 
-	return processData.Pid, nil
-
+	return c.WaitForProcess(ctx, process)
 }
 
-//AddSamplesToProject adds an array of samples to the workspace using WS Agent
-func (c *CheAPI) AddSamplesToProject(sample []Sample) error {
+//AddSamplesToProject adds an array of samples to the workspace using WS Agent, or by cloning them on Che7
+func (c *CheAPI) AddSamplesToProject(ctx context.Context, sample []Sample) error {
 
 	marshalled, marshallErr := json.MarshalIndent(sample, "", "    ")
 
@@ -329,104 +440,153 @@ func (c *CheAPI) AddSamplesToProject(sample []Sample) error {
 		return marshallErr
 	}
 
-	_, _, reqErr := doRequest(http.MethodPost, c.WSAgentURL+"/project/batch", string(marshalled))
-
-	if reqErr != nil {
+	started := time.Now()
+	if c.MachineExecURL != "" {
+		if cloneErr := c.cloneProjects(ctx, sample); cloneErr != nil {
+			return cloneErr
+		}
+	} else if _, _, reqErr := c.doRequest(ctx, http.MethodPost, c.WSAgentURL+"/project/batch", string(marshalled)); reqErr != nil {
 		return reqErr
 	}
 
-	return nil
+	//The last imported project is the current project commands run against
+	if len(sample) > 0 {
+		current := sample[len(sample)-1]
+		c.CurrentProject = samplePath(current)
+		c.SampleLocation = current.Source.Location
+	}
+
+	return c.recordPhase(PhaseImport, started)
 }
 
-//GetNumberOfProjects gets the number of projects in a workspace
-func (c *CheAPI) GetNumberOfProjects() (int, error) {
+//GetProjects gets the projects in a workspace
+func (c *CheAPI) GetProjects(ctx context.Context) ([]Sample, error) {
+	if c.MachineExecURL != "" {
+		return c.listProjects(ctx)
+	}
 
-	projectData, _, reqErr := doRequest(http.MethodGet, c.WSAgentURL+"/project", "")
+	projectData, _, reqErr := c.doRequest(ctx, http.MethodGet, c.WSAgentURL+"/project", "")
 
 	if reqErr != nil {
-		return -1, reqErr
+		return []Sample{}, reqErr
 	}
 
 	var data []Sample
 	jsonErr := json.Unmarshal(projectData, &data)
 	if jsonErr != nil {
-		return -1, jsonErr
+		return []Sample{}, jsonErr
 	}
 
-	return len(data), nil
+	return data, nil
 }
 
-//BlockWorkspace blocks the given workspaceID until it has started
-func (c *CheAPI) BlockWorkspace(workspaceID, untilStatus1, untilStatus2 string) error {
-	workspaceStatus, statusErr := c.GetWorkspaceStatusByID(workspaceID)
+//GetNumberOfProjects gets the number of projects in a workspace
+func (c *CheAPI) GetNumberOfProjects(ctx context.Context) (int, error) {
 
-	if statusErr != nil {
-		return statusErr
-	}
+	projects, projectsErr := c.GetProjects(ctx)
 
-	for workspaceStatus.WorkspaceStatus == untilStatus1 || workspaceStatus.WorkspaceStatus == untilStatus2 {
-		time.Sleep(30 * time.Second)
-		workspaceStatus, statusErr = c.GetWorkspaceStatusByID(workspaceID)
-		if statusErr != nil {
-			return statusErr
-		}
+	if projectsErr != nil {
+		return -1, projectsErr
 	}
 
-	return nil
+	return len(projects), nil
 }
 
-//GetHTTPAgents gets the Exec Agent and WSAgent from a Che5 or Che6 workspace
-func (c *CheAPI) GetHTTPAgents(workspaceID string) (Agent, error) {
+//BlockWorkspace blocks the given workspaceID while its status is untilStatus1 or untilStatus2
+func (c *CheAPI) BlockWorkspace(ctx context.Context, workspaceID, untilStatus1, untilStatus2 string) error {
+	_, watchErr := c.watchUntil(ctx, workspaceID, func(status string) bool {
+		return status != untilStatus1 && status != untilStatus2
+	})
 
-	//Now we need to get the workspace installers and then unmarshall
-	runtimeData, _, reqErr := doRequest(http.MethodGet, c.CheAPIEndpoint+"/workspace/"+workspaceID, "")
+	return watchErr
+}
 
-	if reqErr != nil {
-		return Agent{}, reqErr
+//WaitForStatus follows the workspace workspaceID until it has one of the statuses targets and returns it.
+//It fails with a WorkspaceStatusError carrying the error and attributes of the workspace when it reaches
+//one of the statuses failures first, or when it has reached no target after timeout. A timeout of 0 waits as long as ctx allows.
+func (c *CheAPI) WaitForStatus(ctx context.Context, workspaceID string, targets, failures []string, timeout time.Duration) (WorkspaceStatus, error) {
+	waitCtx := ctx
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		waitCtx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
 	}
 
-	var Che5Runtime Che5RuntimeStruct
-	json.Unmarshal(runtimeData, &Che5Runtime) //Not checking for unmarshalling errors because we don't know whether its che5 or che6 running
+	last, watchErr := c.watchUntil(waitCtx, workspaceID, func(status string) bool {
+		return containsString(targets, status) || containsString(failures, status)
+	})
+	status := WorkspaceStatus{WorkspaceStatus: last.Status}
 
-	var Che6Runtime RuntimeStruct
-	json.Unmarshal(runtimeData, &Che6Runtime) //Not checking for unmarshalling errors because we don't know whether its che5 or che6 running
+	if watchErr != nil {
+		if ctx.Err() != nil || !errors.Is(watchErr, context.DeadlineExceeded) {
+			return status, watchErr
+		}
+		statusErr := &WorkspaceStatusError{WorkspaceID: workspaceID, Status: last.Status, Targets: targets, Timeout: timeout, Err: watchErr}
+		return status, c.describeWorkspace(ctx, statusErr)
+	}
 
-	var agents Agent
+	if containsString(failures, last.Status) {
+		statusErr := &WorkspaceStatusError{WorkspaceID: workspaceID, Status: last.Status, Targets: targets, Message: last.Error}
+		return status, c.describeWorkspace(ctx, statusErr)
+	}
 
-	for index := range Che5Runtime.Runtime.Machines {
-		for _, server := range Che5Runtime.Runtime.Machines[index].Runtime.Servers {
+	return status, nil
+}
 
-			if server.Ref == "exec-agent" {
-				agents.execAgentURL = server.URL + "/process"
-			}
+//describeWorkspace adds the attributes and transitions of its workspace to statusErr
+func (c *CheAPI) describeWorkspace(ctx context.Context, statusErr *WorkspaceStatusError) error {
+	statusErr.Transitions = DescribeTransitions(c.StatusHistory, statusErr.WorkspaceID)
 
-			if server.Ref == "wsagent" {
-				agents.wsAgentURL = server.URL
-			}
-		}
+	workspaceDataJSON, _, reqErr := c.doRequest(ctx, http.MethodGet, c.CheAPIEndpoint+"/workspace/"+statusErr.WorkspaceID, "")
+	if reqErr != nil {
+		c.logf("Could not read the attributes of workspace %s: %v", statusErr.WorkspaceID, reqErr)
+		return statusErr
 	}
 
-	for key := range Che6Runtime.Runtime.Machines {
-		for serverName, installer := range Che6Runtime.Runtime.Machines[key].Servers {
+	var workspace struct {
+		Attributes map[string]string `json:"attributes"`
+	}
+	json.Unmarshal(workspaceDataJSON, &workspace)
+	statusErr.Attributes = workspace.Attributes
 
-			if serverName == "exec-agent/http" {
-				agents.execAgentURL = installer.URL
-			}
+	if statusErr.Message == "" {
+		statusErr.Message = workspace.Attributes[ErrorMessageAttribute]
+	}
 
-			if serverName == "wsagent/http" {
-				agents.wsAgentURL = installer.URL
-			}
+	return statusErr
+}
 
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
 		}
 	}
+	return false
+}
+
+//GetHTTPAgents gets the Exec Agent and WSAgent from a Che5 or Che6 workspace, or che-machine-exec and the editor from a Che7 one
+func (c *CheAPI) GetHTTPAgents(ctx context.Context, workspaceID string) (Agent, error) {
+
+	//Now we need to get the workspace installers and then unmarshall
+	runtimeData, _, reqErr := c.doRequest(ctx, http.MethodGet, c.CheAPIEndpoint+"/workspace/"+workspaceID, "")
+
+	if reqErr != nil {
+		return Agent{}, reqErr
+	}
 
-	return agents, nil
+	client, clientErr := c.versionedClient(ctx)
+	if clientErr != nil {
+		return Agent{}, clientErr
+	}
+
+	return client.Agents(runtimeData)
 }
 
 //StartWorkspace POSTs a Workspace configuration to the workspace endpoint, creating a new workspace
-func (c *CheAPI) StartWorkspace(workspaceConfiguration interface{}, stackID string) (Workspace2, error) {
+func (c *CheAPI) StartWorkspace(ctx context.Context, workspaceConfiguration interface{}, stackID string) (Workspace2, error) {
 
-	a := Post{Environments: workspaceConfiguration, Namespace: "che", Name: stackID + "-stack-test", DefaultEnv: "default"}
+	a := Post{Environments: workspaceConfiguration, Namespace: c.namespace(), Name: testWorkspaceName(stackID), DefaultEnv: "default"}
 	marshalled, marshallErr := json.MarshalIndent(a, "", "    ")
 
 	if marshallErr != nil {
@@ -437,7 +597,8 @@ func (c *CheAPI) StartWorkspace(workspaceConfiguration interface{}, stackID stri
 	re := regexp.MustCompile(",[\\n|\\s]*\"com.redhat.bayesian.lsp\"")
 	noBayesian := re.ReplaceAllString(string(marshalled), "")
 
-	workspaceDataJSON, _, reqErr := doRequest(http.MethodPost, c.CheAPIEndpoint+"/workspace?start-after-create=true", noBayesian)
+	started := time.Now()
+	workspaceDataJSON, _, reqErr := c.doRequest(ctx, http.MethodPost, c.CheAPIEndpoint+"/workspace?start-after-create=true&attribute="+MarkerAttribute+":true", noBayesian)
 
 	if reqErr != nil {
 		return Workspace2{}, reqErr
@@ -448,15 +609,23 @@ func (c *CheAPI) StartWorkspace(workspaceConfiguration interface{}, stackID stri
 	if unmarshallErr != nil {
 		return Workspace2{}, unmarshallErr
 	}
+	c.createdWorkspaces = append(c.createdWorkspaces, WorkspaceResponse.ID)
 
-	c.BlockWorkspace(WorkspaceResponse.ID, "STARTING", "")
+	return WorkspaceResponse, c.waitForStart(ctx, WorkspaceResponse.ID, started)
+}
+
+//waitForStart waits for the workspace workspaceID that was started at started to run, and times the start
+func (c *CheAPI) waitForStart(ctx context.Context, workspaceID string, started time.Time) error {
+	if _, waitErr := c.WaitForStatus(ctx, workspaceID, []string{"RUNNING"}, []string{"STOPPING", "STOPPED"}, c.StartTimeout); waitErr != nil {
+		return waitErr
+	}
 
-	return WorkspaceResponse, nil
+	return c.recordPhase(PhaseStart, started)
 }
 
 //GetWorkspaceStatusByID gets the workspace status of the given workspaceID
-func (c *CheAPI) GetWorkspaceStatusByID(workspaceID string) (WorkspaceStatus, error) {
-	workspaceDataJSON, _, reqErr := doRequest(http.MethodGet, c.CheAPIEndpoint+"/workspace/"+workspaceID, "")
+func (c *CheAPI) GetWorkspaceStatusByID(ctx context.Context, workspaceID string) (WorkspaceStatus, error) {
+	workspaceDataJSON, _, reqErr := c.doRequest(ctx, http.MethodGet, c.CheAPIEndpoint+"/workspace/"+workspaceID, "")
 
 	if reqErr != nil {
 		return WorkspaceStatus{}, reqErr
@@ -472,47 +641,82 @@ func (c *CheAPI) GetWorkspaceStatusByID(workspaceID string) (WorkspaceStatus, er
 }
 
 //CheckWorkspaceDeletion checks if the workspace at workspaceID is deleted
-func (c *CheAPI) CheckWorkspaceDeletion(workspaceID string) error {
-	_, statusCode, reqErr := doRequest(http.MethodGet, c.CheAPIEndpoint+"/workspace/"+workspaceID, "")
+func (c *CheAPI) CheckWorkspaceDeletion(ctx context.Context, workspaceID string) error {
+	_, _, reqErr := c.doRequest(ctx, http.MethodGet, c.CheAPIEndpoint+"/workspace/"+workspaceID, "")
+
+	if IsStatus(reqErr, http.StatusNotFound) {
+		return nil
+	}
 
 	if reqErr != nil {
 		return reqErr
 	}
 
-	if statusCode != 404 {
-		return fmt.Errorf("Workspace was not deleted")
+	return fmt.Errorf("Workspace was not deleted")
+}
+
+//StopWorkspace stops the workspace with workspaceID
+func (c *CheAPI) StopWorkspace(ctx context.Context, workspaceID string) error {
+	started := time.Now()
+	if stopErr := c.stopWorkspace(ctx, workspaceID); stopErr != nil {
+		return stopErr
 	}
 
-	return nil
+	return c.recordPhase(PhaseStop, started)
 }
 
-//StopWorkspace stops the workspace with workspaceID
-func (c *CheAPI) StopWorkspace(workspaceID string) error {
-	_, _, reqErr := doRequest(http.MethodDelete, c.CheAPIEndpoint+"/workspace/"+workspaceID+"/runtime", "")
+//stopWorkspace stops the workspace with workspaceID without timing it
+func (c *CheAPI) stopWorkspace(ctx context.Context, workspaceID string) error {
+	_, _, reqErr := c.doRequest(ctx, http.MethodDelete, c.CheAPIEndpoint+"/workspace/"+workspaceID+"/runtime", "")
 
 	if reqErr != nil {
 		return reqErr
 	}
 
-	c.BlockWorkspace(workspaceID, "SNAPSHOTTING", "STOPPING")
+	return c.waitWhileStopping(ctx, workspaceID)
+}
 
+//waitWhileStopping blocks while the workspace with workspaceID is in one of the statuses of a stopping workspace.
+//It fails with a WorkspaceStatusError when the workspace ends up in another status than STOPPED.
+func (c *CheAPI) waitWhileStopping(ctx context.Context, workspaceID string) error {
+	client, clientErr := c.versionedClient(ctx)
+	if clientErr != nil {
+		return clientErr
+	}
+
+	last, watchErr := c.watchUntil(ctx, workspaceID, func(status string) bool {
+		return !containsString(client.StoppingStatuses(), status)
+	})
+	if watchErr != nil {
+		return watchErr
+	}
+
+	if last.Status != "STOPPED" {
+		statusErr := &WorkspaceStatusError{WorkspaceID: workspaceID, Status: last.Status, Targets: []string{"STOPPED"}, Message: last.Error}
+		return c.describeWorkspace(ctx, statusErr)
+	}
 	return nil
 }
 
 //RemoveWorkspace removes the workspace with workspaceID
-func (c *CheAPI) RemoveWorkspace(workspaceID string) error {
-	_, _, reqErr := doRequest(http.MethodDelete, c.CheAPIEndpoint+"/workspace/"+workspaceID, "")
+func (c *CheAPI) RemoveWorkspace(ctx context.Context, workspaceID string) error {
+	started := time.Now()
+	_, _, reqErr := c.doRequest(ctx, http.MethodDelete, c.CheAPIEndpoint+"/workspace/"+workspaceID, "")
 
 	if reqErr != nil {
 		return reqErr
 	}
 
-	return nil
+	return c.recordPhase(PhaseDelete, started)
 }
 
-//GetStackInformation gets the stack information
-func (c *CheAPI) GetStackInformation() ([]Workspace, error) {
-	stackData, _, reqErr := doRequest(http.MethodGet, c.CheAPIEndpoint+"/stack", "")
+//GetStackInformation gets the stack information along with the LocalStacks. Che7 has no stacks, its devfile registry is read instead.
+func (c *CheAPI) GetStackInformation(ctx context.Context) ([]Workspace, error) {
+	stackData, _, reqErr := c.doRequest(ctx, http.MethodGet, c.CheAPIEndpoint+"/stack", "")
+
+	if IsStatus(reqErr, http.StatusNotFound) {
+		return c.GetDevfileStacks(ctx)
+	}
 
 	if reqErr != nil {
 		return []Workspace{}, reqErr
@@ -523,15 +727,20 @@ func (c *CheAPI) GetStackInformation() ([]Workspace, error) {
 	if jsonStackErr != nil {
 		return workspaceData, jsonStackErr
 	}
-	return workspaceData, nil
+	return c.withLocalStacks(workspaceData), nil
 }
 
-//GetSamplesInformation gets the samples information
-func (c *CheAPI) GetSamplesInformation() ([]Sample, error) {
-	samplesJSON, _, reqErr := doRequest(http.MethodGet, samples, "")
+//GetSamplesInformation gets the samples information from the samples source of CheAPI
+func (c *CheAPI) GetSamplesInformation(ctx context.Context) ([]Sample, error) {
+	source, sourceErr := c.SamplesSource()
+	if sourceErr != nil {
+		return []Sample{}, sourceErr
+	}
 
-	if reqErr != nil {
-		return []Sample{}, reqErr
+	samplesJSON, readErr := source.Read(ctx, c)
+
+	if readErr != nil {
+		return []Sample{}, readErr
 	}
 
 	var sampleData []Sample
@@ -540,6 +749,7 @@ func (c *CheAPI) GetSamplesInformation() ([]Sample, error) {
 		return sampleData, jsonSamplesErr
 	}
 
+	c.logf("Read %d samples from %s", len(sampleData), source)
 	return sampleData, nil
 }
 
@@ -563,6 +773,12 @@ func (c *CheAPI) GenerateDataForWorkspaces(stackData []Workspace, samples []Samp
 func (c *CheAPI) SetAgentsURL(agents Agent) {
 	c.WSAgentURL = agents.wsAgentURL
 	c.ExecAgentURL = agents.execAgentURL
+	c.ExecAgentWSURL = agents.execAgentWSURL
+	c.MachineExecURL = agents.machineExecURL
+	c.EditorURL = agents.editorURL
+	c.DevMachine = agents.devMachine
+	c.Servers = agents.servers
+	c.CheVersion = agents.cheVersion
 }
 
 //SetWorkspaceID sets the workspaceID for CheAPI
@@ -570,23 +786,96 @@ func (c *CheAPI) SetWorkspaceID(workspaceID string) {
 	c.WorkspaceID = workspaceID
 }
 
+//GetWorkspaceID gets the workspaceID of CheAPI
+func (c *CheAPI) GetWorkspaceID() string {
+	return c.WorkspaceID
+}
+
 //SetStackName sets the stackName for CheAPI
 func (c *CheAPI) SetStackName(stackName string) {
 	c.StackName = stackName
 }
 
+//GetStackName gets the stackName of CheAPI
+func (c *CheAPI) GetStackName() string {
+	return c.StackName
+}
+
+//SetProcess sets the last command run by CheAPI
+func (c *CheAPI) SetProcess(process ProcessRecord) {
+	c.Process = process
+}
+
+//GetProcess gets the last command run by CheAPI
+func (c *CheAPI) GetProcess() ProcessRecord {
+	return c.Process
+}
+
+//GetStatusHistory gets the status changes of the workspaces followed by CheAPI
+func (c *CheAPI) GetStatusHistory() []WorkspaceEvent {
+	return c.StatusHistory
+}
+
 func (c *CheAPI) SetStackConfigMap(workspaceConfig map[string]Workspace) {
-	stackConfigMap = workspaceConfig
+	c.stackConfigMap = workspaceConfig
 }
 
 func (c *CheAPI) SetSamplesConfigMap(sampleConfig map[string]Sample) {
-	sampleConfigMap = sampleConfig
+	c.sampleConfigMap = sampleConfig
 }
 
 func (c *CheAPI) GetStackConfigMap() map[string]Workspace {
-	return stackConfigMap
+	return c.stackConfigMap
 }
 
 func (c *CheAPI) GetSamplesConfigMap() map[string]Sample {
-	return sampleConfigMap
+	return c.sampleConfigMap
+}
+
+//Logf logs to the Logger of this CheAPI
+func (c *CheAPI) Logf(format string, args ...interface{}) {
+	c.logf(format, args...)
+}
+
+//logf logs to the Logger of this CheAPI so that output of concurrent scenarios can be told apart
+func (c *CheAPI) logf(format string, args ...interface{}) {
+	if c.Logger == nil {
+		log.Printf(format, args...)
+		return
+	}
+	c.Logger.Printf(format, args...)
+}
+
+func (c *CheAPI) namespace() string {
+	if c.Namespace == "" {
+		return "che"
+	}
+	return c.Namespace
+}
+
+func (c *CheAPI) samplesURL() string {
+	if c.SamplesURL == "" {
+		return samples
+	}
+	return c.SamplesURL
+}
+
+func durationOrDefault(d, defaultDuration time.Duration) time.Duration {
+	if d <= 0 {
+		return defaultDuration
+	}
+	return d
+}
+
+//sleep waits for d, returning early with the error of ctx when it is cancelled or its deadline passes first
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
 }
@@ -0,0 +1,58 @@
+/*
+Copyright (C) 2017 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import "context"
+
+//CheClient is every operation the steps of the features run against Che. CheAPI talks to a
+//Che server, RecordingClient answers from memory so the steps can be tested without one.
+type CheClient interface {
+	GetStackInformation(ctx context.Context) ([]Workspace, error)
+	GetSamplesInformation(ctx context.Context) ([]Sample, error)
+	GenerateDataForWorkspaces(stackData []Workspace, samples []Sample)
+	GetStackConfigMap() map[string]Workspace
+	GetSamplesConfigMap() map[string]Sample
+	CompatibleSample(stackName string) (SampleMatch, []SampleMatch, error)
+	CreateStack(ctx context.Context, stack Workspace) (Workspace, error)
+	SearchStacks(ctx context.Context, tags ...string) ([]Workspace, error)
+	DeleteStack(ctx context.Context, stackID string) error
+
+	StartWorkspace(ctx context.Context, workspaceConfiguration interface{}, stackID string) (Workspace2, error)
+	StartDevfileWorkspace(ctx context.Context, devfile Devfile, stackID string) (Workspace2, error)
+	GetHTTPAgents(ctx context.Context, workspaceID string) (Agent, error)
+	GetWorkspaceStatusByID(ctx context.Context, workspaceID string) (WorkspaceStatus, error)
+	StopWorkspace(ctx context.Context, workspaceID string) error
+	RemoveWorkspace(ctx context.Context, workspaceID string) error
+	CheckWorkspaceDeletion(ctx context.Context, workspaceID string) error
+
+	AddSamplesToProject(ctx context.Context, sample []Sample) error
+	GetNumberOfProjects(ctx context.Context) (int, error)
+	PostCommandToWorkspace(ctx context.Context, sampleCommand Command) (ProcessRecord, error)
+
+	SetWorkspaceID(workspaceID string)
+	GetWorkspaceID() string
+	SetStackName(stackName string)
+	GetStackName() string
+	SetAgentsURL(agents Agent)
+	SetProcess(process ProcessRecord)
+	GetProcess() ProcessRecord
+	GetStatusHistory() []WorkspaceEvent
+	Logf(format string, args ...interface{})
+}
+
+var _ CheClient = (*CheAPI)(nil)
+var _ CheClient = (*RecordingClient)(nil)
@@ -0,0 +1,190 @@
+/*
+Copyright (C) 2017 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+//Scores of the evidence that a sample works on a stack
+const (
+	projectTypeScore = 3
+	tagScore         = 2
+	commandScore     = 1
+)
+
+//projectTypes names the stack tag or component each Che project type needs
+var projectTypes = map[string]string{
+	"maven":      "maven",
+	"gradle":     "gradle",
+	"node-js":    "nodejs",
+	"python":     "python",
+	"php":        "php",
+	"go":         "go",
+	"ruby":       "ruby",
+	"cpp":        "cpp",
+	"c":          "c",
+	"dotnet":     "net",
+	"aspnet":     "net",
+	"java":       "java",
+	"javac":      "java",
+	"spring":     "java",
+	"springboot": "java",
+}
+
+//StackComponent is a tool or runtime a stack provides, such as {"name": "JDK", "version": "1.8.0_45"}
+type StackComponent struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+//SampleMatch is how well a sample fits a stack, with the reasons for its score
+type SampleMatch struct {
+	Sample     Sample
+	Score      int
+	Compatible bool
+	Reasons    []string
+	Missing    []string
+}
+
+//Explain describes why the sample was or was not chosen
+func (m SampleMatch) Explain() string {
+	verdict := "compatible"
+	if !m.Compatible {
+		verdict = "not compatible"
+	}
+
+	explanation := fmt.Sprintf("%s (%s) is %s with score %d", m.Sample.Name, m.Sample.Source.Location, verdict, m.Score)
+	if len(m.Reasons) > 0 {
+		explanation += ": " + strings.Join(m.Reasons, ", ")
+	}
+	if len(m.Missing) > 0 {
+		explanation += "; the stack lacks " + strings.Join(m.Missing, ", ")
+	}
+	return explanation
+}
+
+//MatchSample scores sample against the tags and components of stack. A sample is compatible when the stack
+//has all of the sample's tags and what its project type needs, and at least one of them is known.
+func MatchSample(stack Workspace, sample Sample) SampleMatch {
+	provided := make(map[string]string)
+	for _, tag := range stack.Tags {
+		provided[normalizeTag(tag)] = "stack tag " + tag
+	}
+	for _, component := range stack.Components {
+		provided[normalizeTag(component.Name)] = "component " + component.Name
+	}
+
+	match := SampleMatch{Sample: sample}
+
+	for _, tag := range sample.Tags {
+		if source, ok := provided[normalizeTag(tag)]; ok {
+			match.Score += tagScore
+			match.Reasons = append(match.Reasons, fmt.Sprintf("tag %s matches %s", tag, source))
+		} else {
+			match.Missing = append(match.Missing, "tag "+tag)
+		}
+	}
+
+	if needed, known := projectTypes[strings.ToLower(sample.ProjectType)]; known {
+		if source, ok := provided[needed]; ok {
+			match.Score += projectTypeScore
+			match.Reasons = append(match.Reasons, fmt.Sprintf("project type %s is supported by %s", sample.ProjectType, source))
+		} else {
+			match.Missing = append(match.Missing, fmt.Sprintf("%s for project type %s", needed, sample.ProjectType))
+		}
+	}
+
+	//Commands only help ranking, they say nothing about what the sample needs
+	match.Compatible = len(match.Missing) == 0 && match.Score > 0
+
+	if len(sample.Commands) > 0 {
+		match.Score += commandScore
+		match.Reasons = append(match.Reasons, fmt.Sprintf("it has %d commands", len(sample.Commands)))
+	}
+	return match
+}
+
+//ResolveSamples ranks samples by how well they fit stack, the compatible ones with the highest score first
+func ResolveSamples(stack Workspace, samples []Sample) []SampleMatch {
+	matches := make([]SampleMatch, 0, len(samples))
+	for _, sample := range samples {
+		matches = append(matches, MatchSample(stack, sample))
+	}
+
+	sort.SliceStable(matches, func(i, j int) bool {
+		if matches[i].Compatible != matches[j].Compatible {
+			return matches[i].Compatible
+		}
+		return matches[i].Score > matches[j].Score
+	})
+	return matches
+}
+
+//BuildCommand picks the command that builds sample on stack: a build command of the sample,
+//else its first command, else the first command of the stack
+func BuildCommand(stack Workspace, sample Sample) (Command, bool) {
+	for _, command := range sample.Commands {
+		name := strings.ToLower(command.Name)
+		if strings.Contains(name, "build") || command.Type == "mvn" || command.Type == "gradle" {
+			return command, true
+		}
+	}
+	if len(sample.Commands) > 0 {
+		return sample.Commands[0], true
+	}
+	if len(stack.Command) > 0 {
+		return stack.Command[0], true
+	}
+	return Command{}, false
+}
+
+//CompatibleSample returns the best sample for the stack stackName from the loaded catalogs, along with
+//every candidate it was chosen from
+func (c *CheAPI) CompatibleSample(stackName string) (SampleMatch, []SampleMatch, error) {
+	return compatibleSample(c.stackConfigMap, c.sampleConfigMap, stackName)
+}
+
+//compatibleSample ranks the samples of sampleConfigMap for the stack stackName of stackConfigMap
+func compatibleSample(stackConfigMap map[string]Workspace, sampleConfigMap map[string]Sample, stackName string) (SampleMatch, []SampleMatch, error) {
+	stack, ok := stackConfigMap[stackName]
+	if !ok {
+		return SampleMatch{}, nil, fmt.Errorf("Stack %q was not found", stackName)
+	}
+
+	var samples []Sample
+	for _, sample := range sampleConfigMap {
+		samples = append(samples, sample)
+	}
+	//The catalog is a map, keep the ranking of equal scores stable between runs
+	sort.Slice(samples, func(i, j int) bool {
+		return samples[i].Source.Location < samples[j].Source.Location
+	})
+
+	candidates := ResolveSamples(stack, samples)
+	if len(candidates) == 0 || !candidates[0].Compatible {
+		return SampleMatch{}, candidates, fmt.Errorf("No sample is compatible with stack %q", stackName)
+	}
+	return candidates[0], candidates, nil
+}
+
+//normalizeTag makes "Node.JS" and "nodejs" the same tag
+func normalizeTag(tag string) string {
+	return nonSlugCharacters.ReplaceAllString(strings.ToLower(tag), "")
+}
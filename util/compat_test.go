@@ -0,0 +1,100 @@
+/*
+Copyright (C) 2017 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestResolveSamplesRanksByEvidence(t *testing.T) {
+	stack := Workspace{
+		Name:       "Node",
+		Tags:       []string{"Node.JS", "CentOS"},
+		Components: []StackComponent{{Name: "Maven", Version: "3.3.9"}},
+	}
+	samples := []Sample{
+		{Name: "java-web", Tags: []string{"java"}, ProjectType: "maven"},
+		{Name: "angular", Tags: []string{"nodejs"}, ProjectType: "node-js"},
+		{Name: "express", Tags: []string{"nodejs"}, ProjectType: "node-js", Commands: []Command{{Name: "run"}}},
+		{Name: "untagged", ProjectType: "blank"},
+	}
+
+	matches := ResolveSamples(stack, samples)
+
+	var ranking []string
+	for _, match := range matches {
+		ranking = append(ranking, match.Sample.Name)
+	}
+	if strings.Join(ranking, ",") != "express,angular,java-web,untagged" {
+		t.Errorf("unexpected ranking %v", ranking)
+	}
+
+	if !matches[0].Compatible || matches[0].Score != tagScore+projectTypeScore+commandScore {
+		t.Errorf("express should be compatible with the highest score: %+v", matches[0])
+	}
+	if matches[2].Compatible || len(matches[2].Missing) != 1 {
+		t.Errorf("java-web lacks the java tag: %+v", matches[2])
+	}
+	if matches[3].Compatible {
+		t.Errorf("a sample without tags or a known project type proves nothing: %+v", matches[3])
+	}
+
+	explanation := matches[2].Explain()
+	for _, expected := range []string{"not compatible", "project type maven is supported by component Maven", "lacks tag java"} {
+		if !strings.Contains(explanation, expected) {
+			t.Errorf("%q does not explain %q", explanation, expected)
+		}
+	}
+}
+
+func TestCompatibleSampleFromTheCatalogs(t *testing.T) {
+	c := CheAPI{}
+	c.GenerateDataForWorkspaces(FakeStacks(), FakeSamples())
+
+	match, candidates, err := c.CompatibleSample("Eclipse Vert.x")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if match.Sample.Name != "vertx-http-booster" || len(candidates) != 2 {
+		t.Errorf("expected vertx-http-booster out of 2 candidates, got %s", match.Explain())
+	}
+
+	match, _, err = c.CompatibleSample("Java CentOS")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if match.Sample.Name != "console-java-simple" {
+		t.Errorf("expected console-java-simple, got %s", match.Explain())
+	}
+
+	if _, _, err := c.CompatibleSample("Windows"); err == nil {
+		t.Error("an unknown stack should fail")
+	}
+}
+
+func TestBuildCommandPrefersBuilds(t *testing.T) {
+	sample := Sample{Commands: []Command{{Name: "run", Type: "custom"}, {Name: "app:build", Type: "custom"}}}
+	if command, _ := BuildCommand(Workspace{}, sample); command.Name != "app:build" {
+		t.Errorf("expected the build command, got %+v", command)
+	}
+
+	stack := Workspace{Command: []Command{{Name: "stack-build"}}}
+	if command, ok := BuildCommand(stack, Sample{}); !ok || command.Name != "stack-build" {
+		t.Errorf("expected the stack command, got %+v", command)
+	}
+}
@@ -0,0 +1,527 @@
+/*
+Copyright (C) 2017 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+const (
+	configFileFlag = "che.config"
+	configFileEnv  = "CHE_TEST_CONFIG"
+	flagPrefix     = "che."
+	envPrefix      = "CHE_TEST_"
+)
+
+//Duration is a time.Duration that can be read from "30s" style strings in YAML and JSON config files
+type Duration struct {
+	time.Duration
+}
+
+//UnmarshalJSON reads a duration string such as "90s" or a number of nanoseconds
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var value interface{}
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+	return d.set(value)
+}
+
+//UnmarshalYAML reads a duration string such as "90s" or a number of nanoseconds
+func (d *Duration) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var value interface{}
+	if err := unmarshal(&value); err != nil {
+		return err
+	}
+	return d.set(value)
+}
+
+//MarshalJSON writes the duration back out as a string
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+func (d *Duration) set(value interface{}) error {
+	switch v := value.(type) {
+	case string:
+		parsed, err := time.ParseDuration(v)
+		if err != nil {
+			return err
+		}
+		d.Duration = parsed
+	case float64:
+		d.Duration = time.Duration(v)
+	case int:
+		d.Duration = time.Duration(v)
+	default:
+		return fmt.Errorf("Invalid duration %v", value)
+	}
+	return nil
+}
+
+//Config holds everything needed to point the suite at a Che server and decide what to run
+type Config struct {
+	CheAPIEndpoint        string            `json:"endpoint" yaml:"endpoint"`
+	SamplesURL            string            `json:"samples" yaml:"samples"`
+	DevfileRegistryURL    string            `json:"devfileRegistry" yaml:"devfileRegistry"`
+	PluginRegistryURL     string            `json:"pluginRegistry" yaml:"pluginRegistry"`
+	Namespace             string            `json:"namespace" yaml:"namespace"`
+	RequestTimeout        Duration          `json:"requestTimeout" yaml:"requestTimeout"`
+	WorkspacePollInterval Duration          `json:"workspacePollInterval" yaml:"workspacePollInterval"`
+	ProcessPollInterval   Duration          `json:"processPollInterval" yaml:"processPollInterval"`
+	Features              []string          `json:"features" yaml:"features"`
+	Tags                  string            `json:"tags" yaml:"tags"`
+	Format                string            `json:"format" yaml:"format"`
+	JUnitReport           string            `json:"junitReport" yaml:"junitReport"`
+	JSONReport            string            `json:"jsonReport" yaml:"jsonReport"`
+	MetricsCSV            string            `json:"metricsCSV" yaml:"metricsCSV"`
+	MetricsPrometheus     string            `json:"metricsPrometheus" yaml:"metricsPrometheus"`
+	Auth                  AuthConfig        `json:"auth" yaml:"auth"`
+	Fake                  int               `json:"fake" yaml:"fake"`
+	CheVersion            string            `json:"cheVersion" yaml:"cheVersion"`
+	Concurrency           int               `json:"concurrency" yaml:"concurrency"`
+	ScenarioTimeout       Duration          `json:"scenarioTimeout" yaml:"scenarioTimeout"`
+	StartTimeout          Duration          `json:"startTimeout" yaml:"startTimeout"`
+	Sweep                 SweepConfig       `json:"sweep" yaml:"sweep"`
+	Matrix                MatrixConfig      `json:"matrix" yaml:"matrix"`
+	LocalStacks           LocalStacksConfig `json:"localStacks" yaml:"localStacks"`
+	Retry                 RetryPolicy       `json:"retry" yaml:"retry"`
+	//Budgets are the longest the lifecycle phases of stacks may take
+	Budgets []Budget `json:"budgets" yaml:"budgets"`
+	//Readiness maps command names to the probe telling when the command is ready
+	Readiness map[string]ReadinessProbe `json:"readiness" yaml:"readiness"`
+	//TokenSource is built from Auth by Load and shared by every CheAPI, so the suite logs in once
+	TokenSource TokenSource `json:"-" yaml:"-"`
+}
+
+//setting is a single config value that can be overridden by an env var or a flag
+type setting struct {
+	name  string
+	usage string
+	apply func(cfg *Config, value string) error
+}
+
+var settings = []setting{
+	{"endpoint", "Che API endpoint", func(cfg *Config, value string) error {
+		cfg.CheAPIEndpoint = value
+		return nil
+	}},
+	{"samples", "samples.json catalog: a URL, file:<path>, release:<Che tag> or embedded", func(cfg *Config, value string) error {
+		if _, err := ParseSamplesSource(value); err != nil {
+			return err
+		}
+		cfg.SamplesURL = value
+		return nil
+	}},
+	{"devfile-registry", "Che7 devfile registry the stacks are read from, by default the one of the Che master", func(cfg *Config, value string) error {
+		cfg.DevfileRegistryURL = value
+		return nil
+	}},
+	{"plugin-registry", "Che7 plugin registry editor and plugin components are resolved in, by default the one of the Che master", func(cfg *Config, value string) error {
+		cfg.PluginRegistryURL = value
+		return nil
+	}},
+	{"namespace", "namespace workspaces are created in", func(cfg *Config, value string) error {
+		cfg.Namespace = value
+		return nil
+	}},
+	{"request-timeout", "timeout of a single HTTP request", func(cfg *Config, value string) error {
+		return cfg.RequestTimeout.set(value)
+	}},
+	{"workspace-poll-interval", "interval between workspace status checks", func(cfg *Config, value string) error {
+		return cfg.WorkspacePollInterval.set(value)
+	}},
+	{"process-poll-interval", "interval between exec agent process checks", func(cfg *Config, value string) error {
+		return cfg.ProcessPollInterval.set(value)
+	}},
+	{"features", "comma separated list of feature files or directories to run", func(cfg *Config, value string) error {
+		cfg.Features = splitList(value)
+		return nil
+	}},
+	{"tags", "godog tag expression selecting the scenarios to run", func(cfg *Config, value string) error {
+		cfg.Tags = value
+		return nil
+	}},
+	{"format", "godog output format", func(cfg *Config, value string) error {
+		cfg.Format = value
+		return nil
+	}},
+	{"retry-attempts", "attempts at a request with transient failures, 1 never retries", func(cfg *Config, value string) error {
+		attempts, err := strconv.Atoi(value)
+		cfg.Retry.MaxAttempts = attempts
+		return err
+	}},
+	{"retry-initial-backoff", "wait before the first retry, doubled for every further retry", func(cfg *Config, value string) error {
+		return cfg.Retry.InitialBackoff.set(value)
+	}},
+	{"retry-max-backoff", "longest wait between retries", func(cfg *Config, value string) error {
+		return cfg.Retry.MaxBackoff.set(value)
+	}},
+	{"junit-report", "path the JUnit XML report is written to", func(cfg *Config, value string) error {
+		cfg.JUnitReport = value
+		return nil
+	}},
+	{"json-report", "path the JSON report is written to", func(cfg *Config, value string) error {
+		cfg.JSONReport = value
+		return nil
+	}},
+	{"metrics-csv", "path the lifecycle timings are written to as CSV", func(cfg *Config, value string) error {
+		cfg.MetricsCSV = value
+		return nil
+	}},
+	{"metrics-prometheus", "path the lifecycle timings are written to in the Prometheus text format", func(cfg *Config, value string) error {
+		cfg.MetricsPrometheus = value
+		return nil
+	}},
+	{"fake", "run against an in-process fake Che server of this major version (5, 6 or 7) instead of the endpoint", func(cfg *Config, value string) error {
+		version, err := strconv.Atoi(value)
+		if err != nil {
+			return err
+		}
+		if checkErr := checkFakeVersion(version); checkErr != nil {
+			return checkErr
+		}
+		cfg.Fake = version
+		return nil
+	}},
+	{"che-version", "major version of Che (5, 6 or 7) the endpoint runs, detected from the server when empty", func(cfg *Config, value string) error {
+		if checkErr := checkCheVersion(value); checkErr != nil {
+			return checkErr
+		}
+		cfg.CheVersion = value
+		return nil
+	}},
+	{"concurrency", "number of scenarios run at the same time", func(cfg *Config, value string) error {
+		concurrency, err := strconv.Atoi(value)
+		if err != nil {
+			return err
+		}
+		if checkErr := checkConcurrency(concurrency); checkErr != nil {
+			return checkErr
+		}
+		cfg.Concurrency = concurrency
+		return nil
+	}},
+	{"sweep-before", "stop and remove leftover test workspaces before the suite runs", func(cfg *Config, value string) error {
+		before, err := strconv.ParseBool(value)
+		cfg.Sweep.Before = before
+		return err
+	}},
+	{"sweep-dry-run", "only report the leftover test workspaces that would be removed", func(cfg *Config, value string) error {
+		dryRun, err := strconv.ParseBool(value)
+		cfg.Sweep.DryRun = dryRun
+		return err
+	}},
+	{"sweep-max-age", "only sweep test workspaces created longer ago than this", func(cfg *Config, value string) error {
+		return cfg.Sweep.MaxAge.set(value)
+	}},
+	{"sweep-include", "comma separated glob patterns of the workspace names to sweep", func(cfg *Config, value string) error {
+		cfg.Sweep.Include = splitList(value)
+		return checkPatterns(cfg.Sweep.Include)
+	}},
+	{"sweep-exclude", "comma separated glob patterns of the workspace names never to sweep", func(cfg *Config, value string) error {
+		cfg.Sweep.Exclude = splitList(value)
+		return checkPatterns(cfg.Sweep.Exclude)
+	}},
+	{"matrix", "test every stack on the server with its compatible samples instead of the features", func(cfg *Config, value string) error {
+		generate, err := strconv.ParseBool(value)
+		cfg.Matrix.Generate = generate
+		return err
+	}},
+	{"matrix-include-stacks", "comma separated glob patterns of the stack names in the matrix", func(cfg *Config, value string) error {
+		cfg.Matrix.IncludeStacks = splitList(value)
+		return checkPatterns(cfg.Matrix.IncludeStacks)
+	}},
+	{"matrix-exclude-stacks", "comma separated glob patterns of the stack names left out of the matrix", func(cfg *Config, value string) error {
+		cfg.Matrix.ExcludeStacks = splitList(value)
+		return checkPatterns(cfg.Matrix.ExcludeStacks)
+	}},
+	{"matrix-include-samples", "comma separated glob patterns of the sample locations in the matrix", func(cfg *Config, value string) error {
+		cfg.Matrix.IncludeSamples = splitList(value)
+		return checkPatterns(cfg.Matrix.IncludeSamples)
+	}},
+	{"matrix-exclude-samples", "comma separated glob patterns of the sample locations left out of the matrix", func(cfg *Config, value string) error {
+		cfg.Matrix.ExcludeSamples = splitList(value)
+		return checkPatterns(cfg.Matrix.ExcludeSamples)
+	}},
+	{"local-stacks-dir", "directory of stack JSON files tested next to the stacks of the server", func(cfg *Config, value string) error {
+		cfg.LocalStacks.Dir = value
+		return nil
+	}},
+	{"local-stacks-register", "register the local stacks on the server for the run and remove them afterwards", func(cfg *Config, value string) error {
+		register, err := strconv.ParseBool(value)
+		cfg.LocalStacks.Register = register
+		return err
+	}},
+	{"scenario-timeout", "time budget of a scenario, after which its requests and waits are aborted", func(cfg *Config, value string) error {
+		return cfg.ScenarioTimeout.set(value)
+	}},
+	{"start-timeout", "longest a workspace may take to start", func(cfg *Config, value string) error {
+		return cfg.StartTimeout.set(value)
+	}},
+	{"auth-token", "static bearer token sent with every request", func(cfg *Config, value string) error {
+		cfg.Auth.Token = value
+		return nil
+	}},
+	{"auth-token-url", "Keycloak/OIDC token endpoint used to log in", func(cfg *Config, value string) error {
+		cfg.Auth.TokenURL = value
+		return nil
+	}},
+	{"auth-client-id", "OIDC client id", func(cfg *Config, value string) error {
+		cfg.Auth.ClientID = value
+		return nil
+	}},
+	{"auth-client-secret", "OIDC client secret", func(cfg *Config, value string) error {
+		cfg.Auth.ClientSecret = value
+		return nil
+	}},
+	{"auth-username", "user for the OIDC password grant, leave empty for the client credentials grant", func(cfg *Config, value string) error {
+		cfg.Auth.Username = value
+		return nil
+	}},
+	{"auth-password", "password for the OIDC password grant", func(cfg *Config, value string) error {
+		cfg.Auth.Password = value
+		return nil
+	}},
+}
+
+func checkFakeVersion(version int) error {
+	if version != 0 && (version < 5 || version > 7) {
+		return fmt.Errorf("Fake Che version must be 5, 6 or 7")
+	}
+	return nil
+}
+
+func checkCheVersion(version string) error {
+	if version != "" && version != "5" && version != "6" && version != "7" {
+		return fmt.Errorf("Che version must be 5, 6 or 7")
+	}
+	return nil
+}
+
+func checkConcurrency(concurrency int) error {
+	if concurrency < 1 {
+		return fmt.Errorf("Concurrency must be at least 1")
+	}
+	return nil
+}
+
+//DefaultConfig returns the config used when nothing is overridden, targeting a local single user Che
+func DefaultConfig() Config {
+	return Config{
+		CheAPIEndpoint:        "http://localhost:8081/api",
+		SamplesURL:            samples,
+		Namespace:             "che",
+		RequestTimeout:        Duration{60 * time.Second},
+		WorkspacePollInterval: Duration{2 * time.Second},
+		ProcessPollInterval:   Duration{15 * time.Second},
+		Features:              []string{"features"},
+		Format:                "progress",
+		Concurrency:           1,
+		ScenarioTimeout:       Duration{20 * time.Minute},
+		StartTimeout:          Duration{10 * time.Minute},
+		Sweep:                 SweepConfig{MaxAge: Duration{time.Hour}},
+		Retry:                 DefaultRetryPolicy(),
+	}
+}
+
+//LoadConfigFile reads a YAML or JSON config file on top of the defaults
+func LoadConfigFile(path string) (Config, error) {
+	cfg := DefaultConfig()
+
+	if path == "" {
+		return cfg, nil
+	}
+
+	data, readErr := ioutil.ReadFile(path)
+	if readErr != nil {
+		return cfg, readErr
+	}
+
+	var parseErr error
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		parseErr = yaml.UnmarshalStrict(data, &cfg)
+	default:
+		decoder := json.NewDecoder(strings.NewReader(string(data)))
+		decoder.DisallowUnknownFields()
+		parseErr = decoder.Decode(&cfg)
+	}
+
+	if parseErr != nil {
+		return cfg, fmt.Errorf("Could not parse config file %s: %v", path, parseErr)
+	}
+
+	for _, patterns := range [][]string{cfg.Sweep.Include, cfg.Sweep.Exclude, cfg.Matrix.IncludeStacks,
+		cfg.Matrix.ExcludeStacks, cfg.Matrix.IncludeSamples, cfg.Matrix.ExcludeSamples} {
+		if patternErr := checkPatterns(patterns); patternErr != nil {
+			return cfg, fmt.Errorf("Could not parse config file %s: %v", path, patternErr)
+		}
+	}
+
+	if _, samplesErr := ParseSamplesSource(cfg.SamplesURL); samplesErr != nil {
+		return cfg, fmt.Errorf("Could not parse config file %s: %v", path, samplesErr)
+	}
+
+	for _, budget := range cfg.Budgets {
+		if budgetErr := budget.validate(); budgetErr != nil {
+			return cfg, fmt.Errorf("Could not parse config file %s: %v", path, budgetErr)
+		}
+	}
+
+	//The same checks as for the flags and env vars
+	for _, checkErr := range []error{checkFakeVersion(cfg.Fake), checkCheVersion(cfg.CheVersion), checkConcurrency(cfg.Concurrency)} {
+		if checkErr != nil {
+			return cfg, fmt.Errorf("Could not parse config file %s: %v", path, checkErr)
+		}
+	}
+
+	return cfg, nil
+}
+
+//ApplyEnv overrides cfg with any CHE_TEST_* environment variables that are set
+func (cfg *Config) ApplyEnv() error {
+	for _, s := range settings {
+		envName := envPrefix + strings.ToUpper(strings.Replace(s.name, "-", "_", -1))
+		if value, ok := os.LookupEnv(envName); ok {
+			if err := s.apply(cfg, value); err != nil {
+				return fmt.Errorf("Invalid value for %s: %v", envName, err)
+			}
+		}
+	}
+	return nil
+}
+
+//NewCheAPI creates a CheAPI that talks to the server described by cfg
+func (cfg Config) NewCheAPI() CheAPI {
+	return CheAPI{
+		CheAPIEndpoint:        cfg.CheAPIEndpoint,
+		SamplesURL:            cfg.SamplesURL,
+		DevfileRegistryURL:    cfg.DevfileRegistryURL,
+		PluginRegistryURL:     cfg.PluginRegistryURL,
+		CheVersion:            cfg.CheVersion,
+		Namespace:             cfg.Namespace,
+		RequestTimeout:        cfg.RequestTimeout.Duration,
+		WorkspacePollInterval: cfg.WorkspacePollInterval.Duration,
+		ProcessPollInterval:   cfg.ProcessPollInterval.Duration,
+		StartTimeout:          cfg.StartTimeout.Duration,
+		Auth:                  cfg.TokenSource,
+		Readiness:             cfg.Readiness,
+		Budgets:               cfg.Budgets,
+		Retry:                 cfg.Retry,
+		Logger:                log.New(os.Stderr, "", log.LstdFlags),
+	}
+}
+
+//ConfigFlags holds the che.* flags registered on a FlagSet
+type ConfigFlags struct {
+	flags      *flag.FlagSet
+	configFile *string
+	overrides  map[string]string
+}
+
+type overrideValue struct {
+	name      string
+	overrides map[string]string
+}
+
+func (o overrideValue) String() string {
+	if o.overrides == nil {
+		return ""
+	}
+	return o.overrides[o.name]
+}
+
+func (o overrideValue) Set(value string) error {
+	o.overrides[o.name] = value
+	return nil
+}
+
+//RegisterConfigFlags registers -che.config and one -che.<setting> flag per setting on flags
+func RegisterConfigFlags(flags *flag.FlagSet) *ConfigFlags {
+	cf := &ConfigFlags{
+		flags:     flags,
+		overrides: make(map[string]string),
+	}
+
+	cf.configFile = flags.String(configFileFlag, "", "path to a YAML or JSON config file (or set "+configFileEnv+")")
+	for _, s := range settings {
+		flags.Var(overrideValue{name: s.name, overrides: cf.overrides}, flagPrefix+s.name, s.usage)
+	}
+
+	return cf
+}
+
+//Load builds the config from the defaults, the config file, the environment and the flags, in that order.
+//It must be called after the FlagSet has been parsed.
+func (cf *ConfigFlags) Load() (Config, error) {
+	path := *cf.configFile
+	if path == "" {
+		path = os.Getenv(configFileEnv)
+	}
+
+	cfg, fileErr := LoadConfigFile(path)
+	if fileErr != nil {
+		return cfg, fileErr
+	}
+
+	if envErr := cfg.ApplyEnv(); envErr != nil {
+		return cfg, envErr
+	}
+
+	for _, s := range settings {
+		if value, ok := cf.overrides[s.name]; ok {
+			if err := s.apply(&cfg, value); err != nil {
+				return cfg, fmt.Errorf("Invalid value for -%s: %v", flagPrefix+s.name, err)
+			}
+		}
+	}
+
+	cfg.TokenSource = cfg.Auth.TokenSource()
+	return cfg, nil
+}
+
+func splitList(value string) []string {
+	var list []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+func checkPatterns(patterns []string) error {
+	for _, pattern := range patterns {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("Invalid pattern %q: %v", pattern, err)
+		}
+	}
+	return nil
+}
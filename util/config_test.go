@@ -0,0 +1,114 @@
+/*
+Copyright (C) 2017 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestConfigLayering(t *testing.T) {
+	dir, err := ioutil.TempDir("", "che-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	configFile := filepath.Join(dir, "config.yaml")
+	fileContent := "endpoint: http://file/api\nnamespace: file-ns\nrequestTimeout: 10s\ntags: \"@che6\"\n"
+	if err := ioutil.WriteFile(configFile, []byte(fileContent), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	os.Setenv("CHE_TEST_NAMESPACE", "env-ns")
+	os.Setenv("CHE_TEST_REQUEST_TIMEOUT", "20s")
+	defer os.Unsetenv("CHE_TEST_NAMESPACE")
+	defer os.Unsetenv("CHE_TEST_REQUEST_TIMEOUT")
+
+	flags := flag.NewFlagSet("test", flag.ContinueOnError)
+	configFlags := RegisterConfigFlags(flags)
+	if err := flags.Parse([]string{"-che.config", configFile, "-che.request-timeout", "30s", "-che.features", "a.feature, b"}); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := configFlags.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cfg.CheAPIEndpoint != "http://file/api" {
+		t.Errorf("endpoint should come from the file, got %s", cfg.CheAPIEndpoint)
+	}
+	if cfg.Namespace != "env-ns" {
+		t.Errorf("namespace should come from the env, got %s", cfg.Namespace)
+	}
+	if cfg.RequestTimeout.Duration != 30*time.Second {
+		t.Errorf("request timeout should come from the flag, got %s", cfg.RequestTimeout)
+	}
+	if cfg.WorkspacePollInterval.Duration != 2*time.Second {
+		t.Errorf("workspace poll interval should be the default, got %s", cfg.WorkspacePollInterval)
+	}
+	if len(cfg.Features) != 2 || cfg.Features[1] != "b" {
+		t.Errorf("unexpected features %v", cfg.Features)
+	}
+}
+
+func TestConfigFileRejectsUnknownKeys(t *testing.T) {
+	dir, err := ioutil.TempDir("", "che-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	configFile := filepath.Join(dir, "config.json")
+	if err := ioutil.WriteFile(configFile, []byte(`{"endpiont": "http://typo/api"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := LoadConfigFile(configFile); err == nil {
+		t.Error("expected an error for an unknown key")
+	}
+}
+
+func TestConfigFileIsCheckedLikeTheFlags(t *testing.T) {
+	dir, err := ioutil.TempDir("", "che-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	configFile := filepath.Join(dir, "config.yaml")
+	for _, content := range []string{"concurrency: 0\n", "fake: 4\n", "cheVersion: \"8\"\n"} {
+		if err := ioutil.WriteFile(configFile, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := LoadConfigFile(configFile); err == nil {
+			t.Errorf("expected %q to be rejected", content)
+		}
+	}
+
+	if err := ioutil.WriteFile(configFile, []byte("concurrency: 4\nfake: 7\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadConfigFile(configFile); err != nil {
+		t.Errorf("expected valid values to be accepted, got %v", err)
+	}
+}
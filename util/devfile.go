@@ -0,0 +1,396 @@
+/*
+Copyright (C) 2017 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+//Devfile component types
+const (
+	CheEditorComponent   = "cheEditor"
+	ChePluginComponent   = "chePlugin"
+	DockerimageComponent = "dockerimage"
+	KubernetesComponent  = "kubernetes"
+	OpenshiftComponent   = "openshift"
+)
+
+//Devfile is a Che7 workspace definition. Only the parts the tests look at are typed,
+//the devfile is posted to Che as it was read.
+type Devfile struct {
+	APIVersion string             `json:"apiVersion" yaml:"apiVersion"`
+	Metadata   DevfileMetadata    `json:"metadata" yaml:"metadata"`
+	Projects   []DevfileProject   `json:"projects,omitempty" yaml:"projects,omitempty"`
+	Components []DevfileComponent `json:"components,omitempty" yaml:"components,omitempty"`
+	Commands   []DevfileCommand   `json:"commands,omitempty" yaml:"commands,omitempty"`
+
+	raw map[string]interface{}
+}
+
+//DevfileMetadata names the workspace created from a devfile
+type DevfileMetadata struct {
+	Name         string `json:"name,omitempty" yaml:"name,omitempty"`
+	GenerateName string `json:"generateName,omitempty" yaml:"generateName,omitempty"`
+}
+
+//DevfileProject is a project cloned into the workspace
+type DevfileProject struct {
+	Name   string           `json:"name" yaml:"name"`
+	Source SampleSourceType `json:"source" yaml:"source"`
+}
+
+//DevfileComponent is the editor, a plugin or a container of a devfile
+type DevfileComponent struct {
+	Type         string `json:"type" yaml:"type"`
+	Alias        string `json:"alias,omitempty" yaml:"alias,omitempty"`
+	ID           string `json:"id,omitempty" yaml:"id,omitempty"`
+	Reference    string `json:"reference,omitempty" yaml:"reference,omitempty"`
+	Image        string `json:"image,omitempty" yaml:"image,omitempty"`
+	MemoryLimit  string `json:"memoryLimit,omitempty" yaml:"memoryLimit,omitempty"`
+	MountSources bool   `json:"mountSources,omitempty" yaml:"mountSources,omitempty"`
+}
+
+//DevfileCommand is a command of a devfile, run by its actions
+type DevfileCommand struct {
+	Name    string          `json:"name" yaml:"name"`
+	Actions []DevfileAction `json:"actions" yaml:"actions"`
+}
+
+//DevfileAction is what a devfile command runs, and in which component
+type DevfileAction struct {
+	Type      string `json:"type" yaml:"type"`
+	Component string `json:"component" yaml:"component"`
+	Command   string `json:"command" yaml:"command"`
+	Workdir   string `json:"workdir,omitempty" yaml:"workdir,omitempty"`
+}
+
+//ParseDevfile reads a devfile in YAML or JSON
+func ParseDevfile(data []byte) (Devfile, error) {
+	var devfile Devfile
+	if yamlErr := yaml.Unmarshal(data, &devfile); yamlErr != nil {
+		return devfile, fmt.Errorf("Invalid devfile: %v", yamlErr)
+	}
+
+	var raw interface{}
+	if yamlErr := yaml.Unmarshal(data, &raw); yamlErr != nil {
+		return devfile, fmt.Errorf("Invalid devfile: %v", yamlErr)
+	}
+	rawMap, ok := jsonValue(raw).(map[string]interface{})
+	if !ok {
+		return devfile, fmt.Errorf("Invalid devfile: not a map")
+	}
+	devfile.raw = rawMap
+
+	if devfile.APIVersion == "" {
+		return devfile, fmt.Errorf("Invalid devfile: apiVersion is missing")
+	}
+
+	return devfile, nil
+}
+
+//LoadDevfile reads the devfile at filename
+func LoadDevfile(filename string) (Devfile, error) {
+	data, readErr := ioutil.ReadFile(filename)
+	if readErr != nil {
+		return Devfile{}, readErr
+	}
+	return ParseDevfile(data)
+}
+
+//MarshalJSON writes the devfile as it was read, with the changes made through its setters
+func (d Devfile) MarshalJSON() ([]byte, error) {
+	if d.raw == nil {
+		type plain Devfile
+		return json.Marshal(plain(d))
+	}
+	return json.Marshal(d.raw)
+}
+
+//SetName names the workspace created from the devfile name
+func (d *Devfile) SetName(name string) {
+	d.Metadata = DevfileMetadata{Name: name}
+	if d.raw != nil {
+		d.raw["metadata"] = map[string]interface{}{"name": name}
+	}
+}
+
+//Editor is the cheEditor component of the devfile, nil when Che picks its default editor
+func (d Devfile) Editor() *DevfileComponent {
+	for index := range d.Components {
+		if d.Components[index].Type == CheEditorComponent {
+			return &d.Components[index]
+		}
+	}
+	return nil
+}
+
+//StackCommands are the exec commands of the devfile as stack commands, run in the directory of their action
+func (d Devfile) StackCommands() []Command {
+	var commands []Command
+	for _, command := range d.Commands {
+		for _, action := range command.Actions {
+			if action.Type != "exec" {
+				continue
+			}
+			commandLine := action.Command
+			if action.Workdir != "" {
+				commandLine = "cd " + action.Workdir + " && " + commandLine
+			}
+			commands = append(commands, Command{Name: command.Name, CommandLine: commandLine, Type: "exec"})
+			break
+		}
+	}
+	return commands
+}
+
+//jsonValue turns the maps yaml.v2 reads into maps encoding/json can write
+func jsonValue(value interface{}) interface{} {
+	switch typed := value.(type) {
+	case map[interface{}]interface{}:
+		converted := make(map[string]interface{}, len(typed))
+		for key, item := range typed {
+			converted[fmt.Sprint(key)] = jsonValue(item)
+		}
+		return converted
+	case []interface{}:
+		for index, item := range typed {
+			typed[index] = jsonValue(item)
+		}
+		return typed
+	default:
+		return value
+	}
+}
+
+//DevfileEntry is a devfile listed by the devfile registry
+type DevfileEntry struct {
+	DisplayName string   `json:"displayName"`
+	Description string   `json:"description"`
+	Tags        []string `json:"tags"`
+	Links       struct {
+		Self string `json:"self"`
+	} `json:"links"`
+}
+
+//PluginMeta is the description of an editor or plugin in the plugin registry
+type PluginMeta struct {
+	ID          string `yaml:"-"`
+	Publisher   string `yaml:"publisher"`
+	Name        string `yaml:"name"`
+	Version     string `yaml:"version"`
+	Type        string `yaml:"type"`
+	DisplayName string `yaml:"displayName"`
+}
+
+//ComponentError lists the editor and plugin components of a devfile the plugin registry does not have
+type ComponentError struct {
+	Missing []string
+}
+
+func (e *ComponentError) Error() string {
+	return fmt.Sprintf("The plugin registry has no %s", strings.Join(e.Missing, ", "))
+}
+
+type workspaceSettings struct {
+	DevfileRegistryURL string `json:"cheWorkspaceDevfileRegistryUrl"`
+	PluginRegistryURL  string `json:"cheWorkspacePluginRegistryUrl"`
+}
+
+//registries fills in the devfile and plugin registries that were not configured from the workspace settings of the Che7 master
+func (c *CheAPI) registries(ctx context.Context) error {
+	if c.DevfileRegistryURL != "" && c.PluginRegistryURL != "" {
+		return nil
+	}
+
+	settingsJSON, _, reqErr := c.doRequest(ctx, http.MethodGet, c.CheAPIEndpoint+"/workspace/settings", "")
+	if reqErr != nil {
+		return reqErr
+	}
+
+	var settings workspaceSettings
+	if jsonErr := json.Unmarshal(settingsJSON, &settings); jsonErr != nil {
+		return jsonErr
+	}
+
+	if c.DevfileRegistryURL == "" {
+		c.DevfileRegistryURL = strings.TrimSuffix(settings.DevfileRegistryURL, "/")
+	}
+	if c.PluginRegistryURL == "" {
+		c.PluginRegistryURL = strings.TrimSuffix(settings.PluginRegistryURL, "/")
+	}
+	return nil
+}
+
+//GetDevfileStacks reads the devfiles of the devfile registry as stacks, named by their display name
+func (c *CheAPI) GetDevfileStacks(ctx context.Context) ([]Workspace, error) {
+	if registriesErr := c.registries(ctx); registriesErr != nil {
+		return nil, registriesErr
+	}
+	if c.DevfileRegistryURL == "" {
+		return nil, fmt.Errorf("Che has no devfile registry")
+	}
+
+	indexJSON, _, reqErr := c.doPublicRequest(ctx, c.DevfileRegistryURL+"/devfiles/index.json")
+	if reqErr != nil {
+		return nil, reqErr
+	}
+
+	var entries []DevfileEntry
+	if jsonErr := json.Unmarshal(indexJSON, &entries); jsonErr != nil {
+		return nil, jsonErr
+	}
+
+	var stacks []Workspace
+	for _, entry := range entries {
+		devfileURL, urlErr := resolveReference(c.DevfileRegistryURL, entry.Links.Self)
+		if urlErr != nil {
+			return stacks, urlErr
+		}
+
+		devfileData, _, devfileErr := c.doPublicRequest(ctx, devfileURL)
+		if devfileErr != nil {
+			return stacks, devfileErr
+		}
+
+		devfile, parseErr := ParseDevfile(devfileData)
+		if parseErr != nil {
+			return stacks, fmt.Errorf("%s: %v", devfileURL, parseErr)
+		}
+
+		stacks = append(stacks, Workspace{
+			ID:      path.Base(path.Dir(entry.Links.Self)),
+			Name:    entry.DisplayName,
+			Tags:    entry.Tags,
+			Command: devfile.StackCommands(),
+			Devfile: &devfile,
+		})
+	}
+
+	return stacks, nil
+}
+
+//ResolveComponents looks up the editor and plugin components of devfile in the plugin registry.
+//Components that are not there are returned together in a ComponentError.
+func (c *CheAPI) ResolveComponents(ctx context.Context, devfile Devfile) ([]PluginMeta, error) {
+	if registriesErr := c.registries(ctx); registriesErr != nil {
+		return nil, registriesErr
+	}
+
+	var plugins []PluginMeta
+	var missing []string
+
+	for _, component := range devfile.Components {
+		if component.Type != CheEditorComponent && component.Type != ChePluginComponent {
+			continue
+		}
+
+		metaURL := component.Reference
+		name := component.Reference
+		if component.ID != "" {
+			if c.PluginRegistryURL == "" {
+				return plugins, fmt.Errorf("Che has no plugin registry to resolve %s from", component.ID)
+			}
+			metaURL = c.PluginRegistryURL + "/plugins/" + component.ID + "/meta.yaml"
+			name = component.ID
+		}
+		if metaURL == "" {
+			return plugins, fmt.Errorf("The %s component %s has neither an id nor a reference", component.Type, component.Alias)
+		}
+
+		metaData, _, reqErr := c.doPublicRequest(ctx, metaURL)
+		if IsStatus(reqErr, http.StatusNotFound) {
+			missing = append(missing, component.Type+" "+name)
+			continue
+		}
+		if reqErr != nil {
+			return plugins, reqErr
+		}
+
+		plugin := PluginMeta{ID: name}
+		if yamlErr := yaml.Unmarshal(metaData, &plugin); yamlErr != nil {
+			return plugins, fmt.Errorf("Invalid meta.yaml of %s: %v", name, yamlErr)
+		}
+		plugins = append(plugins, plugin)
+	}
+
+	if len(missing) > 0 {
+		return plugins, &ComponentError{Missing: missing}
+	}
+	return plugins, nil
+}
+
+//StartDevfileWorkspace resolves the components of devfile, creates a workspace from it and starts it
+func (c *CheAPI) StartDevfileWorkspace(ctx context.Context, devfile Devfile, stackID string) (Workspace2, error) {
+	plugins, resolveErr := c.ResolveComponents(ctx, devfile)
+	if resolveErr != nil {
+		return Workspace2{}, resolveErr
+	}
+	for _, plugin := range plugins {
+		c.logf("Using %s %s %s", plugin.Type, plugin.ID, plugin.DisplayName)
+	}
+
+	devfile.SetName(testWorkspaceName(stackID))
+	marshalled, marshallErr := json.Marshal(devfile)
+	if marshallErr != nil {
+		return Workspace2{}, marshallErr
+	}
+
+	query := url.Values{}
+	query.Set("namespace", c.namespace())
+	query.Set("attribute", MarkerAttribute+":true")
+
+	started := time.Now()
+	workspaceDataJSON, _, reqErr := c.doRequest(ctx, http.MethodPost, c.CheAPIEndpoint+"/workspace/devfile?"+query.Encode(), string(marshalled))
+	if reqErr != nil {
+		return Workspace2{}, reqErr
+	}
+
+	var WorkspaceResponse Workspace2
+	if unmarshallErr := json.Unmarshal(workspaceDataJSON, &WorkspaceResponse); unmarshallErr != nil {
+		return Workspace2{}, unmarshallErr
+	}
+	c.createdWorkspaces = append(c.createdWorkspaces, WorkspaceResponse.ID)
+
+	if _, _, startErr := c.doRequest(ctx, http.MethodPost, c.CheAPIEndpoint+"/workspace/"+WorkspaceResponse.ID+"/runtime", ""); startErr != nil {
+		return WorkspaceResponse, startErr
+	}
+
+	return WorkspaceResponse, c.waitForStart(ctx, WorkspaceResponse.ID, started)
+}
+
+//resolveReference resolves the link ref of a registry against its base URL
+func resolveReference(base, ref string) (string, error) {
+	baseURL, parseErr := url.Parse(base + "/")
+	if parseErr != nil {
+		return "", parseErr
+	}
+	refURL, parseErr := url.Parse(strings.TrimPrefix(ref, "/"))
+	if parseErr != nil {
+		return "", parseErr
+	}
+	return baseURL.ResolveReference(refURL).String(), nil
+}
@@ -0,0 +1,151 @@
+/*
+Copyright (C) 2017 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDevfileIsPostedAsItWasRead(t *testing.T) {
+	devfile, err := ParseDevfile([]byte(FakeDevfiles()[0].Content))
+	if err != nil {
+		t.Fatal(err)
+	}
+	devfile.SetName("java-maven-stack-test")
+
+	marshalled, err := json.Marshal(devfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var posted map[string]interface{}
+	if err := json.Unmarshal(marshalled, &posted); err != nil {
+		t.Fatal(err)
+	}
+	if posted["metadata"].(map[string]interface{})["name"] != "java-maven-stack-test" {
+		t.Errorf("expected the name to replace generateName, got %v", posted["metadata"])
+	}
+	if !strings.Contains(string(marshalled), `"containerPath":"/home/user/.m2"`) {
+		t.Errorf("fields the tests do not know about should be posted too: %s", marshalled)
+	}
+
+	if editor := devfile.Editor(); editor == nil || editor.ID != "eclipse/che-theia/7.0.0" {
+		t.Errorf("unexpected editor %+v", editor)
+	}
+	commands := devfile.StackCommands()
+	if len(commands) != 1 || commands[0].CommandLine != "cd ${CHE_PROJECTS_ROOT}/console-java-simple && mvn clean install" {
+		t.Errorf("unexpected stack commands %+v", commands)
+	}
+}
+
+func TestParseDevfileNeedsAnAPIVersion(t *testing.T) {
+	if _, err := ParseDevfile([]byte("metadata:\n  name: broken\n")); err == nil {
+		t.Error("a devfile without apiVersion should be rejected")
+	}
+}
+
+func TestDevfileWorkspaceLifecycleAgainstFakeChe7(t *testing.T) {
+	fake, c := newFakeCheAPI(7)
+	defer fake.Close()
+
+	stacks, err := c.GetStackInformation(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	samples, err := c.GetSamplesInformation(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.GenerateDataForWorkspaces(stacks, samples)
+
+	stack, ok := c.GetStackConfigMap()["Java Maven"]
+	if !ok || stack.Devfile == nil {
+		t.Fatalf("expected the devfiles of the registry as stacks, got %+v", stacks)
+	}
+
+	workspace, err := c.StartDevfileWorkspace(context.Background(), *stack.Devfile, stack.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.SetWorkspaceID(workspace.ID)
+
+	agents, err := c.GetHTTPAgents(context.Background(), workspace.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.SetAgentsURL(agents)
+	if c.CheVersion != "7" || c.MachineExecURL == "" || c.EditorURL == "" || c.DevMachine != fakeDevMachine {
+		t.Fatalf("expected che-machine-exec, the editor and the devfile container, got %+v", agents)
+	}
+
+	sample := c.GetSamplesConfigMap()["https://github.com/che-samples/console-java-simple.git"]
+	if err := c.AddSamplesToProject(context.Background(), []Sample{sample}); err != nil {
+		t.Fatal(err)
+	}
+	projects, err := c.GetNumberOfProjects(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if projects != 1 {
+		t.Errorf("expected the cloned project, got %d projects", projects)
+	}
+
+	fake.SetProcess("mvn clean install -f /projects/console-java-simple", FakeProcess{
+		ExitCode: 1,
+		Output:   []string{"[INFO] Building", "[ERROR] BUILD FAILURE"},
+		Duration: 30 * time.Millisecond,
+	})
+	process, err := c.PostCommandToWorkspace(context.Background(), sample.Commands[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if process.Alive || process.ExitCode != 1 || process.OutputText() != "[INFO] Building\n[ERROR] BUILD FAILURE" {
+		t.Errorf("expected the exit code and output of the build, got %+v", process)
+	}
+
+	if err := c.StopWorkspace(context.Background(), workspace.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.RemoveWorkspace(context.Background(), workspace.ID); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMissingPluginsFailTheStart(t *testing.T) {
+	fake, c := newFakeCheAPI(7)
+	defer fake.Close()
+
+	fake.RemovePlugin("eclipse/che-machine-exec-plugin/7.0.0")
+
+	devfile, err := ParseDevfile([]byte(FakeDevfiles()[0].Content))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = c.StartDevfileWorkspace(context.Background(), devfile, "java-maven")
+	componentErr, ok := err.(*ComponentError)
+	if !ok || len(componentErr.Missing) != 1 || componentErr.Missing[0] != "chePlugin eclipse/che-machine-exec-plugin/7.0.0" {
+		t.Errorf("expected the missing plugin, got %v", err)
+	}
+	if fake.WorkspaceCount() != 0 {
+		t.Error("no workspace should be created when a component cannot be resolved")
+	}
+}
@@ -0,0 +1,134 @@
+/*
+Copyright (C) 2017 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+//maxErrorBody is how much of a body that is not a Che error is kept as the message
+const maxErrorBody = 500
+
+//CheAPIError is a failed request to Che, either a response with an error status or a request
+//that got no response at all, in which case StatusCode is 0 and Err is why
+type CheAPIError struct {
+	Method     string `json:"method"`
+	URL        string `json:"url"`
+	StatusCode int    `json:"statusCode"`
+	//Message is the message of the Che error body, or the body itself when it is not a Che error
+	Message string `json:"message"`
+	Err     error  `json:"-"`
+}
+
+func (e *CheAPIError) Error() string {
+	if e.StatusCode == 0 {
+		return fmt.Sprintf("%s %s failed: %v", e.Method, e.URL, e.Err)
+	}
+	if e.Message == "" {
+		return fmt.Sprintf("%s %s returned %d %s", e.Method, e.URL, e.StatusCode, http.StatusText(e.StatusCode))
+	}
+	return fmt.Sprintf("%s %s returned %d %s: %s", e.Method, e.URL, e.StatusCode, http.StatusText(e.StatusCode), e.Message)
+}
+
+//Unwrap returns why a request without a response failed, such as the context being cancelled
+func (e *CheAPIError) Unwrap() error {
+	return e.Err
+}
+
+//newCheAPIError reads the reason for the error status out of body, which Che sends as {"message": "..."}
+func newCheAPIError(method, url string, statusCode int, body []byte) *CheAPIError {
+	apiErr := &CheAPIError{Method: method, URL: url, StatusCode: statusCode}
+
+	var cheError struct {
+		Message string `json:"message"`
+	}
+	if json.Unmarshal(body, &cheError) == nil && cheError.Message != "" {
+		apiErr.Message = cheError.Message
+		return apiErr
+	}
+
+	apiErr.Message = strings.TrimSpace(string(body))
+	if len(apiErr.Message) > maxErrorBody {
+		apiErr.Message = apiErr.Message[:maxErrorBody] + "..."
+	}
+	return apiErr
+}
+
+//IsStatus tells whether err is a CheAPIError for a response with statusCode
+func IsStatus(err error, statusCode int) bool {
+	var apiErr *CheAPIError
+	return errors.As(err, &apiErr) && apiErr.StatusCode == statusCode
+}
+
+//Attributes Che6 sets on a workspace whose runtime stopped because of an error
+const (
+	ErrorMessageAttribute      = "error_message"
+	StoppedAbnormallyAttribute = "stopped_abnormally"
+)
+
+//WorkspaceStatusError is a workspace that reached a failure status, or none of the expected statuses in time
+type WorkspaceStatusError struct {
+	WorkspaceID string   `json:"workspaceId"`
+	Status      string   `json:"status"`
+	Targets     []string `json:"targets"`
+	//Message is why Che says the workspace failed, from its status event or its error attribute
+	Message     string            `json:"message,omitempty"`
+	Attributes  map[string]string `json:"attributes,omitempty"`
+	Transitions string            `json:"transitions,omitempty"`
+	Timeout     time.Duration     `json:"timeout,omitempty"`
+	Err         error             `json:"-"`
+}
+
+func (e *WorkspaceStatusError) Error() string {
+	var message string
+	if e.Err != nil {
+		message = fmt.Sprintf("Workspace %s is %s, it did not become %s within %s", e.WorkspaceID, e.Status, strings.Join(e.Targets, " or "), e.Timeout)
+	} else {
+		message = fmt.Sprintf("Workspace %s became %s instead of %s", e.WorkspaceID, e.Status, strings.Join(e.Targets, " or "))
+	}
+
+	if e.Message != "" {
+		message += ": " + e.Message
+	}
+	if e.Transitions != "" {
+		message += " (transitions: " + e.Transitions + ")"
+	}
+
+	var attributes []string
+	for name, value := range e.Attributes {
+		if name != ErrorMessageAttribute {
+			attributes = append(attributes, name+"="+value)
+		}
+	}
+	if len(attributes) > 0 {
+		sort.Strings(attributes)
+		message += " (attributes: " + strings.Join(attributes, ", ") + ")"
+	}
+
+	return message
+}
+
+//Unwrap returns the deadline error of a wait that timed out
+func (e *WorkspaceStatusError) Unwrap() error {
+	return e.Err
+}
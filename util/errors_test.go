@@ -0,0 +1,109 @@
+/*
+Copyright (C) 2017 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+func TestCheErrorsCarryTheServerMessage(t *testing.T) {
+	fake, c := newFakeCheAPI(6)
+	defer fake.Close()
+
+	stack := FakeStacks()[0]
+	workspace, err := c.StartWorkspace(context.Background(), stack.Config.EnvironmentConfig, stack.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//A running workspace cannot be removed
+	err = c.RemoveWorkspace(context.Background(), workspace.ID)
+	apiErr, ok := err.(*CheAPIError)
+	if !ok {
+		t.Fatalf("expected a CheAPIError, got %v", err)
+	}
+	if apiErr.Method != http.MethodDelete || apiErr.StatusCode != http.StatusConflict || !strings.HasSuffix(apiErr.URL, "/workspace/"+workspace.ID) {
+		t.Errorf("unexpected request in %+v", apiErr)
+	}
+	if apiErr.Message != "The workspace '"+workspace.ID+"' is currently running and cannot be removed." {
+		t.Errorf("the Che message should be parsed, got %q", apiErr.Message)
+	}
+	if !strings.Contains(apiErr.Error(), "returned 409 Conflict: The workspace") {
+		t.Errorf("unexpected error %q", apiErr.Error())
+	}
+}
+
+func TestErrorStatusesAreNotUnmarshalled(t *testing.T) {
+	fake, c := newFakeCheAPI(6)
+	defer fake.Close()
+	c.Retry.MaxAttempts = 1
+
+	fake.Fail(http.MethodGet, "/api/stack", http.StatusInternalServerError, "Database is down", 1)
+
+	_, err := c.GetStackInformation(context.Background())
+	if !IsStatus(err, http.StatusInternalServerError) || !strings.Contains(err.Error(), "Database is down") {
+		t.Errorf("expected the server error instead of an unmarshal error, got %v", err)
+	}
+}
+
+func TestNonCheErrorBodies(t *testing.T) {
+	apiErr := newCheAPIError(http.MethodGet, "http://che/api/stack", http.StatusBadGateway, []byte("<html>Bad Gateway</html>\n"))
+	if apiErr.Message != "<html>Bad Gateway</html>" {
+		t.Errorf("the body should be the message, got %q", apiErr.Message)
+	}
+
+	apiErr = newCheAPIError(http.MethodGet, "http://che/api/stack", http.StatusNotFound, nil)
+	if apiErr.Error() != "GET http://che/api/stack returned 404 Not Found" {
+		t.Errorf("unexpected error %q", apiErr.Error())
+	}
+}
+
+func TestFailedRequestsHaveNoResponse(t *testing.T) {
+	c := CheAPI{CheAPIEndpoint: "http://127.0.0.1:1/api"}
+
+	_, status, err := c.doRequest(context.Background(), http.MethodGet, c.CheAPIEndpoint+"/stack", "")
+	apiErr, ok := err.(*CheAPIError)
+	if !ok || status != 0 || apiErr.StatusCode != 0 || apiErr.Err == nil {
+		t.Errorf("expected a CheAPIError without a status, got %d %v", status, err)
+	}
+}
+
+func TestWrappedErrorsKeepTheirStatus(t *testing.T) {
+	apiErr := newCheAPIError(http.MethodGet, "http://che/api/stack/stack1", http.StatusNotFound, nil)
+	wrapped := fmt.Errorf("Could not delete stack1: %w", apiErr)
+	if !IsStatus(wrapped, http.StatusNotFound) {
+		t.Errorf("the status should be found through the wrapping, got %v", wrapped)
+	}
+
+	refused := &CheAPIError{Method: http.MethodGet, URL: "http://127.0.0.1:1/api", Err: &net.OpError{Op: "dial", Err: os.NewSyscallError("connect", syscall.ECONNREFUSED)}}
+	if !connectionRefused(fmt.Errorf("Could not detect the Che version: %w", refused)) {
+		t.Error("a refused connection should be found through the wrapping")
+	}
+
+	report := NewReport("http://che/api").StartCase("feature", "scenario")
+	report.End(&CheAPI{}, fmt.Errorf("Stopping failed: %w", &WorkspaceStatusError{WorkspaceID: "workspace1", Status: "RUNNING"}))
+	if report.StatusError == nil || report.StatusError.WorkspaceID != "workspace1" {
+		t.Errorf("the report should carry the wrapped status error, got %+v", report)
+	}
+}
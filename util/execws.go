@@ -0,0 +1,222 @@
+/*
+Copyright (C) 2017 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+)
+
+//Exec agent process event types
+const (
+	ProcessStarted = "process_started"
+	ProcessStdout  = "process_stdout"
+	ProcessStderr  = "process_stderr"
+	ProcessDied    = "process_died"
+)
+
+//ProcessEvent is an event the exec agent sends about a process over its WebSocket
+type ProcessEvent struct {
+	Type     string    `json:"-"`
+	Pid      int       `json:"pid"`
+	Time     time.Time `json:"time"`
+	Text     string    `json:"text"`
+	ExitCode int       `json:"exitCode"`
+}
+
+//ExecAgentStream is a JSON-RPC connection to the Che6 exec agent delivering process events as they happen
+type ExecAgentStream struct {
+	conn *jsonRPCConn
+
+	mu          sync.Mutex
+	subscribers map[int]processSubscriber
+}
+
+//processSubscriber receives the events of a process until its context is done
+type processSubscriber struct {
+	events chan ProcessEvent
+	done   <-chan struct{}
+}
+
+//processEventMask subscribes to the output of a process and to process_status, which covers its start and death
+const processEventMask = "stdout,stderr,process_status"
+
+type processSubscribeParams struct {
+	Pid        int    `json:"pid"`
+	EventTypes string `json:"eventTypes"`
+	After      string `json:"after,omitempty"`
+}
+
+//DialExecAgent connects to the exec agent WebSocket of the current workspace
+func (c *CheAPI) DialExecAgent(ctx context.Context) (*ExecAgentStream, error) {
+	if c.ExecAgentWSURL == "" {
+		return nil, fmt.Errorf("The workspace runtime has no exec agent WebSocket")
+	}
+
+	stream := &ExecAgentStream{subscribers: make(map[int]processSubscriber)}
+
+	conn, dialErr := dialJSONRPC(ctx, c.ExecAgentWSURL, c.Auth, stream.handleNotification, stream.handleClose)
+	if dialErr != nil {
+		return nil, dialErr
+	}
+	stream.conn = conn
+
+	return stream, nil
+}
+
+//Subscribe delivers the output and exit of process pid through the returned channel.
+//Output printed after the given time is replayed. The channel is closed once the process has died, the connection is lost
+//or ctx is done, after which no more events are delivered.
+func (s *ExecAgentStream) Subscribe(ctx context.Context, pid int, after time.Time) (<-chan ProcessEvent, error) {
+	events := make(chan ProcessEvent, 1024)
+
+	s.mu.Lock()
+	s.subscribers[pid] = processSubscriber{events: events, done: ctx.Done()}
+	s.mu.Unlock()
+
+	params := processSubscribeParams{
+		Pid:        pid,
+		EventTypes: processEventMask,
+	}
+	if !after.IsZero() {
+		params.After = after.UTC().Format(time.RFC3339Nano)
+	}
+
+	if callErr := s.conn.call(ctx, "process.subscribe", params, nil); callErr != nil {
+		s.unsubscribe(pid, events)
+		return nil, callErr
+	}
+
+	return events, nil
+}
+
+//Close closes the connection to the exec agent
+func (s *ExecAgentStream) Close() error {
+	return s.conn.Close()
+}
+
+func (s *ExecAgentStream) handleNotification(method string, params json.RawMessage) {
+	switch method {
+	case ProcessStarted, ProcessStdout, ProcessStderr, ProcessDied:
+	default:
+		return
+	}
+
+	var event ProcessEvent
+	if json.Unmarshal(params, &event) != nil {
+		return
+	}
+	event.Type = method
+
+	//Notifications and the close are handled by the same read loop, so the channel
+	//cannot be closed while the event is sent
+	s.mu.Lock()
+	subscriber, ok := s.subscribers[event.Pid]
+	if ok && event.Type == ProcessDied {
+		delete(s.subscribers, event.Pid)
+	}
+	s.mu.Unlock()
+
+	if !ok {
+		return
+	}
+
+	//A subscriber that gave up no longer reads, blocking on it would stall every other process of the connection
+	select {
+	case subscriber.events <- event:
+	case <-subscriber.done:
+		s.unsubscribe(event.Pid, subscriber.events)
+		close(subscriber.events)
+		return
+	}
+	if event.Type == ProcessDied {
+		close(subscriber.events)
+	}
+}
+
+//unsubscribe forgets the subscription of pid delivering to events, unless it has been replaced by a newer one already
+func (s *ExecAgentStream) unsubscribe(pid int, events chan ProcessEvent) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if subscriber, ok := s.subscribers[pid]; ok && subscriber.events == events {
+		delete(s.subscribers, pid)
+	}
+}
+
+func (s *ExecAgentStream) handleClose(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for pid, subscriber := range s.subscribers {
+		close(subscriber.events)
+		delete(s.subscribers, pid)
+	}
+}
+
+//waitForProcessEvents follows process over the exec agent WebSocket until it dies. A died event that
+//never arrives does not leave it waiting, the REST API is asked every poll interval whether the process still runs.
+func (c *CheAPI) waitForProcessEvents(ctx context.Context, process ProcessRecord) (ProcessRecord, error) {
+	stream, dialErr := c.DialExecAgent(ctx)
+	if dialErr != nil {
+		return process, dialErr
+	}
+	defer stream.Close()
+
+	events, subscribeErr := stream.Subscribe(ctx, process.Pid, process.Started.Add(-time.Second))
+	if subscribeErr != nil {
+		return process, subscribeErr
+	}
+
+	liveness := time.NewTicker(durationOrDefault(c.ProcessPollInterval, 15*time.Second))
+	defer liveness.Stop()
+
+	process.Output = nil
+	for {
+		select {
+		case event, ok := <-events:
+			if !ok {
+				return process, fmt.Errorf("Lost the exec agent connection before process %d died", process.Pid)
+			}
+
+			switch event.Type {
+			case ProcessStdout, ProcessStderr:
+				process.Output = append(process.Output, event.Text)
+			case ProcessDied:
+				process.Alive = false
+				process.ExitCode = event.ExitCode
+				process.Finished = time.Now()
+				process.Duration = process.Finished.Sub(process.Started)
+				return process, nil
+			}
+		case <-liveness.C:
+			processData, processErr := c.GetCommandExitCode(ctx, process.Pid)
+			if processErr != nil {
+				return process, processErr
+			}
+			if !processData.Alive {
+				c.logf("Process %d died without the exec agent telling, reading its output from the REST API", process.Pid)
+				return c.refreshProcess(ctx, process)
+			}
+		case <-ctx.Done():
+			return process, ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,218 @@
+/*
+Copyright (C) 2017 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExecAgentStreamDeliversEvents(t *testing.T) {
+	fake, c := newFakeCheAPI(6)
+	defer fake.Close()
+	startFakeWorkspace(t, fake, &c)
+
+	if c.ExecAgentWSURL == "" {
+		t.Fatal("the Che6 runtime should expose the exec agent WebSocket")
+	}
+
+	fake.SetProcess("mvn test", FakeProcess{
+		ExitCode: 2,
+		Output:   []string{"one", "two", "three"},
+		Duration: 60 * time.Millisecond,
+	})
+
+	process, err := c.StartProcess(context.Background(), Command{Name: "test", CommandLine: "mvn test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stream, err := c.DialExecAgent(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stream.Close()
+
+	events, err := stream.Subscribe(context.Background(), process.Pid, process.Started)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var lines []string
+	var died *ProcessEvent
+	for event := range events {
+		event := event
+		switch event.Type {
+		case ProcessStdout:
+			lines = append(lines, event.Text)
+		case ProcessDied:
+			died = &event
+		}
+	}
+
+	if strings.Join(lines, ",") != "one,two,three" {
+		t.Errorf("unexpected output %v", lines)
+	}
+	if died == nil || died.ExitCode != 2 {
+		t.Errorf("expected the process to die with 2, got %+v", died)
+	}
+}
+
+func TestWaitForProcessUsesTheStream(t *testing.T) {
+	fake, c := newFakeCheAPI(6)
+	defer fake.Close()
+	startFakeWorkspace(t, fake, &c)
+
+	fake.SetProcess("mvn test", FakeProcess{ExitCode: 0, Output: []string{"BUILD SUCCESS"}, Duration: 30 * time.Millisecond})
+	//Only the stream can tell the process died before the liveness check asks the REST API
+	c.ProcessPollInterval = time.Minute
+
+	process, err := c.StartProcess(context.Background(), Command{Name: "test", CommandLine: "mvn test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	process, err = c.WaitForProcess(context.Background(), process)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if process.Alive || process.ExitCode != 0 || process.OutputText() != "BUILD SUCCESS" {
+		t.Errorf("unexpected process %+v", process)
+	}
+
+	for _, request := range fake.Requests() {
+		if strings.HasSuffix(request, "/logs") {
+			t.Errorf("the logs should not be polled while streaming, got %s", request)
+		}
+	}
+}
+
+func TestGoneSubscribersDoNotStallTheStream(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	gone := make(chan ProcessEvent)
+	waiting := make(chan ProcessEvent, 1)
+	stream := &ExecAgentStream{subscribers: map[int]processSubscriber{
+		1: {events: gone, done: ctx.Done()},
+		2: {events: waiting, done: context.Background().Done()},
+	}}
+
+	delivered := make(chan struct{})
+	go func() {
+		stream.handleNotification(ProcessStdout, []byte(`{"pid": 1, "text": "nobody reads this"}`))
+		stream.handleNotification(ProcessStdout, []byte(`{"pid": 2, "text": "still delivered"}`))
+		close(delivered)
+	}()
+
+	select {
+	case <-delivered:
+	case <-time.After(time.Second):
+		t.Fatal("the read loop blocked on a subscriber that is gone")
+	}
+	if event := <-waiting; event.Text != "still delivered" {
+		t.Errorf("unexpected event %+v", event)
+	}
+	if _, open := <-gone; open {
+		t.Error("the channel of a subscriber that is gone should be closed")
+	}
+}
+
+func TestExecAgentOnlySendsTheSubscribedEvents(t *testing.T) {
+	fake, c := newFakeCheAPI(6)
+	defer fake.Close()
+	startFakeWorkspace(t, fake, &c)
+
+	fake.SetProcess("mvn test", FakeProcess{ExitCode: 1, Output: []string{"one", "two", "three"}})
+	process, err := c.StartProcess(context.Background(), Command{Name: "test", CommandLine: "mvn test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stream, err := c.DialExecAgent(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stream.Close()
+
+	//The fake prints a line every millisecond after the start, so only "three" is after the first millisecond
+	fake.mu.Lock()
+	started := fake.workspaces[c.GetWorkspaceID()].processes[process.Pid].started
+	fake.mu.Unlock()
+
+	stream.mu.Lock()
+	events := make(chan ProcessEvent, 10)
+	stream.subscribers[process.Pid] = processSubscriber{events: events, done: context.Background().Done()}
+	stream.mu.Unlock()
+	params := processSubscribeParams{Pid: process.Pid, EventTypes: "stdout,process_died", After: started.Add(time.Millisecond).UTC().Format(time.RFC3339Nano)}
+	if err := stream.conn.call(context.Background(), "process.subscribe", params, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case event := <-events:
+		if event.Type != ProcessStdout || event.Text != "three" {
+			t.Errorf("expected only the output after the given time, got %+v", event)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected the output after the given time")
+	}
+	select {
+	case event := <-events:
+		t.Errorf("process_died is not an event type, the death should not be sent: %+v", event)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestWaitForProcessNoticesALostDeath(t *testing.T) {
+	fake, c := newFakeCheAPI(6)
+	defer fake.Close()
+	startFakeWorkspace(t, fake, &c)
+
+	fake.SilenceEvents()
+	fake.SetProcess("mvn test", FakeProcess{ExitCode: 3, Output: []string{"BUILD FAILURE"}, Duration: 30 * time.Millisecond})
+	process, err := c.StartProcess(context.Background(), Command{Name: "test", CommandLine: "mvn test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	process, err = c.waitForProcessEvents(ctx, process)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if process.Alive || process.ExitCode != 3 || process.OutputText() != "BUILD FAILURE" {
+		t.Errorf("expected the exit code and output of the REST API, got %+v", process)
+	}
+}
+
+func TestGoneSubscribersLeaveNewerSubscriptionsAlone(t *testing.T) {
+	gone := make(chan ProcessEvent)
+	newer := make(chan ProcessEvent, 1)
+	stream := &ExecAgentStream{subscribers: map[int]processSubscriber{1: {events: newer, done: context.Background().Done()}}}
+
+	stream.unsubscribe(1, gone)
+	if subscriber, ok := stream.subscribers[1]; !ok || subscriber.events != newer {
+		t.Error("a subscriber that is gone should not remove the newer subscription to the same process")
+	}
+	stream.unsubscribe(1, newer)
+	if _, ok := stream.subscribers[1]; ok {
+		t.Error("expected the subscription to be removed")
+	}
+}
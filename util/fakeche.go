@@ -0,0 +1,1002 @@
+/*
+Copyright (C) 2017 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+//FakeTransition is a workspace status the fake server reports After the transition started.
+//An Error is reported as the reason of the status, like Che does for a runtime that failed.
+type FakeTransition struct {
+	Status string
+	After  time.Duration
+	Error  string
+}
+
+//FakeProcess scripts what a command does when it is run through the fake exec agent.
+//A process that runs Forever never exits, like a server started by a "run" command.
+type FakeProcess struct {
+	ExitCode int
+	Output   []string
+	Duration time.Duration
+	Forever  bool
+}
+
+type fakeFault struct {
+	method     string
+	pathPrefix string
+	status     int
+	message    string
+	delay      time.Duration
+	times      int
+	used       int
+}
+
+type fakeWorkspace struct {
+	id          string
+	post        Post
+	devfile     map[string]interface{}
+	attributes  map[string]string
+	transitions []FakeTransition
+	changedAt   time.Time
+	projects    []Sample
+	processes   map[int]*fakeProcess
+}
+
+type fakeProcess struct {
+	pid     int
+	command Command
+	script  FakeProcess
+	started time.Time
+}
+
+//FakeChe is an in memory Che master, wsagent and exec agent served over httptest.
+//It serves the Che5 or Che6 runtime shape depending on Version and can be scripted
+//with state transitions, failures and delays. As Che7 it has no stacks, but devfile
+//and plugin registries, and runs commands through che-machine-exec.
+type FakeChe struct {
+	*httptest.Server
+	Version int
+	//Build is the version of the API root service descriptor, without it the version has to be told from the endpoints
+	Build string
+
+	mu               sync.Mutex
+	stacks           []Workspace
+	samples          []Sample
+	workspaces       map[string]*fakeWorkspace
+	startTransitions []FakeTransition
+	stopTransitions  []FakeTransition
+	processScripts   map[string]FakeProcess
+	defaultProcess   FakeProcess
+	silentEvents     bool
+	faults           []*fakeFault
+	requests         []string
+	masterSockets    map[*websocket.Conn]bool
+	devfiles         []FakeDevfile
+	plugins          map[string]string
+	stackIcons       map[string]StackIcon
+	nextID           int
+	nextPid          int
+}
+
+//fakeBuilds are the versions fake servers describe themselves with, Che5 only has the endpoints to tell it by
+var fakeBuilds = map[int]string{6: "6.19.0", 7: "7.3.0"}
+
+//NewFakeChe starts a fake Che server of the given major version (5, 6 or 7) seeded with a small stack and sample catalog
+func NewFakeChe(version int) *FakeChe {
+	f := &FakeChe{
+		Version:    version,
+		Build:      fakeBuilds[version],
+		stacks:     FakeStacks(),
+		samples:    FakeSamples(),
+		workspaces: make(map[string]*fakeWorkspace),
+		startTransitions: []FakeTransition{
+			{Status: "STARTING"},
+			{Status: "RUNNING", After: 50 * time.Millisecond},
+		},
+		stopTransitions: []FakeTransition{
+			{Status: "STOPPING"},
+			{Status: "STOPPED", After: 50 * time.Millisecond},
+		},
+		processScripts: make(map[string]FakeProcess),
+		masterSockets:  make(map[*websocket.Conn]bool),
+		devfiles:       FakeDevfiles(),
+		plugins:        FakePlugins(),
+		stackIcons:     make(map[string]StackIcon),
+		defaultProcess: FakeProcess{Duration: 50 * time.Millisecond},
+	}
+	f.Server = httptest.NewServer(http.HandlerFunc(f.serveHTTP))
+	return f
+}
+
+//APIEndpoint is the Che master API endpoint of the fake server
+func (f *FakeChe) APIEndpoint() string {
+	return f.URL + "/api"
+}
+
+//SamplesURL is the URL the fake server serves its samples.json catalog on
+func (f *FakeChe) SamplesURL() string {
+	return f.URL + "/samples.json"
+}
+
+//Config points cfg at the fake server and shortens the poll intervals and retry backoffs
+func (f *FakeChe) Config(cfg Config) Config {
+	cfg.CheAPIEndpoint = f.APIEndpoint()
+	cfg.SamplesURL = f.SamplesURL()
+	cfg.WorkspacePollInterval = Duration{20 * time.Millisecond}
+	cfg.ProcessPollInterval = Duration{20 * time.Millisecond}
+	cfg.Retry.InitialBackoff = Duration{10 * time.Millisecond}
+	cfg.Retry.MaxBackoff = Duration{50 * time.Millisecond}
+	return cfg
+}
+
+//SetStacks replaces the stacks served on /stack
+func (f *FakeChe) SetStacks(stacks []Workspace) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.stacks = stacks
+}
+
+//SetSamples replaces the samples served on /samples.json
+func (f *FakeChe) SetSamples(samples []Sample) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.samples = samples
+}
+
+//SetStartTransitions sets the statuses a workspace goes through after it is started
+func (f *FakeChe) SetStartTransitions(transitions ...FakeTransition) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.startTransitions = transitions
+}
+
+//SetStopTransitions sets the statuses a workspace goes through after it is stopped
+func (f *FakeChe) SetStopTransitions(transitions ...FakeTransition) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.stopTransitions = transitions
+}
+
+//SetProcess scripts the process started for commandLine, any other command exits 0 after a short delay
+func (f *FakeChe) SetProcess(commandLine string, process FakeProcess) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.processScripts[commandLine] = process
+}
+
+//Fail makes the next times requests whose method and path prefix match fail with status and a Che error message.
+//A times of zero or less fails every matching request.
+func (f *FakeChe) Fail(method, pathPrefix string, status int, message string, times int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.faults = append(f.faults, &fakeFault{method: method, pathPrefix: pathPrefix, status: status, message: message, times: times})
+}
+
+//Delay makes every request whose method and path prefix match wait for delay before it is served
+func (f *FakeChe) Delay(method, pathPrefix string, delay time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.faults = append(f.faults, &fakeFault{method: method, pathPrefix: pathPrefix, delay: delay})
+}
+
+//SilenceEvents makes the WebSockets of the master and the exec agent accept subscriptions but send no events,
+//as when they get lost
+func (f *FakeChe) SilenceEvents() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.silentEvents = true
+}
+
+//Requests returns every request served so far as "METHOD path"
+func (f *FakeChe) Requests() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.requests...)
+}
+
+//WorkspaceCount returns the number of workspaces that currently exist on the fake server
+func (f *FakeChe) WorkspaceCount() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.workspaces)
+}
+
+func (f *FakeChe) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	f.requests = append(f.requests, r.Method+" "+r.URL.Path)
+	var delay time.Duration
+	var failure *fakeFault
+	for _, fault := range f.faults {
+		if fault.method != r.Method || !strings.HasPrefix(r.URL.Path, fault.pathPrefix) {
+			continue
+		}
+		if fault.delay > 0 {
+			delay += fault.delay
+			continue
+		}
+		if failure == nil && (fault.times <= 0 || fault.used < fault.times) {
+			failure = fault
+			fault.used++
+		}
+	}
+	f.mu.Unlock()
+
+	time.Sleep(delay)
+
+	if failure != nil {
+		writeFakeError(w, failure.status, failure.message)
+		return
+	}
+
+	path := strings.Trim(r.URL.Path, "/")
+	parts := strings.Split(path, "/")
+
+	switch {
+	case path == "samples.json":
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		writeFakeJSON(w, http.StatusOK, f.samples)
+	case path == "api/websocket" && f.Version >= 6:
+		f.serveMasterWS(w, r)
+	case parts[0] == "api":
+		f.serveMaster(w, r, parts[1:])
+	case parts[0] == "wsagent" && len(parts) >= 3:
+		f.serveWSAgent(w, r, parts[1], parts[3:])
+	case parts[0] == "exec-agent" && len(parts) == 3 && parts[2] == "connect":
+		f.serveExecAgentWS(w, r, parts[1])
+	case parts[0] == "exec-agent" && len(parts) >= 3:
+		f.serveExecAgent(w, r, parts[1], parts[3:])
+	case parts[0] == "devfile-registry":
+		f.serveDevfileRegistry(w, r, parts[1:])
+	case parts[0] == "plugin-registry":
+		f.servePluginRegistry(w, r, parts[1:])
+	case parts[0] == "machine-exec" && len(parts) >= 3:
+		f.serveMachineExec(w, r, parts[1], parts[2:])
+	case parts[0] == "app" && len(parts) >= 2:
+		f.serveApp(w, r, parts[1])
+	default:
+		writeFakeError(w, http.StatusNotFound, "Not found: "+r.URL.Path)
+	}
+}
+
+func (f *FakeChe) serveMaster(w http.ResponseWriter, r *http.Request, parts []string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	switch {
+	case len(parts) == 0 && r.Method == http.MethodGet && f.Build != "":
+		writeFakeJSON(w, http.StatusOK, ServiceDescriptor{Description: "Eclipse Che API", Version: f.Build})
+
+	case len(parts) == 2 && parts[0] == "system" && parts[1] == "state" && r.Method == http.MethodGet && f.Version >= 6:
+		writeFakeJSON(w, http.StatusOK, map[string]string{"status": "RUNNING"})
+
+	case len(parts) >= 1 && parts[0] == "stack" && f.Version < 7:
+		f.serveStack(w, r, parts[1:])
+
+	case len(parts) == 2 && parts[0] == "workspace" && parts[1] == "settings" && r.Method == http.MethodGet && f.Version >= 7:
+		writeFakeJSON(w, http.StatusOK, workspaceSettings{
+			DevfileRegistryURL: f.URL + "/devfile-registry",
+			PluginRegistryURL:  f.URL + "/plugin-registry",
+		})
+
+	case len(parts) == 2 && parts[0] == "workspace" && parts[1] == "devfile" && r.Method == http.MethodPost && f.Version >= 7:
+		f.serveDevfileWorkspace(w, r)
+
+	case len(parts) == 1 && parts[0] == "workspace" && r.Method == http.MethodPost:
+		var post Post
+		if err := json.NewDecoder(r.Body).Decode(&post); err != nil {
+			writeFakeError(w, http.StatusBadRequest, "Invalid workspace configuration: "+err.Error())
+			return
+		}
+		if f.nameTaken(post.Namespace, post.Name) {
+			writeFakeError(w, http.StatusConflict, fmt.Sprintf("Workspace '%s' already exists in namespace '%s'", post.Name, post.Namespace))
+			return
+		}
+		ws := f.newWorkspace(post, time.Now())
+		for _, attribute := range r.URL.Query()["attribute"] {
+			if separator := strings.Index(attribute, ":"); separator > 0 {
+				ws.attributes[attribute[:separator]] = attribute[separator+1:]
+			}
+		}
+		if r.URL.Query().Get("start-after-create") == "true" {
+			ws.transitions = f.startTransitions
+		}
+		writeFakeJSON(w, http.StatusCreated, f.workspaceJSON(ws))
+
+	case len(parts) == 1 && parts[0] == "workspace" && r.Method == http.MethodGet:
+		var ids []string
+		for id := range f.workspaces {
+			ids = append(ids, id)
+		}
+		sort.Strings(ids)
+
+		skip, _ := strconv.Atoi(r.URL.Query().Get("skipCount"))
+		maxItems, maxErr := strconv.Atoi(r.URL.Query().Get("maxItems"))
+		if maxErr != nil {
+			maxItems = 30
+		}
+
+		workspaces := []interface{}{}
+		for index := skip; index < len(ids) && len(workspaces) < maxItems; index++ {
+			workspaces = append(workspaces, f.workspaceJSON(f.workspaces[ids[index]]))
+		}
+		writeFakeJSON(w, http.StatusOK, workspaces)
+
+	case len(parts) >= 2 && parts[0] == "workspace":
+		ws, ok := f.workspaces[parts[1]]
+		if !ok {
+			writeFakeError(w, http.StatusNotFound, fmt.Sprintf("Workspace with id '%s' doesn't exist", parts[1]))
+			return
+		}
+
+		switch {
+		case len(parts) == 2 && r.Method == http.MethodGet:
+			writeFakeJSON(w, http.StatusOK, f.workspaceJSON(ws))
+		case len(parts) == 2 && r.Method == http.MethodDelete:
+			if status := ws.status(); status != "STOPPED" {
+				writeFakeError(w, http.StatusConflict, fmt.Sprintf("The workspace '%s' is currently running and cannot be removed.", ws.id))
+				return
+			}
+			delete(f.workspaces, ws.id)
+			w.WriteHeader(http.StatusNoContent)
+		case len(parts) == 3 && parts[2] == "runtime" && r.Method == http.MethodPost:
+			if status := ws.status(); status != "STOPPED" {
+				writeFakeError(w, http.StatusConflict, fmt.Sprintf("Could not start workspace '%s' because its status is '%s'", ws.id, status))
+				return
+			}
+			ws.transition(f.startTransitions)
+			writeFakeJSON(w, http.StatusOK, f.workspaceJSON(ws))
+		case len(parts) == 3 && parts[2] == "runtime" && r.Method == http.MethodDelete:
+			if status := ws.status(); status != "RUNNING" && status != "STARTING" {
+				writeFakeError(w, http.StatusConflict, fmt.Sprintf("Could not stop the workspace '%s' because its status is '%s'.", ws.id, status))
+				return
+			}
+			ws.transition(f.stopTransitions)
+			ws.processes = make(map[int]*fakeProcess)
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			writeFakeError(w, http.StatusMethodNotAllowed, "Unsupported workspace operation")
+		}
+
+	default:
+		writeFakeError(w, http.StatusNotFound, "Not found: "+r.URL.Path)
+	}
+}
+
+func (f *FakeChe) serveWSAgent(w http.ResponseWriter, r *http.Request, workspaceID string, parts []string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	ws, ok := f.runningWorkspace(w, workspaceID)
+	if !ok {
+		return
+	}
+
+	switch {
+	case len(parts) == 1 && parts[0] == "project" && r.Method == http.MethodGet:
+		projects := ws.projects
+		if projects == nil {
+			projects = []Sample{}
+		}
+		writeFakeJSON(w, http.StatusOK, projects)
+	case len(parts) == 2 && parts[0] == "project" && parts[1] == "batch" && r.Method == http.MethodPost:
+		var projects []Sample
+		if err := json.NewDecoder(r.Body).Decode(&projects); err != nil {
+			writeFakeError(w, http.StatusBadRequest, "Invalid project configuration: "+err.Error())
+			return
+		}
+		for _, project := range projects {
+			if project.Source.Location == "" {
+				writeFakeError(w, http.StatusBadRequest, "Project source location is required")
+				return
+			}
+			if project.Path == "" {
+				project.Path = "/" + project.Name
+			}
+			ws.projects = append(ws.projects, project)
+		}
+		writeFakeJSON(w, http.StatusOK, projects)
+	default:
+		writeFakeError(w, http.StatusNotFound, "Not found: "+r.URL.Path)
+	}
+}
+
+func (f *FakeChe) serveExecAgent(w http.ResponseWriter, r *http.Request, workspaceID string, parts []string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	ws, ok := f.runningWorkspace(w, workspaceID)
+	if !ok {
+		return
+	}
+
+	if len(parts) == 0 && r.Method == http.MethodPost {
+		var command Command
+		if err := json.NewDecoder(r.Body).Decode(&command); err != nil {
+			writeFakeError(w, http.StatusBadRequest, "Invalid command: "+err.Error())
+			return
+		}
+		script, scripted := f.processScripts[command.CommandLine]
+		if !scripted {
+			script = f.defaultProcess
+			script.Output = []string{command.CommandLine}
+		}
+		f.nextPid++
+		process := &fakeProcess{pid: f.nextPid, command: command, script: script, started: time.Now()}
+		ws.processes[process.pid] = process
+		writeFakeJSON(w, http.StatusOK, process.state())
+		return
+	}
+
+	if len(parts) == 0 {
+		writeFakeError(w, http.StatusMethodNotAllowed, "Unsupported process operation")
+		return
+	}
+
+	pid, pidErr := strconv.Atoi(parts[0])
+	process, exists := ws.processes[pid]
+	if pidErr != nil || !exists {
+		writeFakeError(w, http.StatusNotFound, fmt.Sprintf("Process with id '%s' does not exist", parts[0]))
+		return
+	}
+
+	switch {
+	case len(parts) == 1 && r.Method == http.MethodGet:
+		writeFakeJSON(w, http.StatusOK, process.state())
+	case len(parts) == 2 && parts[1] == "logs" && r.Method == http.MethodGet:
+		writeFakeJSON(w, http.StatusOK, process.logs())
+	default:
+		writeFakeError(w, http.StatusNotFound, "Not found: "+r.URL.Path)
+	}
+}
+
+//serveExecAgentWS speaks the Che6 exec agent JSON-RPC protocol, streaming process events to subscribers
+func (f *FakeChe) serveExecAgentWS(w http.ResponseWriter, r *http.Request, workspaceID string) {
+	f.mu.Lock()
+	ws, ok := f.runningWorkspace(w, workspaceID)
+	f.mu.Unlock()
+	if !ok {
+		return
+	}
+
+	upgrader := websocket.Upgrader{}
+	conn, upgradeErr := upgrader.Upgrade(w, r, nil)
+	if upgradeErr != nil {
+		return
+	}
+	defer conn.Close()
+
+	var writeMu sync.Mutex
+	send := func(message jsonRPCMessage) error {
+		writeMu.Lock()
+		defer writeMu.Unlock()
+		message.JSONRPC = "2.0"
+		return conn.WriteJSON(message)
+	}
+
+	for {
+		var request jsonRPCIncoming
+		if conn.ReadJSON(&request) != nil {
+			return
+		}
+
+		if request.Method != "process.subscribe" {
+			send(jsonRPCMessage{ID: request.ID, Error: &jsonRPCError{Code: -32601, Message: "Method not found: " + request.Method}})
+			continue
+		}
+
+		var params processSubscribeParams
+		json.Unmarshal(request.Params, &params)
+
+		f.mu.Lock()
+		process, exists := ws.processes[params.Pid]
+		f.mu.Unlock()
+
+		if !exists {
+			send(jsonRPCMessage{ID: request.ID, Error: &jsonRPCError{Code: -32000, Message: fmt.Sprintf("Process with id '%d' does not exist", params.Pid)}})
+			continue
+		}
+
+		var after time.Time
+		if params.After != "" {
+			parsed, afterErr := time.Parse(time.RFC3339Nano, params.After)
+			if afterErr != nil {
+				send(jsonRPCMessage{ID: request.ID, Error: &jsonRPCError{Code: -32602, Message: "Invalid after: " + afterErr.Error()}})
+				continue
+			}
+			after = parsed
+		}
+
+		result, _ := json.Marshal(map[string]interface{}{"pid": params.Pid, "eventTypes": params.EventTypes, "text": "Successfully subscribed"})
+		send(jsonRPCMessage{ID: request.ID, Result: result})
+
+		go f.streamProcess(process, fakeEventMask(params.EventTypes), after, send)
+	}
+}
+
+//fakeEventMask tells the event types the exec agent sends for the eventTypes of a subscription.
+//Like the exec agent it ignores the types it does not know.
+func fakeEventMask(eventTypes string) map[string]bool {
+	mask := map[string]bool{}
+	for _, eventType := range strings.Split(eventTypes, ",") {
+		switch strings.TrimSpace(eventType) {
+		case "stdout":
+			mask[ProcessStdout] = true
+		case "stderr":
+			mask[ProcessStderr] = true
+		case "process_status":
+			mask[ProcessStarted] = true
+			mask[ProcessDied] = true
+		}
+	}
+	return mask
+}
+
+//serveMasterWS is the JSON-RPC WebSocket of the Che6 master, pushing the status changes of the subscribed workspaces
+func (f *FakeChe) serveMasterWS(w http.ResponseWriter, r *http.Request) {
+	upgrader := websocket.Upgrader{}
+	conn, upgradeErr := upgrader.Upgrade(w, r, nil)
+	if upgradeErr != nil {
+		return
+	}
+	defer conn.Close()
+
+	f.mu.Lock()
+	f.masterSockets[conn] = true
+	f.mu.Unlock()
+	defer func() {
+		f.mu.Lock()
+		delete(f.masterSockets, conn)
+		f.mu.Unlock()
+	}()
+
+	var writeMu sync.Mutex
+	send := func(message jsonRPCMessage) error {
+		writeMu.Lock()
+		defer writeMu.Unlock()
+		message.JSONRPC = "2.0"
+		return conn.WriteJSON(message)
+	}
+
+	closed := make(chan struct{})
+	defer close(closed)
+
+	var subscribedMu sync.Mutex
+	subscribed := make(map[string]map[string]bool)
+
+	for {
+		var request jsonRPCIncoming
+		if conn.ReadJSON(&request) != nil {
+			return
+		}
+
+		if request.Method != "subscribe" {
+			if request.ID != nil {
+				send(jsonRPCMessage{ID: request.ID, Error: &jsonRPCError{Code: -32601, Message: "Method not found: " + request.Method}})
+			}
+			continue
+		}
+
+		var subscription eventSubscription
+		json.Unmarshal(request.Params, &subscription)
+
+		//Like Che, confirm every subscription, events of unknown workspaces or methods are just never sent
+		f.mu.Lock()
+		ws, exists := f.workspaces[subscription.Scope["workspaceId"]]
+		var current string
+		if exists {
+			current = ws.status()
+		}
+		f.mu.Unlock()
+
+		if exists && (subscription.Method == WorkspaceStatusChanged || subscription.Method == MachineStatusChanged) {
+			subscribedMu.Lock()
+			methods, streaming := subscribed[ws.id]
+			if !streaming {
+				methods = make(map[string]bool)
+				subscribed[ws.id] = methods
+			}
+			methods[subscription.Method] = true
+			subscribedMu.Unlock()
+
+			if !streaming {
+				go f.streamWorkspaceStatus(ws, current, func(method string) bool {
+					subscribedMu.Lock()
+					defer subscribedMu.Unlock()
+					return subscribed[ws.id][method]
+				}, send, closed)
+			}
+		}
+
+		if request.ID != nil {
+			send(jsonRPCMessage{ID: request.ID, Result: json.RawMessage(`{}`)})
+		}
+	}
+}
+
+//streamWorkspaceStatus sends the changes of ws from status last on as notifications of the subscribed methods until
+//the connection is closed. Like Che, the machine reports its change before the workspace does.
+func (f *FakeChe) streamWorkspaceStatus(ws *fakeWorkspace, last string, subscribed func(method string) bool, send func(jsonRPCMessage) error, closed <-chan struct{}) {
+	for {
+		select {
+		case <-closed:
+			return
+		case <-time.After(2 * time.Millisecond):
+		}
+
+		f.mu.Lock()
+		current := ws.current()
+		silent := f.silentEvents
+		f.mu.Unlock()
+
+		status := current.Status
+		if status == last || silent {
+			continue
+		}
+
+		var notifications []jsonRPCMessage
+
+		//Machines only report starting, running and stopped
+		if subscribed(MachineStatusChanged) && (status == "STARTING" || status == "RUNNING" || status == "STOPPED") {
+			machine := machineStatusParams{MachineName: "dev-machine", EventType: status, Error: current.Error}
+			machine.Identity.WorkspaceID = ws.id
+			notifications = append(notifications, jsonRPCMessage{Method: MachineStatusChanged, Params: machine})
+		}
+		if subscribed(WorkspaceStatusChanged) {
+			notifications = append(notifications, jsonRPCMessage{Method: WorkspaceStatusChanged, Params: workspaceStatusParams{WorkspaceID: ws.id, Status: status, PrevStatus: last, Error: current.Error}})
+		}
+		last = status
+
+		for _, notification := range notifications {
+			if send(notification) != nil {
+				return
+			}
+		}
+	}
+}
+
+//CloseMasterSockets drops the connections to the master WebSocket, as a restarting Che or proxy would
+func (f *FakeChe) CloseMasterSockets() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for conn := range f.masterSockets {
+		conn.Close()
+	}
+}
+
+//streamProcess sends the output of process printed after after as it is printed, followed by its death,
+//leaving out the events that are not in mask
+func (f *FakeChe) streamProcess(process *fakeProcess, mask map[string]bool, after time.Time, send func(jsonRPCMessage) error) {
+	sent := 0
+	for {
+		f.mu.Lock()
+		logs := process.logs()
+		state := process.state()
+		silent := f.silentEvents
+		f.mu.Unlock()
+
+		for ; sent < len(logs); sent++ {
+			if silent || !mask[ProcessStdout] || !logs[sent].Time.After(after) {
+				continue
+			}
+			event := ProcessEvent{Pid: process.pid, Time: logs[sent].Time, Text: logs[sent].Text}
+			if send(jsonRPCMessage{Method: ProcessStdout, Params: event}) != nil {
+				return
+			}
+		}
+
+		if !state.Alive {
+			if !silent && mask[ProcessDied] {
+				send(jsonRPCMessage{Method: ProcessDied, Params: ProcessEvent{Pid: process.pid, Time: time.Now(), ExitCode: state.ExitCode}})
+			}
+			return
+		}
+
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+//serveApp plays the application a "run" command starts, it answers while a process that runs Forever is alive
+func (f *FakeChe) serveApp(w http.ResponseWriter, r *http.Request, workspaceID string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	ws, ok := f.runningWorkspace(w, workspaceID)
+	if !ok {
+		return
+	}
+
+	for _, process := range ws.processes {
+		if process.script.Forever {
+			w.Write([]byte("Hello from " + process.command.Name))
+			return
+		}
+	}
+
+	writeFakeError(w, http.StatusServiceUnavailable, "Application is not available")
+}
+
+//AddWorkspace adds a workspace created at created, as if an earlier run had left it behind, and returns its id
+func (f *FakeChe) AddWorkspace(post Post, created time.Time, running bool, attributes map[string]string) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	ws := f.newWorkspace(post, created)
+	for key, value := range attributes {
+		ws.attributes[key] = value
+	}
+	if running {
+		ws.transitions = []FakeTransition{{Status: "RUNNING"}}
+	}
+	return ws.id
+}
+
+func (f *FakeChe) newWorkspace(post Post, created time.Time) *fakeWorkspace {
+	f.nextID++
+	ws := &fakeWorkspace{
+		id:          fmt.Sprintf("workspace%04d", f.nextID),
+		post:        post,
+		attributes:  map[string]string{"created": strconv.FormatInt(created.UnixNano()/int64(time.Millisecond), 10)},
+		transitions: []FakeTransition{{Status: "STOPPED"}},
+		changedAt:   time.Now(),
+		processes:   make(map[int]*fakeProcess),
+	}
+	f.workspaces[ws.id] = ws
+	return ws
+}
+
+//nameTaken tells whether namespace has a workspace called name already, Che rejects a second one
+func (f *FakeChe) nameTaken(namespace, name string) bool {
+	for _, ws := range f.workspaces {
+		if ws.post.Namespace == namespace && ws.post.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+//runningWorkspace finds the workspace an agent request is for, agents only answer while it is running
+func (f *FakeChe) runningWorkspace(w http.ResponseWriter, workspaceID string) (*fakeWorkspace, bool) {
+	ws, ok := f.workspaces[workspaceID]
+	if !ok || ws.status() != "RUNNING" {
+		writeFakeError(w, http.StatusBadGateway, "Agent is not available")
+		return nil, false
+	}
+	return ws, true
+}
+
+func (f *FakeChe) workspaceJSON(ws *fakeWorkspace) map[string]interface{} {
+	status := ws.status()
+	data := map[string]interface{}{
+		"id":        ws.id,
+		"namespace": ws.post.Namespace,
+		"status":    status,
+		"config": map[string]interface{}{
+			"name":         ws.post.Name,
+			"defaultEnv":   ws.post.DefaultEnv,
+			"environments": ws.post.Environments,
+			"projects":     ws.projects,
+		},
+		"attributes": ws.attributes,
+	}
+
+	//Workspaces created from a devfile have it instead of a config
+	if ws.devfile != nil {
+		delete(data, "config")
+		data["devfile"] = ws.devfile
+	}
+
+	if failure := ws.current().Error; failure != "" {
+		attributes := map[string]string{ErrorMessageAttribute: failure, StoppedAbnormallyAttribute: "true"}
+		for key, value := range ws.attributes {
+			attributes[key] = value
+		}
+		data["attributes"] = attributes
+	}
+
+	if status == "RUNNING" || status == "STOPPING" || status == "SNAPSHOTTING" {
+		data["runtime"] = f.runtimeJSON(ws)
+	}
+
+	return data
+}
+
+//runtimeJSON builds the runtime in the shape GetHTTPAgents expects from Che5 or Che6
+func (f *FakeChe) runtimeJSON(ws *fakeWorkspace) interface{} {
+	execAgentURL := f.URL + "/exec-agent/" + ws.id
+	wsAgentURL := f.URL + "/wsagent/" + ws.id + "/api"
+	appURL := f.URL + "/app/" + ws.id
+
+	if f.Version >= 7 {
+		return f.che7RuntimeJSON(ws)
+	}
+
+	if f.Version == 5 {
+		return map[string]interface{}{
+			"machines": []interface{}{
+				map[string]interface{}{
+					"runtime": map[string]interface{}{
+						"servers": map[string]ServerURL{
+							"4412/tcp": {URL: execAgentURL, Ref: "exec-agent"},
+							"4401/tcp": {URL: wsAgentURL, Ref: "wsagent"},
+							"8080/tcp": {URL: appURL, Ref: "tomcat8"},
+						},
+					},
+				},
+			},
+		}
+	}
+
+	return map[string]interface{}{
+		"machines": map[string]interface{}{
+			"dev-machine": map[string]interface{}{
+				"servers": map[string]ServerURL{
+					"exec-agent/http": {URL: execAgentURL + "/process"},
+					"exec-agent/ws":   {URL: "ws" + strings.TrimPrefix(execAgentURL, "http") + "/connect"},
+					"wsagent/http":    {URL: wsAgentURL},
+					"tomcat8":         {URL: appURL},
+				},
+			},
+		},
+	}
+}
+
+//status is the status of the last transition whose delay has passed
+func (ws *fakeWorkspace) status() string {
+	return ws.current().Status
+}
+
+//current is the last transition whose delay has passed
+func (ws *fakeWorkspace) current() FakeTransition {
+	elapsed := time.Since(ws.changedAt)
+	current := FakeTransition{Status: "STOPPED"}
+	for _, transition := range ws.transitions {
+		if transition.After <= elapsed {
+			current = transition
+		}
+	}
+	return current
+}
+
+func (ws *fakeWorkspace) transition(transitions []FakeTransition) {
+	ws.transitions = transitions
+	ws.changedAt = time.Now()
+}
+
+func (p *fakeProcess) alive() bool {
+	return p.script.Forever || time.Since(p.started) < p.script.Duration
+}
+
+func (p *fakeProcess) state() ProcessStruct {
+	exitCode := -1
+	if !p.alive() {
+		exitCode = p.script.ExitCode
+	}
+	return ProcessStruct{
+		Pid:         p.pid,
+		Name:        p.command.Name,
+		CommandLine: p.command.CommandLine,
+		Type:        p.command.Type,
+		Alive:       p.alive(),
+		NativePid:   p.pid + 1000,
+		ExitCode:    exitCode,
+	}
+}
+
+//logs returns the output lines printed so far, the first one straight away and the rest spread evenly over the process duration
+func (p *fakeProcess) logs() LogArray {
+	logs := LogArray{}
+	lines := p.script.Output
+	printed := len(lines)
+	if !p.script.Forever && p.alive() && len(lines) > 0 {
+		printed = 1 + int(int64(len(lines)-1)*int64(time.Since(p.started))/int64(p.script.Duration))
+	}
+
+	for index := 0; index < printed; index++ {
+		logs = append(logs, LogItem{
+			Kind: 0,
+			Time: p.started.Add(time.Duration(index) * time.Millisecond),
+			Text: lines[index],
+		})
+	}
+	return logs
+}
+
+func writeFakeJSON(w http.ResponseWriter, status int, data interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(data)
+}
+
+func writeFakeError(w http.ResponseWriter, status int, message string) {
+	writeFakeJSON(w, status, map[string]string{"message": message})
+}
+
+//FakeStacks is the stack catalog a FakeChe starts with, covering the stacks used by the features
+func FakeStacks() []Workspace {
+	return []Workspace{
+		{
+			ID:   "vert.x",
+			Name: "Eclipse Vert.x",
+			Tags: []string{"Java", "JDK", "Maven", "Vert.x", "CentOS"},
+			Config: WorkspaceConfig{
+				Name:       "default",
+				DefaultEnv: "default",
+				EnvironmentConfig: EnvironmentConfig{Default: map[string]interface{}{
+					"recipe": map[string]string{"type": "dockerimage", "location": "registry.centos.org/che-stacks/vertx"},
+				}},
+			},
+			Command: []Command{
+				{Name: "build", Type: "mvn", CommandLine: "mvn clean install -f ${current.project.path}"},
+			},
+		},
+		{
+			ID:   "java-centos",
+			Name: "Java CentOS",
+			Tags: []string{"Java", "JDK", "Maven", "CentOS"},
+			Config: WorkspaceConfig{
+				Name:       "default",
+				DefaultEnv: "default",
+				EnvironmentConfig: EnvironmentConfig{Default: map[string]interface{}{
+					"recipe": map[string]string{"type": "dockerimage", "location": "registry.centos.org/che-stacks/centos-jdk8"},
+				}},
+			},
+			Command: []Command{
+				{Name: "build", Type: "mvn", CommandLine: "mvn clean install -f ${current.project.path}"},
+			},
+		},
+	}
+}
+
+//FakeSamples is the samples catalog a FakeChe starts with, covering the samples used by the features
+func FakeSamples() []Sample {
+	return []Sample{
+		{
+			Name:        "vertx-http-booster",
+			Source:      SampleSourceType{Type: "git", Location: "https://github.com/openshiftio-vertx-boosters/vertx-http-booster"},
+			Tags:        []string{"vertx", "java", "maven"},
+			Path:        "/vertx-http-booster",
+			ProjectType: "maven",
+			Commands: []Command{
+				{Name: "run", Type: "custom", CommandLine: "cd ${current.project.path} && mvn compile vertx:run"},
+			},
+		},
+		{
+			Name:        "console-java-simple",
+			Source:      SampleSourceType{Type: "git", Location: "https://github.com/che-samples/console-java-simple.git"},
+			Tags:        []string{"java", "maven"},
+			Path:        "/console-java-simple",
+			ProjectType: "maven",
+			Commands: []Command{
+				{Name: "console-java-simple:build", Type: "mvn", CommandLine: "mvn clean install -f ${current.project.path}"},
+			},
+		},
+	}
+}
@@ -0,0 +1,362 @@
+/*
+Copyright (C) 2017 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+//fakeDevMachine is the machine of the devfile container in the runtime of a fake Che7 workspace
+const fakeDevMachine = "ws/dev"
+
+//FakeDevfile is a devfile served by the devfile registry of a fake Che7 server
+type FakeDevfile struct {
+	Slug        string
+	DisplayName string
+	Tags        []string
+	Content     string
+}
+
+//FakeDevfiles is the devfile registry catalog of a fake Che7 server
+func FakeDevfiles() []FakeDevfile {
+	return []FakeDevfile{
+		{
+			Slug:        "java-maven",
+			DisplayName: "Java Maven",
+			Tags:        []string{"Java", "Maven", "CentOS"},
+			Content: `apiVersion: 1.0.0
+metadata:
+  generateName: java-maven-
+projects:
+  - name: console-java-simple
+    source:
+      type: git
+      location: "https://github.com/che-samples/console-java-simple.git"
+components:
+  - type: cheEditor
+    id: eclipse/che-theia/7.0.0
+  - type: chePlugin
+    id: eclipse/che-machine-exec-plugin/7.0.0
+  - type: dockerimage
+    alias: dev
+    image: maven:3.6.0-jdk-11
+    memoryLimit: 512Mi
+    mountSources: true
+    volumes:
+      - name: m2
+        containerPath: /home/user/.m2
+commands:
+  - name: maven build
+    actions:
+      - type: exec
+        component: dev
+        command: "mvn clean install"
+        workdir: "${CHE_PROJECTS_ROOT}/console-java-simple"
+`,
+		},
+	}
+}
+
+//FakePlugins are the meta.yaml files of the plugin registry of a fake Che7 server, by plugin id
+func FakePlugins() map[string]string {
+	return map[string]string{
+		"eclipse/che-theia/7.0.0": `apiVersion: v2
+publisher: eclipse
+name: che-theia
+version: 7.0.0
+type: Che Editor
+displayName: theia-ide
+`,
+		"eclipse/che-machine-exec-plugin/7.0.0": `apiVersion: v2
+publisher: eclipse
+name: che-machine-exec-plugin
+version: 7.0.0
+type: Che Plugin
+displayName: Che machine-exec Service
+`,
+	}
+}
+
+//RemovePlugin takes the plugin id out of the plugin registry
+func (f *FakeChe) RemovePlugin(id string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.plugins, id)
+}
+
+func (f *FakeChe) serveDevfileRegistry(w http.ResponseWriter, r *http.Request, parts []string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if len(parts) == 2 && parts[0] == "devfiles" && parts[1] == "index.json" {
+		var entries []DevfileEntry
+		for _, devfile := range f.devfiles {
+			entry := DevfileEntry{DisplayName: devfile.DisplayName, Tags: devfile.Tags}
+			entry.Links.Self = "/devfiles/" + devfile.Slug + "/devfile.yaml"
+			entries = append(entries, entry)
+		}
+		writeFakeJSON(w, http.StatusOK, entries)
+		return
+	}
+
+	if len(parts) == 3 && parts[0] == "devfiles" && parts[2] == "devfile.yaml" {
+		for _, devfile := range f.devfiles {
+			if devfile.Slug == parts[1] {
+				w.Header().Set("Content-Type", "text/yaml")
+				w.Write([]byte(devfile.Content))
+				return
+			}
+		}
+	}
+
+	writeFakeError(w, http.StatusNotFound, "Not found: "+r.URL.Path)
+}
+
+func (f *FakeChe) servePluginRegistry(w http.ResponseWriter, r *http.Request, parts []string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if len(parts) > 2 && parts[0] == "plugins" && parts[len(parts)-1] == "meta.yaml" {
+		if meta, ok := f.plugins[strings.Join(parts[1:len(parts)-1], "/")]; ok {
+			w.Header().Set("Content-Type", "text/yaml")
+			w.Write([]byte(meta))
+			return
+		}
+	}
+
+	writeFakeError(w, http.StatusNotFound, "Not found: "+r.URL.Path)
+}
+
+//serveDevfileWorkspace creates a workspace from the devfile in the body of r, the caller holds f.mu
+func (f *FakeChe) serveDevfileWorkspace(w http.ResponseWriter, r *http.Request) {
+	var devfile map[string]interface{}
+	if err := json.NewDecoder(r.Body).Decode(&devfile); err != nil {
+		writeFakeError(w, http.StatusBadRequest, "Invalid devfile: "+err.Error())
+		return
+	}
+
+	metadata, _ := devfile["metadata"].(map[string]interface{})
+	name, _ := metadata["name"].(string)
+	if name == "" {
+		writeFakeError(w, http.StatusBadRequest, "Devfile metadata name is required")
+		return
+	}
+	if f.nameTaken(r.URL.Query().Get("namespace"), name) {
+		writeFakeError(w, http.StatusConflict, fmt.Sprintf("Workspace '%s' already exists in namespace '%s'", name, r.URL.Query().Get("namespace")))
+		return
+	}
+
+	ws := f.newWorkspace(Post{Name: name, Namespace: r.URL.Query().Get("namespace")}, time.Now())
+	ws.devfile = devfile
+	for _, attribute := range r.URL.Query()["attribute"] {
+		if separator := strings.Index(attribute, ":"); separator > 0 {
+			ws.attributes[attribute[:separator]] = attribute[separator+1:]
+		}
+	}
+	writeFakeJSON(w, http.StatusCreated, f.workspaceJSON(ws))
+}
+
+//che7RuntimeJSON is the runtime of a Che7 workspace: the devfile container and the containers of che-machine-exec and the editor
+func (f *FakeChe) che7RuntimeJSON(ws *fakeWorkspace) interface{} {
+	machineExecURL := "ws" + strings.TrimPrefix(f.URL, "http") + "/machine-exec/" + ws.id
+	appURL := f.URL + "/app/" + ws.id
+
+	return map[string]interface{}{
+		"machines": map[string]interface{}{
+			fakeDevMachine: map[string]interface{}{
+				"attributes": map[string]string{"source": "recipe"},
+				"servers": map[string]ServerURL{
+					"tomcat8": {URL: appURL},
+				},
+			},
+			"ws/che-machine-exec": map[string]interface{}{
+				"attributes": map[string]string{"source": "tool"},
+				"servers": map[string]ServerURL{
+					"terminal": {URL: machineExecURL, Attributes: map[string]string{"type": "terminal"}},
+				},
+			},
+			"ws/theia-ide": map[string]interface{}{
+				"attributes": map[string]string{"source": "tool"},
+				"servers": map[string]ServerURL{
+					"theia": {URL: appURL + "/editor", Attributes: map[string]string{"type": "ide"}},
+				},
+			},
+		},
+	}
+}
+
+//serveMachineExec is che-machine-exec: processes are created over the connect JSON-RPC WebSocket,
+//their output is read from the attach WebSocket and their exit is notified on the connect one
+func (f *FakeChe) serveMachineExec(w http.ResponseWriter, r *http.Request, workspaceID string, parts []string) {
+	f.mu.Lock()
+	ws, ok := f.runningWorkspace(w, workspaceID)
+	var process *fakeProcess
+	if ok && len(parts) == 2 && parts[0] == "attach" {
+		pid, _ := strconv.Atoi(parts[1])
+		process = ws.processes[pid]
+	}
+	f.mu.Unlock()
+	if !ok {
+		return
+	}
+
+	switch {
+	case len(parts) == 1 && parts[0] == "connect":
+	case process != nil:
+	default:
+		writeFakeError(w, http.StatusNotFound, "Not found: "+r.URL.Path)
+		return
+	}
+
+	upgrader := websocket.Upgrader{}
+	conn, upgradeErr := upgrader.Upgrade(w, r, nil)
+	if upgradeErr != nil {
+		return
+	}
+	defer conn.Close()
+
+	if process != nil {
+		f.attachProcess(conn, process)
+		return
+	}
+
+	var writeMu sync.Mutex
+	send := func(message jsonRPCMessage) error {
+		writeMu.Lock()
+		defer writeMu.Unlock()
+		message.JSONRPC = "2.0"
+		return conn.WriteJSON(message)
+	}
+
+	closed := make(chan struct{})
+	defer close(closed)
+
+	for {
+		var request jsonRPCIncoming
+		if conn.ReadJSON(&request) != nil {
+			return
+		}
+
+		var params machineExecParams
+		json.Unmarshal(request.Params, &params)
+
+		if request.Method != "create" {
+			send(jsonRPCMessage{ID: request.ID, Error: &jsonRPCError{Code: -32601, Message: "Method not found: " + request.Method}})
+			continue
+		}
+		if params.Identifier.MachineName != fakeDevMachine || len(params.Cmd) == 0 {
+			send(jsonRPCMessage{ID: request.ID, Error: &jsonRPCError{Code: -32000, Message: fmt.Sprintf("Machine %q not found", params.Identifier.MachineName)}})
+			continue
+		}
+
+		commandLine := params.Cmd[len(params.Cmd)-1]
+		f.mu.Lock()
+		f.nextPid++
+		created := &fakeProcess{pid: f.nextPid, command: Command{CommandLine: commandLine}, script: f.machineExecScript(ws, commandLine), started: time.Now()}
+		ws.processes[created.pid] = created
+		f.mu.Unlock()
+
+		result, _ := json.Marshal(created.pid)
+		send(jsonRPCMessage{ID: request.ID, Result: result})
+
+		go f.notifyExit(created, send, closed)
+	}
+}
+
+//machineExecScript is what commandLine does in the fake devfile container. Cloning and listing
+//projects work on the projects of ws, anything else runs as scripted with SetProcess.
+func (f *FakeChe) machineExecScript(ws *fakeWorkspace, commandLine string) FakeProcess {
+	fields := strings.Fields(commandLine)
+
+	if len(fields) == 4 && fields[0] == "git" && fields[1] == "clone" {
+		location := strings.Trim(fields[2], "'")
+		name := path.Base(strings.Trim(fields[3], "'"))
+		for _, project := range ws.projects {
+			if project.Name == name {
+				return FakeProcess{ExitCode: 128, Output: []string{fmt.Sprintf("fatal: destination path '%s' already exists and is not an empty directory.", name)}}
+			}
+		}
+		ws.projects = append(ws.projects, Sample{Name: name, Path: "/" + name, Source: SampleSourceType{Type: "git", Location: location}})
+		return FakeProcess{Output: []string{fmt.Sprintf("Cloning into '%s'...", name)}, Duration: 10 * time.Millisecond}
+	}
+
+	if commandLine == "ls -1 -p "+projectsRoot {
+		var output []string
+		for _, project := range ws.projects {
+			output = append(output, project.Name+"/")
+		}
+		return FakeProcess{Output: output}
+	}
+
+	script, scripted := f.processScripts[commandLine]
+	if !scripted {
+		script = f.defaultProcess
+		script.Output = []string{commandLine}
+	}
+	return script
+}
+
+//attachProcess writes the output of process to conn as it is printed and closes it once the process died
+func (f *FakeChe) attachProcess(conn *websocket.Conn, process *fakeProcess) {
+	sent := 0
+	for {
+		f.mu.Lock()
+		logs := process.logs()
+		alive := process.alive()
+		f.mu.Unlock()
+
+		for ; sent < len(logs); sent++ {
+			if conn.WriteMessage(websocket.TextMessage, []byte(logs[sent].Text+"\n")) != nil {
+				return
+			}
+		}
+
+		if !alive {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+//notifyExit sends the exit of process once it died, unless the connection is closed first
+func (f *FakeChe) notifyExit(process *fakeProcess, send func(jsonRPCMessage) error, closed <-chan struct{}) {
+	for {
+		f.mu.Lock()
+		state := process.state()
+		f.mu.Unlock()
+
+		if !state.Alive {
+			send(jsonRPCMessage{Method: MachineExecExit, Params: machineExecEvent{ID: process.pid, Code: state.ExitCode}})
+			return
+		}
+
+		select {
+		case <-closed:
+			return
+		case <-time.After(5 * time.Millisecond):
+		}
+	}
+}
@@ -0,0 +1,155 @@
+/*
+Copyright (C) 2017 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func newFakeCheAPI(version int) (*FakeChe, CheAPI) {
+	fake := NewFakeChe(version)
+	return fake, fake.Config(DefaultConfig()).NewCheAPI()
+}
+
+func TestWorkspaceLifecycleAgainstFakeChe(t *testing.T) {
+	for _, version := range []int{5, 6} {
+		fake, c := newFakeCheAPI(version)
+
+		stacks, err := c.GetStackInformation(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		samples, err := c.GetSamplesInformation(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		c.GenerateDataForWorkspaces(stacks, samples)
+
+		stack := c.GetStackConfigMap()["Java CentOS"]
+		workspace, err := c.StartWorkspace(context.Background(), stack.Config.EnvironmentConfig, stack.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c.SetWorkspaceID(workspace.ID)
+
+		status, err := c.GetWorkspaceStatusByID(context.Background(), workspace.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if status.WorkspaceStatus != "RUNNING" {
+			t.Fatalf("Che%d: expected RUNNING after start, got %s", version, status.WorkspaceStatus)
+		}
+
+		agents, err := c.GetHTTPAgents(context.Background(), workspace.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if agents.execAgentURL == "" || agents.wsAgentURL == "" {
+			t.Fatalf("Che%d: agents were not found in the runtime: %+v", version, agents)
+		}
+		c.SetAgentsURL(agents)
+
+		sample := c.GetSamplesConfigMap()["https://github.com/che-samples/console-java-simple.git"]
+		if err := c.AddSamplesToProject(context.Background(), []Sample{sample}); err != nil {
+			t.Fatal(err)
+		}
+		projects, err := c.GetNumberOfProjects(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if projects != 1 {
+			t.Errorf("Che%d: expected 1 project, got %d", version, projects)
+		}
+
+		process, err := c.PostCommandToWorkspace(context.Background(), sample.Commands[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if process.Alive || process.ExitCode != 0 {
+			t.Errorf("Che%d: expected the command to exit with 0, got %+v", version, process)
+		}
+
+		if err := c.StopWorkspace(context.Background(), workspace.ID); err != nil {
+			t.Fatal(err)
+		}
+		if err := c.RemoveWorkspace(context.Background(), workspace.ID); err != nil {
+			t.Fatal(err)
+		}
+		if err := c.CheckWorkspaceDeletion(context.Background(), workspace.ID); err != nil {
+			t.Errorf("Che%d: %v", version, err)
+		}
+
+		fake.Close()
+	}
+}
+
+func TestFakeCheScriptedFailures(t *testing.T) {
+	fake, c := newFakeCheAPI(6)
+	defer fake.Close()
+	c.Retry.MaxAttempts = 1
+
+	fake.Fail(http.MethodGet, "/api/stack", http.StatusServiceUnavailable, "Service unavailable", 1)
+
+	_, status, err := c.doRequest(context.Background(), http.MethodGet, c.CheAPIEndpoint+"/stack", "")
+	if !IsStatus(err, http.StatusServiceUnavailable) || status != http.StatusServiceUnavailable {
+		t.Errorf("expected the scripted 503, got %d %v", status, err)
+	}
+
+	_, status, err = c.doRequest(context.Background(), http.MethodGet, c.CheAPIEndpoint+"/stack", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status != http.StatusOK {
+		t.Errorf("expected the failure to be used up, got %d", status)
+	}
+}
+
+func TestFakeCheScriptedTransitions(t *testing.T) {
+	fake, c := newFakeCheAPI(6)
+	defer fake.Close()
+
+	//A start that fails after a while
+	fake.SetStartTransitions(
+		FakeTransition{Status: "STARTING"},
+		FakeTransition{Status: "STOPPED", After: 30 * time.Millisecond},
+	)
+
+	stack := FakeStacks()[0]
+	workspace, err := c.StartWorkspace(context.Background(), stack.Config.EnvironmentConfig, stack.ID)
+	if _, ok := err.(*WorkspaceStatusError); !ok {
+		t.Fatalf("expected the start to fail, got %v", err)
+	}
+
+	status, err := c.GetWorkspaceStatusByID(context.Background(), workspace.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.WorkspaceStatus != "STOPPED" {
+		t.Errorf("expected the scripted start failure, got %s", status.WorkspaceStatus)
+	}
+
+	agents, err := c.GetHTTPAgents(context.Background(), workspace.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if agents.wsAgentURL != "" {
+		t.Error("a stopped workspace should not expose a wsagent")
+	}
+}
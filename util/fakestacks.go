@@ -0,0 +1,167 @@
+/*
+Copyright (C) 2017 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+//Stacks returns the stacks the fake server has
+func (f *FakeChe) Stacks() []Workspace {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]Workspace(nil), f.stacks...)
+}
+
+//serveStack is the stack service of Che5 and Che6, the caller holds f.mu
+func (f *FakeChe) serveStack(w http.ResponseWriter, r *http.Request, parts []string) {
+	switch {
+	case len(parts) == 0 && r.Method == http.MethodGet:
+		tags := r.URL.Query()["tags"]
+		stacks := []Workspace{}
+		for _, stack := range f.stacks {
+			if hasTags(stack, tags) {
+				stacks = append(stacks, stack)
+			}
+		}
+		writeFakeJSON(w, http.StatusOK, stacks)
+
+	case len(parts) == 0 && r.Method == http.MethodPost:
+		stack, ok := decodeFakeStack(w, r)
+		if !ok || !f.stackNameIsFree(w, stack) {
+			return
+		}
+		f.nextID++
+		stack.ID = fmt.Sprintf("stack%04d", f.nextID)
+		f.stacks = append(f.stacks, stack)
+		writeFakeJSON(w, http.StatusCreated, stack)
+
+	case len(parts) >= 1 && f.stackIndex(parts[0]) < 0:
+		writeFakeError(w, http.StatusNotFound, fmt.Sprintf("Stack with id '%s' was not found", parts[0]))
+
+	case len(parts) == 1 && r.Method == http.MethodGet:
+		writeFakeJSON(w, http.StatusOK, f.stacks[f.stackIndex(parts[0])])
+
+	case len(parts) == 1 && r.Method == http.MethodPut:
+		stack, ok := decodeFakeStack(w, r)
+		if !ok {
+			return
+		}
+		stack.ID = parts[0]
+		if !f.stackNameIsFree(w, stack) {
+			return
+		}
+		stack.StackIcon = f.stacks[f.stackIndex(parts[0])].StackIcon
+		f.stacks = append([]Workspace(nil), f.stacks...)
+		f.stacks[f.stackIndex(parts[0])] = stack
+		writeFakeJSON(w, http.StatusOK, stack)
+
+	case len(parts) == 1 && r.Method == http.MethodDelete:
+		index := f.stackIndex(parts[0])
+		f.stacks = append(append([]Workspace(nil), f.stacks[:index]...), f.stacks[index+1:]...)
+		delete(f.stackIcons, parts[0])
+		w.WriteHeader(http.StatusNoContent)
+
+	case len(parts) == 2 && parts[1] == "icon":
+		f.serveStackIcon(w, r, parts[0])
+
+	default:
+		writeFakeError(w, http.StatusNotFound, "Not found: "+r.URL.Path)
+	}
+}
+
+//serveStackIcon uploads, downloads and deletes the icon of the stack stackID, the caller holds f.mu
+func (f *FakeChe) serveStackIcon(w http.ResponseWriter, r *http.Request, stackID string) {
+	switch r.Method {
+	case http.MethodPost:
+		file, header, formErr := r.FormFile("file")
+		if formErr != nil {
+			writeFakeError(w, http.StatusBadRequest, "Icon file required: "+formErr.Error())
+			return
+		}
+		defer file.Close()
+		data, _ := ioutil.ReadAll(file)
+
+		icon := StackIcon{Name: header.Filename, MediaType: header.Header.Get("Content-Type"), Data: data}
+		if !strings.HasPrefix(icon.MediaType, "image/") {
+			writeFakeError(w, http.StatusBadRequest, fmt.Sprintf("Media type '%s' is not an image", icon.MediaType))
+			return
+		}
+		f.stackIcons[stackID] = icon
+
+		f.stacks = append([]Workspace(nil), f.stacks...)
+		stack := &f.stacks[f.stackIndex(stackID)]
+		stack.StackIcon = &StackIcon{Name: icon.Name, MediaType: icon.MediaType}
+		writeFakeJSON(w, http.StatusOK, stack)
+
+	case http.MethodGet:
+		icon, ok := f.stackIcons[stackID]
+		if !ok {
+			writeFakeError(w, http.StatusNotFound, fmt.Sprintf("Stack with id '%s' doesn't have stack icon", stackID))
+			return
+		}
+		w.Header().Set("Content-Type", icon.MediaType)
+		w.Write(icon.Data)
+
+	case http.MethodDelete:
+		delete(f.stackIcons, stackID)
+		f.stacks = append([]Workspace(nil), f.stacks...)
+		f.stacks[f.stackIndex(stackID)].StackIcon = nil
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		writeFakeError(w, http.StatusMethodNotAllowed, "Method not allowed: "+r.Method)
+	}
+}
+
+func decodeFakeStack(w http.ResponseWriter, r *http.Request) (Workspace, bool) {
+	var stack Workspace
+	if err := json.NewDecoder(r.Body).Decode(&stack); err != nil {
+		writeFakeError(w, http.StatusBadRequest, "Invalid stack: "+err.Error())
+		return stack, false
+	}
+	if stack.Name == "" {
+		writeFakeError(w, http.StatusBadRequest, "Stack name required")
+		return stack, false
+	}
+	return stack, true
+}
+
+//stackNameIsFree fails with a conflict when another stack than stack has its name, the caller holds f.mu
+func (f *FakeChe) stackNameIsFree(w http.ResponseWriter, stack Workspace) bool {
+	for _, existing := range f.stacks {
+		if existing.Name == stack.Name && existing.ID != stack.ID {
+			writeFakeError(w, http.StatusConflict, fmt.Sprintf("Stack with name '%s' already exists", stack.Name))
+			return false
+		}
+	}
+	return true
+}
+
+//stackIndex is the index of the stack stackID in f.stacks or -1, the caller holds f.mu
+func (f *FakeChe) stackIndex(stackID string) int {
+	for index, stack := range f.stacks {
+		if stack.ID == stackID {
+			return index
+		}
+	}
+	return -1
+}
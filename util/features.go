@@ -0,0 +1,245 @@
+/*
+Copyright (C) 2017 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+var nonSlugCharacters = regexp.MustCompile(`[^a-z0-9]+`)
+
+//featureScenario is a scenario of a feature file, or a single Examples row of a Scenario Outline,
+//as the line ranges of the source it is copied from
+type featureScenario struct {
+	name string
+	//lines are the half open [start, end) ranges of the source lines of the scenario
+	lines [][2]int
+}
+
+//parsedFeature is a feature file split into the part every scenario shares and its scenarios
+type parsedFeature struct {
+	source []string
+	//shared is the header of the feature, its description and its Background
+	shared    [2]int
+	scenarios []featureScenario
+}
+
+//SplitFeatures writes every scenario of the feature files under paths into a feature file of its own in dir
+//and returns the written files. Each Examples row of a Scenario Outline becomes an outline with that row only.
+//The scenarios are copied line for line, so docstrings, tables and comments stay as they were written.
+//godog runs features concurrently, so this lets scenarios run concurrently.
+func SplitFeatures(paths []string, dir string) ([]string, error) {
+	featureFiles, findErr := findFeatureFiles(paths)
+	if findErr != nil {
+		return nil, findErr
+	}
+
+	var written []string
+	for _, featureFile := range featureFiles {
+		content, readErr := ioutil.ReadFile(featureFile)
+		if readErr != nil {
+			return written, readErr
+		}
+
+		feature := parseFeature(string(content))
+		base := strings.TrimSuffix(filepath.Base(featureFile), filepath.Ext(featureFile))
+
+		for _, scenario := range feature.scenarios {
+			name := fmt.Sprintf("%03d-%s-%s.feature", len(written)+1, slug(base), slug(scenario.name))
+			target := filepath.Join(dir, name)
+			if writeErr := ioutil.WriteFile(target, []byte(feature.render(scenario)), 0644); writeErr != nil {
+				return written, writeErr
+			}
+			written = append(written, target)
+		}
+	}
+
+	return written, nil
+}
+
+//findFeatureFiles expands directories in paths into the feature files they contain
+func findFeatureFiles(paths []string) ([]string, error) {
+	var featureFiles []string
+	for _, path := range paths {
+		walkErr := filepath.Walk(path, func(file string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if !info.IsDir() && strings.HasSuffix(file, ".feature") {
+				featureFiles = append(featureFiles, file)
+			}
+			return nil
+		})
+		if walkErr != nil {
+			return nil, walkErr
+		}
+	}
+	return featureFiles, nil
+}
+
+//featureSection is a keyword line of a feature file, starting at the tags and comments right above it
+type featureSection struct {
+	keyword string
+	text    string
+	start   int
+	//rows are the lines of the table of an Examples section
+	rows []int
+}
+
+//parseFeature splits a feature file into sections by their keyword lines, skipping docstrings,
+//and takes the scenarios from the line ranges of the sections
+func parseFeature(content string) parsedFeature {
+	feature := parsedFeature{source: strings.SplitAfter(content, "\n")}
+
+	var sections []featureSection
+	blockStart := -1
+	docString := ""
+	for index, line := range feature.source {
+		trimmed := strings.TrimSpace(line)
+
+		switch {
+		case docString != "":
+			if strings.HasPrefix(trimmed, docString) {
+				docString = ""
+			}
+			continue
+		case trimmed == "":
+			continue
+		case strings.HasPrefix(trimmed, "@") || strings.HasPrefix(trimmed, "#"):
+			if blockStart == -1 {
+				blockStart = index
+			}
+			continue
+		}
+
+		start := index
+		if blockStart != -1 {
+			start = blockStart
+		}
+		blockStart = -1
+
+		if strings.HasPrefix(trimmed, `"""`) || strings.HasPrefix(trimmed, "```") {
+			docString = trimmed[:3]
+			continue
+		}
+
+		if keyword := sectionKeyword(trimmed); keyword != "" {
+			sections = append(sections, featureSection{keyword: keyword, text: strings.TrimSpace(trimmed[len(keyword)+1:]), start: start})
+		} else if len(sections) > 0 && sections[len(sections)-1].keyword == "Examples" && strings.HasPrefix(trimmed, "|") {
+			last := &sections[len(sections)-1]
+			last.rows = append(last.rows, index)
+		}
+	}
+
+	feature.shared = [2]int{0, len(feature.source)}
+	for index, section := range sections {
+		end := len(feature.source)
+		if index+1 < len(sections) {
+			end = sections[index+1].start
+		}
+
+		switch section.keyword {
+		case "Scenario":
+			feature.scenarios = append(feature.scenarios, featureScenario{name: section.text, lines: [][2]int{{section.start, end}}})
+
+		case "Scenario Outline":
+			//Every row of the Examples of the outline is run as an outline of its own
+			for _, examples := range sections[index+1:] {
+				if examples.keyword != "Examples" {
+					break
+				}
+				if len(examples.rows) < 2 {
+					continue
+				}
+				for _, row := range examples.rows[1:] {
+					feature.scenarios = append(feature.scenarios, featureScenario{
+						name:  fmt.Sprintf("%s (%s)", section.text, strings.Join(tableCells(feature.source[row]), ", ")),
+						lines: [][2]int{{section.start, end}, {examples.start, examples.rows[0] + 1}, {row, row + 1}},
+					})
+				}
+			}
+
+		default:
+			continue
+		}
+
+		if feature.shared[1] > section.start {
+			feature.shared[1] = section.start
+		}
+	}
+
+	return feature
+}
+
+//sectionKeyword returns the keyword of line when it starts a section, with the synonyms Gherkin allows
+//replaced by Scenario, Scenario Outline and Examples
+func sectionKeyword(line string) string {
+	for _, keyword := range []string{"Feature", "Background", "Scenario Outline", "Scenario Template", "Scenario", "Example", "Examples", "Scenarios"} {
+		if !strings.HasPrefix(line, keyword+":") {
+			continue
+		}
+		switch keyword {
+		case "Scenario Template":
+			return "Scenario Outline"
+		case "Example":
+			return "Scenario"
+		case "Scenarios":
+			return "Examples"
+		}
+		return keyword
+	}
+	return ""
+}
+
+//render writes scenario as a feature of its own, copying its lines after the header and Background of f
+func (f parsedFeature) render(scenario featureScenario) string {
+	var rendered strings.Builder
+	for _, lines := range append([][2]int{f.shared}, scenario.lines...) {
+		for _, line := range f.source[lines[0]:lines[1]] {
+			rendered.WriteString(line)
+		}
+		if !strings.HasSuffix(rendered.String(), "\n") {
+			rendered.WriteString("\n")
+		}
+	}
+	return rendered.String()
+}
+
+func tableCells(row string) []string {
+	row = strings.TrimSpace(row)
+	row = strings.TrimPrefix(row, "|")
+	row = strings.TrimSuffix(row, "|")
+
+	var cells []string
+	for _, cell := range strings.Split(row, "|") {
+		cells = append(cells, strings.TrimSpace(cell))
+	}
+	return cells
+}
+
+func slug(name string) string {
+	slugged := strings.Trim(nonSlugCharacters.ReplaceAllString(strings.ToLower(name), "-"), "-")
+	if len(slugged) > 60 {
+		slugged = strings.TrimRight(slugged[:60], "-")
+	}
+	return slugged
+}
@@ -0,0 +1,165 @@
+/*
+Copyright (C) 2017 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSplitFeatures(t *testing.T) {
+	dir, err := ioutil.TempDir("", "split-features")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	source := filepath.Join(dir, "matrix.feature")
+	content := `@che @che6
+Feature: Che add-on
+  Che addon starts Eclipse Che
+
+  Background:
+    Given the server is up
+
+  Scenario Outline: User starts workspace
+    When starting a workspace with stack "<stack>" succeeds
+    When importing the sample project "<sample>" succeeds
+
+    Examples:
+    | stack       | sample                         |
+    | Java CentOS | https://github.com/a/java.git  |
+    | Node        | https://github.com/a/node.git  |
+
+  @smoke
+  Scenario: Stacks are listed
+    When we try to get the stacks information
+`
+	if err := ioutil.WriteFile(source, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	outDir := filepath.Join(dir, "out")
+	os.Mkdir(outDir, 0755)
+
+	written, err := SplitFeatures([]string{dir}, outDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(written) != 3 {
+		t.Fatalf("expected 3 features, got %v", written)
+	}
+
+	first, _ := ioutil.ReadFile(written[0])
+	expected := `@che @che6
+Feature: Che add-on
+  Che addon starts Eclipse Che
+
+  Background:
+    Given the server is up
+
+  Scenario Outline: User starts workspace
+    When starting a workspace with stack "<stack>" succeeds
+    When importing the sample project "<sample>" succeeds
+
+    Examples:
+    | stack       | sample                         |
+    | Java CentOS | https://github.com/a/java.git  |
+`
+	if string(first) != expected {
+		t.Errorf("unexpected feature:\n%s", first)
+	}
+
+	last, _ := ioutil.ReadFile(written[2])
+	if !strings.Contains(string(last), "  @smoke\n  Scenario: Stacks are listed\n") {
+		t.Errorf("scenario tags were lost:\n%s", last)
+	}
+}
+
+func TestSplitFeaturesCopiesScenariosAsWritten(t *testing.T) {
+	dir, err := ioutil.TempDir("", "split-features")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	header := `# language: en
+@che
+Feature: Stacks
+  Stacks can be created from JSON
+
+`
+	created := `  @create
+  Scenario: Creating a stack from JSON
+    When the stack is created from
+      """
+      {
+        "name": "Go",
+          "tags": ["Go"]
+      }
+      Scenario: this is part of the docstring
+      """
+    Then the stacks are
+      | name | tags    |
+      | Go   | Go, Dep |
+
+`
+	outline := `  Scenario Outline: Running <command>
+    # The command of the sample
+    When user runs "<command>" with
+      ` + "```" + `
+        <command> --verbose
+      ` + "```" + `
+
+    @fast
+    Examples: Builds
+      | command |
+      | build   |
+      | test    |
+`
+	if err := ioutil.WriteFile(filepath.Join(dir, "stacks.feature"), []byte(header+created+outline), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	outDir := filepath.Join(dir, "out")
+	os.Mkdir(outDir, 0755)
+	written, err := SplitFeatures([]string{filepath.Join(dir, "stacks.feature")}, outDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(written) != 3 {
+		t.Fatalf("expected a scenario and two outline rows, got %v", written)
+	}
+
+	examples := `    @fast
+    Examples: Builds
+      | command |
+`
+	for index, expected := range []string{
+		header + created,
+		header + strings.SplitAfter(outline, "\n\n")[0] + examples + "      | build   |\n",
+		header + strings.SplitAfter(outline, "\n\n")[0] + examples + "      | test    |\n",
+	} {
+		feature, _ := ioutil.ReadFile(written[index])
+		if string(feature) != expected {
+			t.Errorf("scenario %d was not copied as written:\n%s\nexpected:\n%s", index, feature, expected)
+		}
+	}
+}
@@ -0,0 +1,202 @@
+/*
+Copyright (C) 2017 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+type jsonRPCMessage struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      *int            `json:"id,omitempty"`
+	Method  string          `json:"method,omitempty"`
+	Params  interface{}     `json:"params,omitempty"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *jsonRPCError   `json:"error,omitempty"`
+}
+
+type jsonRPCIncoming struct {
+	ID     *int            `json:"id"`
+	Method string          `json:"method"`
+	Params json.RawMessage `json:"params"`
+	Result json.RawMessage `json:"result"`
+	Error  *jsonRPCError   `json:"error"`
+}
+
+type jsonRPCError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *jsonRPCError) Error() string {
+	return fmt.Sprintf("JSON-RPC error %d: %s", e.Code, e.Message)
+}
+
+//jsonRPCConn is a JSON-RPC 2.0 connection over a WebSocket, as spoken by the Che6 agents and master
+type jsonRPCConn struct {
+	ws             *websocket.Conn
+	onNotification func(method string, params json.RawMessage)
+	onClose        func(err error)
+
+	writeMu sync.Mutex
+	mu      sync.Mutex
+	nextID  int
+	pending map[int]chan jsonRPCIncoming
+	closed  bool
+}
+
+//dialJSONRPC connects to wsURL and starts reading the responses and notifications it sends
+func dialJSONRPC(ctx context.Context, wsURL string, auth TokenSource, onNotification func(string, json.RawMessage), onClose func(error)) (*jsonRPCConn, error) {
+	ws, dialErr := dialWebSocket(ctx, wsURL, auth)
+	if dialErr != nil {
+		return nil, dialErr
+	}
+
+	conn := &jsonRPCConn{
+		ws:             ws,
+		onNotification: onNotification,
+		onClose:        onClose,
+		pending:        make(map[int]chan jsonRPCIncoming),
+	}
+	go conn.readLoop()
+
+	return conn, nil
+}
+
+//dialWebSocket connects to wsURL, passing the bearer token both as a header and as the token query parameter Che expects
+func dialWebSocket(ctx context.Context, wsURL string, auth TokenSource) (*websocket.Conn, error) {
+	header := http.Header{}
+
+	if auth != nil {
+		token, tokenErr := auth.Token()
+		if tokenErr != nil {
+			return nil, tokenErr
+		}
+		header.Set("Authorization", "Bearer "+token)
+
+		parsed, parseErr := url.Parse(wsURL)
+		if parseErr != nil {
+			return nil, parseErr
+		}
+		query := parsed.Query()
+		query.Set("token", token)
+		parsed.RawQuery = query.Encode()
+		wsURL = parsed.String()
+	}
+
+	dialer := websocket.Dialer{HandshakeTimeout: 30 * time.Second}
+	ws, _, dialErr := dialer.DialContext(ctx, wsURL, header)
+	return ws, dialErr
+}
+
+//call sends a request and waits for its response, unmarshalling the result into result when it is not nil
+func (j *jsonRPCConn) call(ctx context.Context, method string, params interface{}, result interface{}) error {
+	j.mu.Lock()
+	if j.closed {
+		j.mu.Unlock()
+		return fmt.Errorf("JSON-RPC connection is closed")
+	}
+	j.nextID++
+	id := j.nextID
+	response := make(chan jsonRPCIncoming, 1)
+	j.pending[id] = response
+	j.mu.Unlock()
+
+	j.writeMu.Lock()
+	writeErr := j.ws.WriteJSON(jsonRPCMessage{JSONRPC: "2.0", ID: &id, Method: method, Params: params})
+	j.writeMu.Unlock()
+	if writeErr != nil {
+		return writeErr
+	}
+
+	select {
+	case message, ok := <-response:
+		if !ok {
+			return fmt.Errorf("JSON-RPC connection closed while waiting for %s", method)
+		}
+		if message.Error != nil {
+			return message.Error
+		}
+		if result != nil && len(message.Result) > 0 {
+			return json.Unmarshal(message.Result, result)
+		}
+		return nil
+	case <-time.After(60 * time.Second):
+		j.forget(id)
+		return fmt.Errorf("Timed out waiting for the response to %s", method)
+	case <-ctx.Done():
+		j.forget(id)
+		return ctx.Err()
+	}
+}
+
+//forget stops waiting for the response to the request id
+func (j *jsonRPCConn) forget(id int) {
+	j.mu.Lock()
+	delete(j.pending, id)
+	j.mu.Unlock()
+}
+
+//Close closes the underlying WebSocket
+func (j *jsonRPCConn) Close() error {
+	return j.ws.Close()
+}
+
+func (j *jsonRPCConn) readLoop() {
+	var readErr error
+	for {
+		var message jsonRPCIncoming
+		if readErr = j.ws.ReadJSON(&message); readErr != nil {
+			break
+		}
+
+		if message.ID != nil && message.Method == "" {
+			j.mu.Lock()
+			response, ok := j.pending[*message.ID]
+			delete(j.pending, *message.ID)
+			j.mu.Unlock()
+			if ok {
+				response <- message
+			}
+			continue
+		}
+
+		if message.Method != "" && j.onNotification != nil {
+			j.onNotification(message.Method, message.Params)
+		}
+	}
+
+	j.mu.Lock()
+	j.closed = true
+	for id, response := range j.pending {
+		close(response)
+		delete(j.pending, id)
+	}
+	j.mu.Unlock()
+
+	if j.onClose != nil {
+		j.onClose(readErr)
+	}
+}
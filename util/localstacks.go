@@ -0,0 +1,148 @@
+/*
+Copyright (C) 2017 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+//LocalStacksConfig is where stack definitions that are not on the server yet are read from
+type LocalStacksConfig struct {
+	//Dir holds the stack JSON files, one stack per file as it would be POSTed to /stack
+	Dir string `json:"dir" yaml:"dir"`
+	//Register POSTs the stacks to /stack for the run and deletes them afterwards,
+	//otherwise they are only added to the stacks read from the server
+	Register bool `json:"register" yaml:"register"`
+}
+
+//LocalStack is a stack definition read from a file
+type LocalStack struct {
+	Stack Workspace
+	Path  string
+	raw   json.RawMessage
+}
+
+//LoadStackDir reads the stack definitions of the *.json files in dir, sorted by file name
+func LoadStackDir(dir string) ([]LocalStack, error) {
+	paths, globErr := filepath.Glob(filepath.Join(dir, "*.json"))
+	if globErr != nil {
+		return nil, globErr
+	}
+	sort.Strings(paths)
+
+	var stacks []LocalStack
+	names := make(map[string]string)
+	for _, path := range paths {
+		data, readErr := ioutil.ReadFile(path)
+		if readErr != nil {
+			return nil, readErr
+		}
+
+		stack := LocalStack{Path: path, raw: data}
+		if jsonErr := json.Unmarshal(data, &stack.Stack); jsonErr != nil {
+			return nil, fmt.Errorf("Could not parse stack %s: %v", path, jsonErr)
+		}
+		if stack.Stack.Name == "" {
+			return nil, fmt.Errorf("Stack %s has no name", path)
+		}
+		if other, ok := names[stack.Stack.Name]; ok {
+			return nil, fmt.Errorf("Stacks %s and %s are both named %q", other, path, stack.Stack.Name)
+		}
+		names[stack.Stack.Name] = path
+
+		stacks = append(stacks, stack)
+	}
+
+	if len(stacks) == 0 {
+		return nil, fmt.Errorf("No stack JSON files in %s", dir)
+	}
+	return stacks, nil
+}
+
+//StackDefinitions returns the stack definitions of local
+func StackDefinitions(local []LocalStack) []Workspace {
+	var stacks []Workspace
+	for _, stack := range local {
+		stacks = append(stacks, stack.Stack)
+	}
+	return stacks
+}
+
+//RegisterStacks POSTs the local stacks to /stack as they were read and returns them as the server
+//created them. When one cannot be registered the ones registered before it are deleted again.
+func (c *CheAPI) RegisterStacks(ctx context.Context, local []LocalStack) ([]Workspace, error) {
+	var registered []Workspace
+	for _, stack := range local {
+		created, createErr := c.postStack(ctx, string(stack.raw))
+		if createErr != nil {
+			if rollbackErr := c.UnregisterStacks(ctx, registered); rollbackErr != nil {
+				return nil, fmt.Errorf("Could not register stack %s: %w. Could not roll back the stacks registered before it: %v", stack.Path, createErr, rollbackErr)
+			}
+			return nil, fmt.Errorf("Could not register stack %s: %w", stack.Path, createErr)
+		}
+		c.logf("Registered stack %q of %s as %s", created.Name, stack.Path, created.ID)
+		registered = append(registered, created)
+	}
+	return registered, nil
+}
+
+//UnregisterStacks deletes the stacks from the server, stacks that are already gone are skipped
+func (c *CheAPI) UnregisterStacks(ctx context.Context, stacks []Workspace) error {
+	var failures []string
+	for _, stack := range stacks {
+		if deleteErr := c.DeleteStack(ctx, stack.ID); deleteErr != nil && !IsStatus(deleteErr, http.StatusNotFound) {
+			failures = append(failures, deleteErr.Error())
+			continue
+		}
+		c.logf("Unregistered stack %q", stack.Name)
+	}
+
+	if len(failures) > 0 {
+		return fmt.Errorf("Could not unregister %d stacks: %s", len(failures), strings.Join(failures, "; "))
+	}
+	return nil
+}
+
+//withLocalStacks adds the LocalStacks of c to the stacks read from the server. A local stack
+//replaces the stack of the server with the same name, it is the one being worked on.
+func (c *CheAPI) withLocalStacks(stacks []Workspace) []Workspace {
+	if len(c.LocalStacks) == 0 {
+		return stacks
+	}
+
+	local := make(map[string]bool)
+	for _, stack := range c.LocalStacks {
+		local[stack.Name] = true
+	}
+
+	var merged []Workspace
+	for _, stack := range stacks {
+		if local[stack.Name] {
+			c.logf("Local stack %q replaces the one on the server", stack.Name)
+			continue
+		}
+		merged = append(merged, stack)
+	}
+	return append(merged, c.LocalStacks...)
+}
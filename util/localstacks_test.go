@@ -0,0 +1,180 @@
+/*
+Copyright (C) 2017 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const localStackJSON = `{
+  "name": "Java CentOS",
+  "description": "Java JDK stack on CentOS, work in progress",
+  "tags": ["Java", "JDK", "Maven", "CentOS"],
+  "workspaceConfig": {
+    "name": "default",
+    "defaultEnv": "default",
+    "environments": {"default": {"recipe": {"type": "dockerimage", "content": "registry.centos.org/che-stacks/centos-jdk9"}}}
+  },
+  "stackIcon": {"name": "type-java.svg", "mediaType": "image/svg+xml"}
+}`
+
+//writeStackDir writes the stack files to a temporary directory
+func writeStackDir(t *testing.T, files map[string]string) string {
+	dir, err := ioutil.TempDir("", "stacks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestLoadStackDir(t *testing.T) {
+	dir := writeStackDir(t, map[string]string{
+		"java.json":   localStackJSON,
+		"node.json":   `{"name": "Node", "tags": ["Node.js"]}`,
+		"README.md":   "not a stack",
+		"broken.json": `{"name": `,
+	})
+	defer os.RemoveAll(dir)
+
+	if _, err := LoadStackDir(dir); err == nil || !strings.Contains(err.Error(), "broken.json") {
+		t.Errorf("expected the broken file to be reported, got %v", err)
+	}
+
+	os.Remove(filepath.Join(dir, "broken.json"))
+	stacks, err := LoadStackDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := StackDefinitions(stacks)
+	if len(names) != 2 || names[0].Name != "Java CentOS" || names[1].Name != "Node" {
+		t.Errorf("expected the stacks sorted by file name, got %+v", names)
+	}
+
+	ioutil.WriteFile(filepath.Join(dir, "other.json"), []byte(`{"name": "Node"}`), 0644)
+	if _, err := LoadStackDir(dir); err == nil {
+		t.Error("two stacks with the same name should be rejected")
+	}
+
+	empty := writeStackDir(t, nil)
+	defer os.RemoveAll(empty)
+	if _, err := LoadStackDir(empty); err == nil {
+		t.Error("a directory without stacks is most likely a mistake")
+	}
+}
+
+func TestLocalStacksReplaceTheStacksOfTheServer(t *testing.T) {
+	fake, c := newFakeCheAPI(6)
+	defer fake.Close()
+
+	dir := writeStackDir(t, map[string]string{"java.json": localStackJSON})
+	defer os.RemoveAll(dir)
+	local, err := LoadStackDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.LocalStacks = StackDefinitions(local)
+
+	stacks, err := c.GetStackInformation(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stacks) != 2 {
+		t.Fatalf("expected the server stack and the local one, got %+v", stacks)
+	}
+	for _, stack := range stacks {
+		if stack.Name == "Java CentOS" && stack.ID != "" {
+			t.Errorf("expected the local Java CentOS stack, got the one of the server: %+v", stack)
+		}
+	}
+}
+
+func TestRegisterAndUnregisterStacks(t *testing.T) {
+	fake, c := newFakeCheAPI(6)
+	defer fake.Close()
+
+	dir := writeStackDir(t, map[string]string{
+		"go.json":   `{"name": "Go", "tags": ["Go"]}`,
+		"java.json": localStackJSON,
+	})
+	defer os.RemoveAll(dir)
+	local, err := LoadStackDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//Java CentOS is on the server already, so Go has to be rolled back
+	if _, err := c.RegisterStacks(context.Background(), local); err == nil || !strings.Contains(err.Error(), "java.json") {
+		t.Fatalf("expected the conflict of java.json, got %v", err)
+	}
+	if len(fake.Stacks()) != len(FakeStacks()) {
+		t.Errorf("the stacks registered before the failure should be removed, got %+v", fake.Stacks())
+	}
+
+	registered, err := c.RegisterStacks(context.Background(), local[:1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(registered) != 1 || registered[0].ID == "" || len(fake.Stacks()) != len(FakeStacks())+1 {
+		t.Fatalf("expected Go on the server, got %+v", fake.Stacks())
+	}
+
+	if err := c.UnregisterStacks(context.Background(), registered); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.UnregisterStacks(context.Background(), registered); err != nil {
+		t.Errorf("stacks that are gone already should be skipped: %v", err)
+	}
+	if len(fake.Stacks()) != len(FakeStacks()) {
+		t.Errorf("expected Go to be removed, got %+v", fake.Stacks())
+	}
+}
+
+func TestRegisterStacksReportsAFailedRollback(t *testing.T) {
+	fake, c := newFakeCheAPI(6)
+	defer fake.Close()
+
+	dir := writeStackDir(t, map[string]string{
+		"go.json":   `{"name": "Go", "tags": ["Go"]}`,
+		"java.json": localStackJSON,
+	})
+	defer os.RemoveAll(dir)
+	local, err := LoadStackDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//Go stays on the server when it cannot be deleted, the caller has to hear about it
+	fake.Fail(http.MethodDelete, "/api/stack", http.StatusInternalServerError, "Database is down", 1)
+	_, err = c.RegisterStacks(context.Background(), local)
+	if err == nil || !strings.Contains(err.Error(), "java.json") || !strings.Contains(err.Error(), "Database is down") {
+		t.Fatalf("expected the conflict of java.json and the failed rollback, got %v", err)
+	}
+	if len(fake.Stacks()) != len(FakeStacks())+1 {
+		t.Errorf("expected Go to be left on the server, got %+v", fake.Stacks())
+	}
+}
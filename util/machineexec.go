@@ -0,0 +1,269 @@
+/*
+Copyright (C) 2017 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+//che-machine-exec notifications about the processes it runs
+const (
+	MachineExecExit  = "onExecExit"
+	MachineExecError = "onExecError"
+)
+
+//attachGrace is how long output still arriving after a process exited is waited for
+const attachGrace = 2 * time.Second
+
+type machineExecIdentifier struct {
+	MachineName string `json:"machineName"`
+	WorkspaceID string `json:"workspaceId"`
+}
+
+type machineExecParams struct {
+	Identifier machineExecIdentifier `json:"identifier"`
+	Cmd        []string              `json:"cmd"`
+	Tty        bool                  `json:"tty"`
+	Cwd        string                `json:"cwd"`
+}
+
+type machineExecEvent struct {
+	ID    int    `json:"id"`
+	Code  int    `json:"code"`
+	Stack string `json:"stack"`
+}
+
+//RunMachineExec runs commandLine through che-machine-exec in the devfile container of a Che7 workspace and waits until it exits
+func (c *CheAPI) RunMachineExec(ctx context.Context, name, commandLine string) (ProcessRecord, error) {
+	return c.runMachineExec(ctx, name, commandLine, nil)
+}
+
+//RunMachineExecUntilReady runs commandLine like RunMachineExec but returns as soon as probe passes, leaving a server running.
+//The http and tcp probes go to the servers of the workspace, the log probe matches the output of the command.
+func (c *CheAPI) RunMachineExecUntilReady(ctx context.Context, name, commandLine string, probe ReadinessProbe) (ProcessRecord, error) {
+	return c.runMachineExec(ctx, name, commandLine, &probe)
+}
+
+//runMachineExec runs commandLine until it exits or, when there is a probe, until the probe passes
+func (c *CheAPI) runMachineExec(ctx context.Context, name, commandLine string, probe *ReadinessProbe) (ProcessRecord, error) {
+	process := ProcessRecord{Name: name, CommandLine: commandLine, Alive: true, Started: time.Now()}
+
+	if c.MachineExecURL == "" {
+		return process, fmt.Errorf("The workspace runtime has no che-machine-exec")
+	}
+	if c.DevMachine == "" {
+		return process, fmt.Errorf("The workspace runtime has no devfile container to run %q in", commandLine)
+	}
+
+	exits := make(chan machineExecEvent, 16)
+	conn, dialErr := dialJSONRPC(ctx, c.MachineExecURL+"/connect", c.Auth, func(method string, params json.RawMessage) {
+		var event machineExecEvent
+		if (method != MachineExecExit && method != MachineExecError) || json.Unmarshal(params, &event) != nil {
+			return
+		}
+		if method == MachineExecError {
+			event.Code = -1
+		}
+		select {
+		case exits <- event:
+		default:
+		}
+	}, nil)
+	if dialErr != nil {
+		return process, dialErr
+	}
+	defer conn.Close()
+
+	params := machineExecParams{
+		Identifier: machineExecIdentifier{MachineName: c.DevMachine, WorkspaceID: c.WorkspaceID},
+		Cmd:        []string{"sh", "-c", commandLine},
+		Cwd:        projectsRoot,
+	}
+	if callErr := conn.call(ctx, "create", params, &process.Pid); callErr != nil {
+		return process, callErr
+	}
+
+	attach, attachErr := dialWebSocket(ctx, c.MachineExecURL+"/attach/"+strconv.Itoa(process.Pid), c.Auth)
+	if attachErr != nil {
+		return process, attachErr
+	}
+	defer attach.Close()
+
+	var outputMu sync.Mutex
+	var output strings.Builder
+	attached := make(chan struct{})
+	go func() {
+		defer close(attached)
+		for {
+			_, data, readErr := attach.ReadMessage()
+			if readErr != nil {
+				return
+			}
+			outputMu.Lock()
+			output.Write(data)
+			outputMu.Unlock()
+		}
+	}()
+
+	var check func() (bool, string)
+	var probeTicks <-chan time.Time
+	var timeout time.Duration
+	var deadline time.Time
+	lastReason := "the probe has not run"
+	if probe != nil {
+		var checkErr error
+		check, checkErr = c.readinessCheck(ctx, *probe, func() ([]string, error) {
+			outputMu.Lock()
+			defer outputMu.Unlock()
+			return outputLines(output.String()), nil
+		})
+		if checkErr != nil {
+			return process, &ReadinessError{Command: commandLine, Reason: checkErr.Error()}
+		}
+
+		ticker := time.NewTicker(durationOrDefault(c.ProcessPollInterval, 15*time.Second))
+		defer ticker.Stop()
+		probeTicks = ticker.C
+		timeout = durationOrDefault(probe.Timeout.Duration, 5*time.Minute)
+		deadline = time.Now().Add(timeout)
+	}
+
+	for {
+		select {
+		case event := <-exits:
+			if event.ID != process.Pid {
+				continue
+			}
+
+			select {
+			case <-attached:
+			case <-time.After(attachGrace):
+			}
+
+			process.Alive = false
+			process.ExitCode = event.Code
+			process.Finished = time.Now()
+			process.Duration = process.Finished.Sub(process.Started)
+
+			outputMu.Lock()
+			process.Output = outputLines(output.String())
+			outputMu.Unlock()
+			if event.Stack != "" {
+				process.Output = append(process.Output, event.Stack)
+			}
+			if probe != nil {
+				return process, &ReadinessError{
+					Command: commandLine,
+					Reason:  fmt.Sprintf("the process exited with %d before becoming ready", process.ExitCode),
+				}
+			}
+			return process, nil
+		case <-probeTicks:
+			ready, reason := check()
+			outputMu.Lock()
+			process.Output = outputLines(output.String())
+			outputMu.Unlock()
+			process.Duration = time.Since(process.Started)
+
+			if ready {
+				process.Ready = true
+				process.ReadyReason = reason
+				return process, nil
+			}
+			lastReason = reason
+
+			if time.Now().After(deadline) {
+				return process, &ReadinessError{
+					Command: commandLine,
+					Reason:  fmt.Sprintf("not ready within %s, %s", timeout, lastReason),
+				}
+			}
+		case <-ctx.Done():
+			outputMu.Lock()
+			process.Output = outputLines(output.String())
+			outputMu.Unlock()
+			return process, ctx.Err()
+		}
+	}
+}
+
+//cloneProjects clones samples into the projects of a Che7 workspace, which has no wsagent to import them
+func (c *CheAPI) cloneProjects(ctx context.Context, samples []Sample) error {
+	for _, sample := range samples {
+		commandLine := "git clone " + shellQuote(sample.Source.Location) + " " + shellQuote(projectsRoot+samplePath(sample))
+		process, runErr := c.RunMachineExec(ctx, "clone "+sample.Name, commandLine)
+		if runErr != nil {
+			return runErr
+		}
+		if process.ExitCode != 0 {
+			return fmt.Errorf("Cloning %s failed with exit code %d: %s", sample.Source.Location, process.ExitCode, process.OutputText())
+		}
+	}
+	return nil
+}
+
+//listProjects lists the project directories of a Che7 workspace
+func (c *CheAPI) listProjects(ctx context.Context) ([]Sample, error) {
+	process, runErr := c.RunMachineExec(ctx, "list projects", "ls -1 -p "+projectsRoot)
+	if runErr != nil {
+		return nil, runErr
+	}
+	if process.ExitCode != 0 {
+		return nil, fmt.Errorf("Listing the projects failed with exit code %d: %s", process.ExitCode, process.OutputText())
+	}
+
+	projects := []Sample{}
+	for _, line := range process.Output {
+		if strings.HasSuffix(line, "/") {
+			name := strings.TrimSuffix(line, "/")
+			projects = append(projects, Sample{Name: name, Path: "/" + name})
+		}
+	}
+	return projects, nil
+}
+
+//samplePath is the path of sample below the projects root
+func samplePath(sample Sample) string {
+	if sample.Path != "" {
+		return sample.Path
+	}
+	return "/" + sample.Name
+}
+
+//outputLines splits the output of a process into lines
+func outputLines(output string) []string {
+	output = strings.TrimRight(output, "\r\n")
+	if output == "" {
+		return nil
+	}
+
+	lines := strings.Split(output, "\n")
+	for index := range lines {
+		lines[index] = strings.TrimSuffix(lines[index], "\r")
+	}
+	return lines
+}
+
+func shellQuote(value string) string {
+	return "'" + strings.Replace(value, "'", `'\''`, -1) + "'"
+}
@@ -0,0 +1,248 @@
+/*
+Copyright (C) 2017 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+)
+
+//projectsRoot is where Che mounts the projects of a workspace
+const projectsRoot = "/projects"
+
+var macroPattern = regexp.MustCompile(`\$\{([^}]+)\}`)
+
+//MacroContext is what the Che IDE macros in command lines are expanded from
+type MacroContext struct {
+	Project            *Sample
+	Servers            map[string]ServerURL
+	WorkspaceName      string
+	WorkspaceNamespace string
+}
+
+//MacroError is returned when a command line uses macros that cannot be expanded
+type MacroError struct {
+	CommandLine string
+	Macros      []string
+	Reason      string
+}
+
+func (e *MacroError) Error() string {
+	return fmt.Sprintf("Could not expand %s in %q: %s", strings.Join(e.Macros, ", "), e.CommandLine, e.Reason)
+}
+
+type workspaceIdentity struct {
+	Namespace string `json:"namespace"`
+	Config    struct {
+		Name string `json:"name"`
+	} `json:"config"`
+	Devfile struct {
+		Metadata DevfileMetadata `json:"metadata"`
+	} `json:"devfile"`
+}
+
+//Expand replaces the Che macros in commandLine. Only ${...} with a dot in the name are treated
+//as macros so that shell variables such as ${HOME} are left for the shell.
+func (m MacroContext) Expand(commandLine string) (string, error) {
+	var unknown []string
+	var missingProject []string
+
+	expanded := macroPattern.ReplaceAllStringFunc(commandLine, func(macro string) string {
+		name := macroPattern.FindStringSubmatch(macro)[1]
+		if !strings.Contains(name, ".") {
+			return macro
+		}
+
+		value, known, needsProject := m.value(name)
+		if needsProject {
+			missingProject = append(missingProject, macro)
+			return macro
+		}
+		if !known {
+			unknown = append(unknown, macro)
+			return macro
+		}
+		return value
+	})
+
+	if len(unknown) > 0 {
+		return expanded, &MacroError{CommandLine: commandLine, Macros: unknown, Reason: "unknown macro"}
+	}
+	if len(missingProject) > 0 {
+		return expanded, &MacroError{CommandLine: commandLine, Macros: missingProject, Reason: "no project has been imported"}
+	}
+
+	return expanded, nil
+}
+
+//value returns the value of the macro name, whether it is known and whether it needs a project that is missing
+func (m MacroContext) value(name string) (string, bool, bool) {
+	if strings.HasPrefix(name, "current.project.") || strings.HasPrefix(name, "explorer.current.file.") {
+		if m.Project == nil {
+			return "", true, true
+		}
+		value, known := m.projectValue(name)
+		return value, known, false
+	}
+
+	switch name {
+	case "workspace.name":
+		return m.WorkspaceName, true, false
+	case "workspace.namespace":
+		return m.WorkspaceNamespace, true, false
+	}
+
+	if strings.HasPrefix(name, "server.") {
+		value, known := m.serverValue(strings.TrimPrefix(name, "server."))
+		return value, known, false
+	}
+
+	return "", false, false
+}
+
+//projectValue expands the project macros. Nothing is selected in the project explorer when the
+//suite runs a command, so the explorer macros refer to the project itself as the IDE does on the project root.
+func (m MacroContext) projectValue(name string) (string, bool) {
+	relPath := strings.TrimPrefix(m.Project.Path, "/")
+	if relPath == "" {
+		relPath = m.Project.Name
+	}
+	absPath := path.Join(projectsRoot, relPath)
+
+	switch name {
+	case "current.project.path", "explorer.current.file.path":
+		return absPath, true
+	case "current.project.relpath", "explorer.current.file.relpath":
+		return relPath, true
+	case "current.project.type":
+		return m.Project.ProjectType, true
+	case "explorer.current.file.name":
+		return path.Base(absPath), true
+	case "explorer.current.file.parent.path":
+		return path.Dir(absPath), true
+	}
+
+	return "", false
+}
+
+//serverValue expands ${server.<server>}, ${server.port.<server>}, ${server.<server>.hostname} and ${server.<server>.protocol}
+//where <server> is a server name, ref or port in the workspace runtime
+func (m MacroContext) serverValue(name string) (string, bool) {
+	part := "address"
+	switch {
+	case strings.HasPrefix(name, "port."):
+		part = "port"
+		name = strings.TrimPrefix(name, "port.")
+	case strings.HasSuffix(name, ".hostname"):
+		part = "hostname"
+		name = strings.TrimSuffix(name, ".hostname")
+	case strings.HasSuffix(name, ".protocol"):
+		part = "protocol"
+		name = strings.TrimSuffix(name, ".protocol")
+	}
+
+	server, serverErr := findServer(m.Servers, name)
+	if serverErr != nil {
+		return "", false
+	}
+
+	address, addressErr := hostPort(server.URL)
+	if addressErr != nil {
+		return "", false
+	}
+	host, port, splitErr := net.SplitHostPort(address)
+	if splitErr != nil {
+		return "", false
+	}
+
+	switch part {
+	case "port":
+		return port, true
+	case "hostname":
+		return host, true
+	case "protocol":
+		parsed, parseErr := url.Parse(server.URL)
+		if parseErr != nil || parsed.Scheme == "" {
+			return "http", true
+		}
+		return parsed.Scheme, true
+	}
+
+	return address, true
+}
+
+//MacroContext builds the macro context of the running workspace from its projects, runtime servers and config
+func (c *CheAPI) MacroContext(ctx context.Context) (MacroContext, error) {
+	macros := MacroContext{Servers: c.Servers}
+
+	workspaceJSON, _, reqErr := c.doRequest(ctx, http.MethodGet, c.CheAPIEndpoint+"/workspace/"+c.WorkspaceID, "")
+	if reqErr != nil {
+		return macros, reqErr
+	}
+
+	var identity workspaceIdentity
+	if jsonErr := json.Unmarshal(workspaceJSON, &identity); jsonErr != nil {
+		return macros, jsonErr
+	}
+	macros.WorkspaceName = identity.Config.Name
+	if macros.WorkspaceName == "" {
+		macros.WorkspaceName = identity.Devfile.Metadata.Name
+	}
+	macros.WorkspaceNamespace = identity.Namespace
+
+	projects, projectsErr := c.GetProjects(ctx)
+	if projectsErr != nil {
+		return macros, projectsErr
+	}
+
+	//Without a current project the first one is it
+	for index := range projects {
+		if c.CurrentProject == "" || projects[index].Path == c.CurrentProject {
+			macros.Project = &projects[index]
+			break
+		}
+	}
+
+	return macros, nil
+}
+
+//ResolveCommand expands the Che macros in the command line of command
+func (c *CheAPI) ResolveCommand(ctx context.Context, command Command) (Command, error) {
+	if !macroPattern.MatchString(command.CommandLine) {
+		return command, nil
+	}
+
+	macros, macrosErr := c.MacroContext(ctx)
+	if macrosErr != nil {
+		return command, macrosErr
+	}
+
+	commandLine, expandErr := macros.Expand(command.CommandLine)
+	if expandErr != nil {
+		return command, expandErr
+	}
+
+	command.CommandLine = commandLine
+	return command, nil
+}
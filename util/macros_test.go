@@ -0,0 +1,116 @@
+/*
+Copyright (C) 2017 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestMacroExpansion(t *testing.T) {
+	macros := MacroContext{
+		Project: &Sample{Name: "console-java-simple", Path: "/console-java-simple", ProjectType: "maven"},
+		Servers: map[string]ServerURL{
+			"8080/tcp": {URL: "http://che-host:32771", Ref: "tomcat8"},
+			"wsagent":  {URL: "https://che-host:32772/api"},
+		},
+		WorkspaceName:      "java-centos-stack-test",
+		WorkspaceNamespace: "che",
+	}
+
+	expansions := map[string]string{
+		"mvn clean install -f ${current.project.path}":            "mvn clean install -f /projects/console-java-simple",
+		"cd ${current.project.relpath}":                           "cd console-java-simple",
+		"echo ${explorer.current.file.path}":                      "echo /projects/console-java-simple",
+		"echo ${explorer.current.file.parent.path}":               "echo /projects",
+		"curl ${server.8080}/ && curl ${server.port.tomcat8}":     "curl che-host:32771/ && curl 32771",
+		"${server.wsagent.protocol}://${server.wsagent.hostname}": "https://che-host",
+		"echo ${workspace.name} in ${workspace.namespace}":        "echo java-centos-stack-test in che",
+		"echo ${HOME} $JAVA_HOME":                                 "echo ${HOME} $JAVA_HOME",
+	}
+
+	for commandLine, expected := range expansions {
+		expanded, err := macros.Expand(commandLine)
+		if err != nil {
+			t.Errorf("%s: %v", commandLine, err)
+		}
+		if expanded != expected {
+			t.Errorf("%s: expected %q, got %q", commandLine, expected, expanded)
+		}
+	}
+}
+
+func TestUnknownMacrosFail(t *testing.T) {
+	macros := MacroContext{Project: &Sample{Name: "app", Path: "/app"}}
+
+	_, err := macros.Expand("java ${current.class.fqn} ${server.port.9999}")
+	macroErr, ok := err.(*MacroError)
+	if !ok {
+		t.Fatalf("expected a MacroError, got %v", err)
+	}
+	if len(macroErr.Macros) != 2 || macroErr.Macros[0] != "${current.class.fqn}" || macroErr.Macros[1] != "${server.port.9999}" {
+		t.Errorf("unexpected macros %v", macroErr.Macros)
+	}
+}
+
+func TestProjectMacrosNeedAProject(t *testing.T) {
+	_, err := MacroContext{}.Expand("mvn -f ${current.project.path}")
+	if macroErr, ok := err.(*MacroError); !ok || macroErr.Reason != "no project has been imported" {
+		t.Errorf("expected a missing project error, got %v", err)
+	}
+}
+
+func TestCommandsAreResolvedBeforeExecution(t *testing.T) {
+	fake, c := newFakeCheAPI(5)
+	defer fake.Close()
+	startFakeWorkspace(t, fake, &c)
+
+	sample := FakeSamples()[1]
+	if err := c.AddSamplesToProject(context.Background(), []Sample{sample}); err != nil {
+		t.Fatal(err)
+	}
+
+	fake.SetProcess("mvn clean install -f /projects/console-java-simple", FakeProcess{ExitCode: 3, Duration: 10 * time.Millisecond})
+
+	process, err := c.PostCommandToWorkspace(context.Background(), sample.Commands[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if process.CommandLine != "mvn clean install -f /projects/console-java-simple" || process.ExitCode != 3 {
+		t.Errorf("the resolved command line should have been run: %+v", process)
+	}
+}
+
+func TestFirstProjectIsCurrentWithoutOne(t *testing.T) {
+	fake, c := newFakeCheAPI(5)
+	defer fake.Close()
+	startFakeWorkspace(t, fake, &c)
+
+	if err := c.AddSamplesToProject(context.Background(), []Sample{FakeSamples()[0], FakeSamples()[1]}); err != nil {
+		t.Fatal(err)
+	}
+	c.CurrentProject = ""
+
+	macros, err := c.MacroContext(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if macros.Project == nil || macros.Project.Name != FakeSamples()[0].Name {
+		t.Errorf("expected the first project %s, got %+v", FakeSamples()[0].Name, macros.Project)
+	}
+}
@@ -0,0 +1,426 @@
+/*
+Copyright (C) 2017 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+//Che master event types
+const (
+	WorkspaceStatusChanged = "workspace/statusChanged"
+	MachineStatusChanged   = "machine/statusChanged"
+)
+
+//WorkspaceEvent is a status change of a workspace or of one of its machines.
+//Polled is set on the changes seen by polling the workspace instead of being pushed by the Che master.
+type WorkspaceEvent struct {
+	Type        string    `json:"type"`
+	WorkspaceID string    `json:"workspaceId"`
+	Machine     string    `json:"machine,omitempty"`
+	Status      string    `json:"status"`
+	PrevStatus  string    `json:"prevStatus,omitempty"`
+	Error       string    `json:"error,omitempty"`
+	Time        time.Time `json:"time"`
+	Polled      bool      `json:"polled,omitempty"`
+}
+
+func (e WorkspaceEvent) String() string {
+	change := e.Status
+	if e.PrevStatus != "" {
+		change = e.PrevStatus + " -> " + e.Status
+	}
+	if e.Type == MachineStatusChanged {
+		change = "machine " + e.Machine + " " + change
+	}
+	if e.Error != "" {
+		change += ": " + e.Error
+	}
+	return change
+}
+
+//DescribeTransitions lists the statuses of workspaceID in events in order, e.g. "STARTING -> RUNNING -> STOPPING"
+func DescribeTransitions(events []WorkspaceEvent, workspaceID string) string {
+	var statuses []string
+	for _, event := range events {
+		if event.Type != WorkspaceStatusChanged || event.WorkspaceID != workspaceID {
+			continue
+		}
+		if len(statuses) == 0 && event.PrevStatus != "" {
+			statuses = append(statuses, event.PrevStatus)
+		}
+		if len(statuses) == 0 || statuses[len(statuses)-1] != event.Status {
+			statuses = append(statuses, event.Status)
+		}
+	}
+	return strings.Join(statuses, " -> ")
+}
+
+type workspaceStatusParams struct {
+	WorkspaceID string `json:"workspaceId"`
+	Status      string `json:"status"`
+	PrevStatus  string `json:"prevStatus"`
+	Error       string `json:"error"`
+}
+
+type machineStatusParams struct {
+	Identity struct {
+		WorkspaceID string `json:"workspaceId"`
+	} `json:"identity"`
+	MachineName string `json:"machineName"`
+	EventType   string `json:"eventType"`
+	Error       string `json:"error"`
+}
+
+type eventSubscription struct {
+	Method string            `json:"method"`
+	Scope  map[string]string `json:"scope"`
+}
+
+//MasterEventStream is a JSON-RPC connection to the Che6 master delivering workspace and machine status events as they happen
+type MasterEventStream struct {
+	conn *jsonRPCConn
+
+	mu          sync.Mutex
+	subscribers map[string]chan WorkspaceEvent
+}
+
+//DialMaster connects to the JSON-RPC WebSocket of the Che master
+func (c *CheAPI) DialMaster(ctx context.Context) (*MasterEventStream, error) {
+	wsURL, urlErr := c.masterWSURL()
+	if urlErr != nil {
+		return nil, urlErr
+	}
+
+	stream := &MasterEventStream{subscribers: make(map[string]chan WorkspaceEvent)}
+
+	conn, dialErr := dialJSONRPC(ctx, wsURL, c.Auth, stream.handleNotification, stream.handleClose)
+	if dialErr != nil {
+		return nil, dialErr
+	}
+	stream.conn = conn
+
+	return stream, nil
+}
+
+//Subscribe delivers the workspace and machine status events of workspaceID through the returned channel.
+//It returns once the Che master has confirmed the subscriptions, so that no event after it is missed.
+//The channel is closed once the connection is lost or closed.
+func (s *MasterEventStream) Subscribe(ctx context.Context, workspaceID string) (<-chan WorkspaceEvent, error) {
+	events := make(chan WorkspaceEvent, 1024)
+
+	s.mu.Lock()
+	s.subscribers[workspaceID] = events
+	s.mu.Unlock()
+
+	for _, method := range []string{WorkspaceStatusChanged, MachineStatusChanged} {
+		subscription := eventSubscription{Method: method, Scope: map[string]string{"workspaceId": workspaceID}}
+		if callErr := s.conn.call(ctx, "subscribe", subscription, nil); callErr != nil {
+			s.mu.Lock()
+			if s.subscribers[workspaceID] == events {
+				delete(s.subscribers, workspaceID)
+			}
+			s.mu.Unlock()
+			return nil, callErr
+		}
+	}
+
+	return events, nil
+}
+
+//Close closes the connection to the Che master
+func (s *MasterEventStream) Close() error {
+	return s.conn.Close()
+}
+
+func (s *MasterEventStream) handleNotification(method string, params json.RawMessage) {
+	event := WorkspaceEvent{Type: method, Time: time.Now()}
+
+	switch method {
+	case WorkspaceStatusChanged:
+		var status workspaceStatusParams
+		if json.Unmarshal(params, &status) != nil {
+			return
+		}
+		event.WorkspaceID = status.WorkspaceID
+		event.Status = status.Status
+		event.PrevStatus = status.PrevStatus
+		event.Error = status.Error
+	case MachineStatusChanged:
+		var status machineStatusParams
+		if json.Unmarshal(params, &status) != nil {
+			return
+		}
+		event.WorkspaceID = status.Identity.WorkspaceID
+		event.Machine = status.MachineName
+		event.Status = status.EventType
+		event.Error = status.Error
+	default:
+		return
+	}
+
+	//Notifications and the close are handled by the same read loop, so the channel
+	//cannot be closed while the event is sent
+	s.mu.Lock()
+	events, ok := s.subscribers[event.WorkspaceID]
+	s.mu.Unlock()
+
+	if ok {
+		events <- event
+	}
+}
+
+func (s *MasterEventStream) handleClose(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for workspaceID, events := range s.subscribers {
+		close(events)
+		delete(s.subscribers, workspaceID)
+	}
+}
+
+//masterWSURL is the JSON-RPC WebSocket of the Che master, next to its REST API
+func (c *CheAPI) masterWSURL() (string, error) {
+	endpoint, parseErr := url.Parse(c.CheAPIEndpoint)
+	if parseErr != nil {
+		return "", parseErr
+	}
+
+	switch endpoint.Scheme {
+	case "http":
+		endpoint.Scheme = "ws"
+	case "https":
+		endpoint.Scheme = "wss"
+	default:
+		return "", fmt.Errorf("Cannot derive the Che master WebSocket from %s", c.CheAPIEndpoint)
+	}
+	endpoint.Path = strings.TrimSuffix(endpoint.Path, "/") + "/websocket"
+
+	return endpoint.String(), nil
+}
+
+//WorkspaceWatch follows the status of a workspace, see WatchWorkspace
+type WorkspaceWatch struct {
+	events chan WorkspaceEvent
+	err    error
+}
+
+//Events delivers the status changes of the workspace. It is closed when the watch ends.
+func (w *WorkspaceWatch) Events() <-chan WorkspaceEvent {
+	return w.events
+}
+
+//Err is the error that ended the watch, once Events is closed. It is nil when the watch was cancelled.
+func (w *WorkspaceWatch) Err() error {
+	return w.err
+}
+
+//subscribeTimeout bounds waiting for the Che master to confirm a subscription, the status is polled without it
+const subscribeTimeout = 10 * time.Second
+
+//WatchWorkspace follows the status of workspace workspaceID until ctx is done. The first event is its current status.
+//Changes are pushed by the Che master WebSocket when the server has one (Che6), and polled every five
+//WorkspacePollIntervals in case a push gets lost. Without the WebSocket, or once the connection is lost, the status
+//is polled every WorkspacePollInterval, which misses changes that are undone in between.
+func (c *CheAPI) WatchWorkspace(ctx context.Context, workspaceID string) *WorkspaceWatch {
+	watch := &WorkspaceWatch{events: make(chan WorkspaceEvent)}
+
+	go func() {
+		defer close(watch.events)
+
+		last := ""
+		emit := func(event WorkspaceEvent) bool {
+			if event.Type == WorkspaceStatusChanged {
+				last = event.Status
+			}
+			select {
+			case watch.events <- event:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		}
+
+		if !c.pushEvents(ctx, workspaceID, &last, emit) {
+			return
+		}
+		watch.err = c.pollEvents(ctx, workspaceID, &last, emit)
+	}()
+
+	return watch
+}
+
+//pushEvents emits the events the Che master pushes for workspaceID. It returns false once the watch is over,
+//and true when the events have to be polled instead.
+func (c *CheAPI) pushEvents(ctx context.Context, workspaceID string, last *string, emit func(WorkspaceEvent) bool) bool {
+	if c.masterWSUnavailable {
+		return true
+	}
+
+	stream, dialErr := c.DialMaster(ctx)
+	if dialErr != nil {
+		if ctx.Err() != nil {
+			return false
+		}
+		//Che5 has no JSON-RPC endpoint on the master, don't try again for every wait
+		if dialErr == websocket.ErrBadHandshake {
+			c.masterWSUnavailable = true
+		}
+		c.logf("Che master WebSocket is unavailable, polling the status of workspace %s: %v", workspaceID, dialErr)
+		return true
+	}
+
+	subscribeCtx, cancelSubscribe := context.WithTimeout(ctx, subscribeTimeout)
+	pushed, subscribeErr := stream.Subscribe(subscribeCtx, workspaceID)
+	cancelSubscribe()
+	if subscribeErr != nil {
+		if ctx.Err() != nil {
+			stream.Close()
+			return false
+		}
+		stream.Close()
+		c.logf("Subscribing to the status of workspace %s failed, polling it: %v", workspaceID, subscribeErr)
+		return true
+	}
+
+	//The read loop closes pushed once the connection is closed, drain it so that it is never blocked
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-done:
+		}
+		stream.Close()
+		for range pushed {
+		}
+	}()
+
+	//Changes before the subscription took effect are not pushed, so start from the current status.
+	//When it cannot be read, polling ends the watch with the error.
+	status, statusErr := c.GetWorkspaceStatusByID(ctx, workspaceID)
+	if statusErr != nil {
+		return ctx.Err() == nil
+	}
+	if !emit(WorkspaceEvent{Type: WorkspaceStatusChanged, WorkspaceID: workspaceID, Status: status.WorkspaceStatus, Time: time.Now(), Polled: true}) {
+		return false
+	}
+
+	safetyPoll := time.NewTicker(5 * durationOrDefault(c.WorkspacePollInterval, 2*time.Second))
+	defer safetyPoll.Stop()
+
+	for {
+		select {
+		case event, ok := <-pushed:
+			if !ok {
+				if ctx.Err() != nil {
+					return false
+				}
+				c.logf("Lost the Che master WebSocket, polling the status of workspace %s", workspaceID)
+				return true
+			}
+			if event.Type == WorkspaceStatusChanged && event.Status == *last {
+				continue
+			}
+			if !emit(event) {
+				return false
+			}
+		case <-safetyPoll.C:
+			status, statusErr := c.GetWorkspaceStatusByID(ctx, workspaceID)
+			if statusErr != nil {
+				if ctx.Err() != nil {
+					return false
+				}
+				c.logf("Could not check the status of workspace %s, relying on the Che master WebSocket: %v", workspaceID, statusErr)
+				continue
+			}
+			if status.WorkspaceStatus == *last {
+				continue
+			}
+			if !emit(WorkspaceEvent{Type: WorkspaceStatusChanged, WorkspaceID: workspaceID, Status: status.WorkspaceStatus, PrevStatus: *last, Time: time.Now(), Polled: true}) {
+				return false
+			}
+		case <-ctx.Done():
+			return false
+		}
+	}
+}
+
+//pollEvents emits the status changes of workspaceID seen by polling it, until ctx is done or a request fails
+func (c *CheAPI) pollEvents(ctx context.Context, workspaceID string, last *string, emit func(WorkspaceEvent) bool) error {
+	for {
+		status, statusErr := c.GetWorkspaceStatusByID(ctx, workspaceID)
+		if ctx.Err() != nil {
+			return nil
+		}
+		if statusErr != nil {
+			return statusErr
+		}
+
+		if status.WorkspaceStatus != *last {
+			event := WorkspaceEvent{Type: WorkspaceStatusChanged, WorkspaceID: workspaceID, Status: status.WorkspaceStatus, PrevStatus: *last, Time: time.Now(), Polled: true}
+			if !emit(event) {
+				return nil
+			}
+		}
+
+		if sleep(ctx, durationOrDefault(c.WorkspacePollInterval, 2*time.Second)) != nil {
+			return nil
+		}
+	}
+}
+
+//watchUntil follows workspace workspaceID until done accepts its status, recording every event in StatusHistory.
+//It returns the last workspace status event.
+func (c *CheAPI) watchUntil(ctx context.Context, workspaceID string, done func(status string) bool) (WorkspaceEvent, error) {
+	watchCtx, cancel := context.WithCancel(ctx)
+	watch := c.WatchWorkspace(watchCtx, workspaceID)
+
+	//Wait for the watch to end so that it does not outlive the wait
+	defer func() {
+		cancel()
+		for range watch.Events() {
+		}
+	}()
+
+	var last WorkspaceEvent
+	for event := range watch.Events() {
+		c.StatusHistory = append(c.StatusHistory, event)
+		if event.Type != WorkspaceStatusChanged {
+			continue
+		}
+
+		last = event
+		if done(event.Status) {
+			return last, nil
+		}
+	}
+
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return last, ctxErr
+	}
+	return last, watch.Err()
+}
@@ -0,0 +1,158 @@
+/*
+Copyright (C) 2017 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestMasterEventsCatchShortTransitions(t *testing.T) {
+	fake, c := newFakeCheAPI(6)
+	defer fake.Close()
+
+	//Polling this slowly would only ever see STOPPED
+	c.WorkspacePollInterval = time.Second
+	fake.SetStartTransitions(
+		FakeTransition{Status: "STARTING"},
+		FakeTransition{Status: "STOPPED", After: 30 * time.Millisecond},
+	)
+
+	started := time.Now()
+	stack := FakeStacks()[0]
+	workspace, err := c.StartWorkspace(context.Background(), stack.Config.EnvironmentConfig, stack.ID)
+	if statusErr, ok := err.(*WorkspaceStatusError); !ok || statusErr.Status != "STOPPED" {
+		t.Fatalf("expected the start to fail in STOPPED, got %v", err)
+	}
+	if time.Since(started) > 500*time.Millisecond {
+		t.Errorf("expected STOPPED to be pushed straight away, took %s", time.Since(started))
+	}
+
+	if transitions := DescribeTransitions(c.StatusHistory, workspace.ID); transitions != "STARTING -> STOPPED" {
+		t.Errorf("unexpected transitions %q", transitions)
+	}
+
+	machineEvents := 0
+	for _, event := range c.StatusHistory {
+		if event.Type == MachineStatusChanged && event.Machine == "dev-machine" && !event.Polled {
+			machineEvents++
+		}
+	}
+	if machineEvents == 0 {
+		t.Errorf("expected the machine status changes in the history: %v", c.StatusHistory)
+	}
+}
+
+func TestWatchWorkspacePollsWithoutMasterWebSocket(t *testing.T) {
+	fake, c := newFakeCheAPI(5)
+	defer fake.Close()
+
+	stack := FakeStacks()[0]
+	workspace, err := c.StartWorkspace(context.Background(), stack.Config.EnvironmentConfig, stack.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.StopWorkspace(context.Background(), workspace.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	if !c.masterWSUnavailable {
+		t.Error("Che5 has no master WebSocket, it should not be dialled again")
+	}
+	for _, event := range c.StatusHistory {
+		if !event.Polled {
+			t.Errorf("Che5 events can only be polled, got %+v", event)
+		}
+	}
+	if transitions := DescribeTransitions(c.StatusHistory, workspace.ID); transitions != "STARTING -> RUNNING -> STOPPING -> STOPPED" {
+		t.Errorf("unexpected transitions %q", transitions)
+	}
+}
+
+func TestWatchWorkspaceFallsBackToPolling(t *testing.T) {
+	fake, c := newFakeCheAPI(6)
+	defer fake.Close()
+
+	fake.SetStartTransitions(
+		FakeTransition{Status: "STARTING"},
+		FakeTransition{Status: "RUNNING", After: 200 * time.Millisecond},
+	)
+	workspace := fake.AddWorkspace(Post{Name: "watched"}, time.Now(), false, nil)
+	if _, _, err := c.doRequest(context.Background(), http.MethodPost, c.CheAPIEndpoint+"/workspace/"+workspace+"/runtime", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	watch := c.WatchWorkspace(ctx, workspace)
+	first := <-watch.Events()
+	if first.Status != "STARTING" {
+		t.Fatalf("expected the current status first, got %+v", first)
+	}
+
+	fake.CloseMasterSockets()
+
+	for event := range watch.Events() {
+		if event.Type == WorkspaceStatusChanged && event.Status == "RUNNING" {
+			if !event.Polled || event.PrevStatus != "STARTING" {
+				t.Errorf("expected RUNNING to be polled once the connection was lost, got %+v", event)
+			}
+			return
+		}
+	}
+	t.Errorf("the watch ended without RUNNING: %v", watch.Err())
+}
+
+func TestLostPushesAreCaughtByPolling(t *testing.T) {
+	fake, c := newFakeCheAPI(6)
+	defer fake.Close()
+
+	fake.SilenceEvents()
+	fake.SetStartTransitions(
+		FakeTransition{Status: "STARTING"},
+		FakeTransition{Status: "RUNNING", After: 50 * time.Millisecond},
+	)
+
+	stack := FakeStacks()[0]
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	workspace, err := c.StartWorkspace(ctx, stack.Config.EnvironmentConfig, stack.ID)
+	if err != nil {
+		t.Fatalf("expected the start to be seen without pushed events, got %v", err)
+	}
+
+	stream, err := c.DialMaster(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stream.Close()
+	if _, err := stream.Subscribe(ctx, workspace.ID); err != nil {
+		t.Errorf("expected the master to confirm the subscription, got %v", err)
+	}
+
+	if transitions := DescribeTransitions(c.StatusHistory, workspace.ID); transitions != "STARTING -> RUNNING" {
+		t.Errorf("unexpected transitions %q", transitions)
+	}
+	for _, event := range c.StatusHistory {
+		if !event.Polled {
+			t.Errorf("nothing was pushed, got %+v", event)
+		}
+	}
+}
@@ -0,0 +1,148 @@
+/*
+Copyright (C) 2017 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+)
+
+//MatrixFeatureFile is the name of the feature file GenerateMatrix writes
+const MatrixFeatureFile = "matrix.feature"
+
+//MatrixConfig selects the stack and sample pairs of the generated scenario matrix.
+//The include and exclude lists are glob patterns matched against stack names and sample locations.
+type MatrixConfig struct {
+	Generate       bool     `json:"generate" yaml:"generate"`
+	Tags           string   `json:"tags" yaml:"tags"`
+	IncludeStacks  []string `json:"includeStacks" yaml:"includeStacks"`
+	ExcludeStacks  []string `json:"excludeStacks" yaml:"excludeStacks"`
+	IncludeSamples []string `json:"includeSamples" yaml:"includeSamples"`
+	ExcludeSamples []string `json:"excludeSamples" yaml:"excludeSamples"`
+}
+
+//MatrixEntry is a stack and a sample that is tested on it
+type MatrixEntry struct {
+	Stack  Workspace
+	Sample Sample
+}
+
+//matrixSteps is the scenario run for every matrix entry, the same one the hand written features use
+var matrixSteps = []string{
+	"When we try to get the stacks information",
+	"Then the stacks should not be empty",
+	`When starting a workspace with stack "<stack>" succeeds`,
+	`Then workspace should have state "RUNNING"`,
+	`When importing the sample project "<sample>" succeeds`,
+	"Then workspace should have 1 project",
+	`When user runs command on sample "<sample>"`,
+	"Then exit code should be 0",
+	"When user stops workspace",
+	`Then workspace should have state "STOPPED"`,
+	"When workspace is removed",
+	"Then workspace removal should be successful",
+}
+
+//CompatibleSamples returns the samples that can be tested on stack, best first. Only samples the resolver
+//finds compatible and that have a command to run, of their own or of the stack, are returned.
+func CompatibleSamples(stack Workspace, samples []Sample) []Sample {
+	var compatible []Sample
+	for _, match := range ResolveSamples(stack, samples) {
+		if _, runnable := BuildCommand(stack, match.Sample); match.Compatible && runnable {
+			compatible = append(compatible, match.Sample)
+		}
+	}
+	return compatible
+}
+
+//BuildMatrix pairs every stack selected by cfg with each of its compatible samples selected by cfg
+func BuildMatrix(stacks []Workspace, samples []Sample, cfg MatrixConfig) []MatrixEntry {
+	var selectedSamples []Sample
+	for _, sample := range samples {
+		if selected(sample.Source.Location, cfg.IncludeSamples, cfg.ExcludeSamples) {
+			selectedSamples = append(selectedSamples, sample)
+		}
+	}
+
+	var entries []MatrixEntry
+	for _, stack := range stacks {
+		if !selected(stack.Name, cfg.IncludeStacks, cfg.ExcludeStacks) {
+			continue
+		}
+		for _, sample := range CompatibleSamples(stack, selectedSamples) {
+			entries = append(entries, MatrixEntry{Stack: stack, Sample: sample})
+		}
+	}
+	return entries
+}
+
+//MatrixFeature renders entries as a feature with a Scenario Outline and one Examples row per entry
+func MatrixFeature(entries []MatrixEntry, tags string) string {
+	lines := []string{
+		tags,
+		"Feature: Che stack matrix",
+		"  Every stack on the server is tested with its compatible samples",
+		"",
+		"  Scenario Outline: User starts workspace, imports projects, checks run commands",
+	}
+	for _, step := range matrixSteps {
+		lines = append(lines, "    "+step)
+	}
+
+	lines = append(lines, "", "    Examples:", "    | stack | sample |")
+	for _, entry := range entries {
+		lines = append(lines, fmt.Sprintf("    | %s | %s |", entry.Stack.Name, entry.Sample.Source.Location))
+	}
+
+	return strings.Join(lines, "\n") + "\n"
+}
+
+//GenerateMatrix builds the matrix from the catalogs on the server and writes it as a feature file into dir
+func (c *CheAPI) GenerateMatrix(ctx context.Context, cfg MatrixConfig, dir string) ([]MatrixEntry, error) {
+	stacks, stackErr := c.GetStackInformation(ctx)
+	if stackErr != nil {
+		return nil, stackErr
+	}
+
+	samples, samplesErr := c.GetSamplesInformation(ctx)
+	if samplesErr != nil {
+		return nil, samplesErr
+	}
+
+	entries := BuildMatrix(stacks, samples, cfg)
+	if len(entries) == 0 {
+		return nil, fmt.Errorf("No stack on the server has a compatible sample")
+	}
+
+	tags := cfg.Tags
+	if tags == "" {
+		tags = "@che @matrix"
+	}
+
+	writeErr := ioutil.WriteFile(filepath.Join(dir, MatrixFeatureFile), []byte(MatrixFeature(entries, tags)), 0644)
+	return entries, writeErr
+}
+
+func selected(name string, include, exclude []string) bool {
+	if len(include) > 0 && !matchesAny(include, name) {
+		return false
+	}
+	return !matchesAny(exclude, name)
+}
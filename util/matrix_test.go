@@ -0,0 +1,91 @@
+/*
+Copyright (C) 2017 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func matrixPairs(entries []MatrixEntry) string {
+	var pairs []string
+	for _, entry := range entries {
+		pairs = append(pairs, entry.Stack.Name+"="+entry.Sample.Name)
+	}
+	return strings.Join(pairs, ",")
+}
+
+func TestBuildMatrixPairsCompatibleSamples(t *testing.T) {
+	entries := BuildMatrix(FakeStacks(), FakeSamples(), MatrixConfig{})
+
+	expected := "Eclipse Vert.x=vertx-http-booster,Eclipse Vert.x=console-java-simple,Java CentOS=console-java-simple"
+	if matrixPairs(entries) != expected {
+		t.Errorf("expected %s, got %s", expected, matrixPairs(entries))
+	}
+}
+
+func TestBuildMatrixFilters(t *testing.T) {
+	entries := BuildMatrix(FakeStacks(), FakeSamples(), MatrixConfig{
+		ExcludeStacks:  []string{"Java *"},
+		IncludeSamples: []string{"https://github.com/che-samples/*"},
+	})
+
+	if expected := "Eclipse Vert.x=console-java-simple"; matrixPairs(entries) != expected {
+		t.Errorf("expected %s, got %s", expected, matrixPairs(entries))
+	}
+}
+
+func TestGenerateMatrixWritesRunnableFeature(t *testing.T) {
+	fake, c := newFakeCheAPI(6)
+	defer fake.Close()
+
+	dir, err := ioutil.TempDir("", "matrix")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	entries, err := c.GenerateMatrix(context.Background(), MatrixConfig{IncludeStacks: []string{"Java CentOS"}}, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("expected a single pair, got %s", matrixPairs(entries))
+	}
+
+	splitDir := filepath.Join(dir, "split")
+	os.Mkdir(splitDir, 0755)
+	written, err := SplitFeatures([]string{filepath.Join(dir, MatrixFeatureFile)}, splitDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	feature, _ := ioutil.ReadFile(written[0])
+	for _, expected := range []string{
+		"@che @matrix\n",
+		`When starting a workspace with stack "<stack>" succeeds`,
+		"| Java CentOS | https://github.com/che-samples/console-java-simple.git |\n",
+	} {
+		if !strings.Contains(string(feature), expected) {
+			t.Errorf("the generated feature is missing %q:\n%s", expected, feature)
+		}
+	}
+}
@@ -0,0 +1,223 @@
+/*
+Copyright (C) 2017 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+//Lifecycle phases that are timed per stack
+const (
+	PhaseStart   = "start"
+	PhaseImport  = "import"
+	PhaseCommand = "command"
+	PhaseStop    = "stop"
+	PhaseDelete  = "delete"
+)
+
+var phases = []string{PhaseStart, PhaseImport, PhaseCommand, PhaseStop, PhaseDelete}
+
+//Timing is how long a lifecycle phase of a stack took
+type Timing struct {
+	Stack    string
+	Phase    string
+	Started  time.Time
+	Duration time.Duration
+}
+
+//Metrics collects the lifecycle timings of every scenario, concurrent scenarios share one Metrics
+type Metrics struct {
+	mu      sync.Mutex
+	timings []Timing
+}
+
+//Budget is the longest a lifecycle phase of the stacks matching the glob pattern Stack may take
+type Budget struct {
+	Stack string   `json:"stack" yaml:"stack"`
+	Phase string   `json:"phase" yaml:"phase"`
+	Max   Duration `json:"max" yaml:"max"`
+}
+
+//BudgetError is returned when a lifecycle phase took longer than its budget
+type BudgetError struct {
+	Timing Timing
+	Budget Budget
+}
+
+func (e *BudgetError) Error() string {
+	return fmt.Sprintf("%s of stack %s took %s, over its budget of %s", e.Timing.Phase, e.Timing.Stack,
+		e.Timing.Duration.Round(time.Millisecond), e.Budget.Max.Duration)
+}
+
+//NewMetrics creates an empty Metrics
+func NewMetrics() *Metrics {
+	return &Metrics{}
+}
+
+//Record adds timing
+func (m *Metrics) Record(timing Timing) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.timings = append(m.timings, timing)
+}
+
+//Timings returns the timings recorded so far in the order they were recorded
+func (m *Metrics) Timings() []Timing {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]Timing(nil), m.timings...)
+}
+
+//WriteCSV writes one row per timing
+func (m *Metrics) WriteCSV(w io.Writer) error {
+	writer := csv.NewWriter(w)
+	writer.Write([]string{"stack", "phase", "started", "seconds"})
+	for _, timing := range m.Timings() {
+		writer.Write([]string{
+			timing.Stack,
+			timing.Phase,
+			timing.Started.UTC().Format(time.RFC3339),
+			strconv.FormatFloat(timing.Duration.Seconds(), 'f', 3, 64),
+		})
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+//WritePrometheus writes the timings in the Prometheus text format, as a summary and the slowest run per stack and phase
+func (m *Metrics) WritePrometheus(w io.Writer) error {
+	type aggregate struct {
+		count int
+		sum   float64
+		max   float64
+	}
+
+	aggregates := make(map[[2]string]*aggregate)
+	var keys [][2]string
+	for _, timing := range m.Timings() {
+		key := [2]string{timing.Stack, timing.Phase}
+		if aggregates[key] == nil {
+			aggregates[key] = &aggregate{}
+			keys = append(keys, key)
+		}
+		seconds := timing.Duration.Seconds()
+		aggregates[key].count++
+		aggregates[key].sum += seconds
+		if seconds > aggregates[key].max {
+			aggregates[key].max = seconds
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i][0] < keys[j][0] || (keys[i][0] == keys[j][0] && keys[i][1] < keys[j][1])
+	})
+
+	lines := []string{
+		"# HELP che_stack_phase_duration_seconds Time a workspace lifecycle phase of a stack took.",
+		"# TYPE che_stack_phase_duration_seconds summary",
+	}
+	for _, key := range keys {
+		labels := prometheusLabels(key[0], key[1])
+		lines = append(lines,
+			fmt.Sprintf("che_stack_phase_duration_seconds_sum%s %.3f", labels, aggregates[key].sum),
+			fmt.Sprintf("che_stack_phase_duration_seconds_count%s %d", labels, aggregates[key].count))
+	}
+
+	lines = append(lines,
+		"# HELP che_stack_phase_duration_seconds_max Slowest run of a workspace lifecycle phase of a stack.",
+		"# TYPE che_stack_phase_duration_seconds_max gauge")
+	for _, key := range keys {
+		lines = append(lines, fmt.Sprintf("che_stack_phase_duration_seconds_max%s %.3f", prometheusLabels(key[0], key[1]), aggregates[key].max))
+	}
+
+	_, err := io.WriteString(w, strings.Join(lines, "\n")+"\n")
+	return err
+}
+
+//WriteFiles writes the CSV and Prometheus exports to the paths that are not empty
+func (m *Metrics) WriteFiles(csvPath, prometheusPath string) error {
+	for path, write := range map[string]func(io.Writer) error{csvPath: m.WriteCSV, prometheusPath: m.WritePrometheus} {
+		if path == "" {
+			continue
+		}
+
+		file, createErr := os.Create(path)
+		if createErr != nil {
+			return createErr
+		}
+		writeErr := write(file)
+		closeErr := file.Close()
+		if writeErr != nil {
+			return fmt.Errorf("Could not write metrics %s: %v", path, writeErr)
+		}
+		if closeErr != nil {
+			return closeErr
+		}
+	}
+	return nil
+}
+
+//Check returns a BudgetError when the budget applies to timing and timing is over it
+func (b Budget) Check(timing Timing) error {
+	if b.Phase != timing.Phase || b.Max.Duration <= 0 || !matchesAny([]string{b.Stack}, timing.Stack) {
+		return nil
+	}
+	if timing.Duration > b.Max.Duration {
+		return &BudgetError{Timing: timing, Budget: b}
+	}
+	return nil
+}
+
+//validate checks that the budget names a known phase and a positive duration
+func (b Budget) validate() error {
+	if b.Max.Duration <= 0 {
+		return fmt.Errorf("The budget of %s %s must be positive", b.Stack, b.Phase)
+	}
+	for _, phase := range phases {
+		if b.Phase == phase {
+			return checkPatterns([]string{b.Stack})
+		}
+	}
+	return fmt.Errorf("Unknown phase %q, expected one of %s", b.Phase, strings.Join(phases, ", "))
+}
+
+//recordPhase records how long phase of the current stack took since started and checks it against the budgets
+func (c *CheAPI) recordPhase(phase string, started time.Time) error {
+	timing := Timing{Stack: c.StackName, Phase: phase, Started: started, Duration: time.Since(started)}
+	if c.Metrics != nil {
+		c.Metrics.Record(timing)
+	}
+
+	for _, budget := range c.Budgets {
+		if budgetErr := budget.Check(timing); budgetErr != nil {
+			return budgetErr
+		}
+	}
+	return nil
+}
+
+func prometheusLabels(stack, phase string) string {
+	escape := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	return fmt.Sprintf(`{stack="%s",phase="%s"}`, escape.Replace(stack), escape.Replace(phase))
+}
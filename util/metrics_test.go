@@ -0,0 +1,135 @@
+/*
+Copyright (C) 2017 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLifecyclePhasesAreTimed(t *testing.T) {
+	fake, c := newFakeCheAPI(6)
+	defer fake.Close()
+
+	c.Metrics = NewMetrics()
+	c.SetStackName("Java CentOS")
+	startFakeWorkspace(t, fake, &c)
+
+	sample := FakeSamples()[1]
+	if err := c.AddSamplesToProject(context.Background(), []Sample{sample}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.PostCommandToWorkspace(context.Background(), sample.Commands[0]); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.StopWorkspace(context.Background(), c.WorkspaceID); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.RemoveWorkspace(context.Background(), c.WorkspaceID); err != nil {
+		t.Fatal(err)
+	}
+
+	var recorded []string
+	for _, timing := range c.Metrics.Timings() {
+		if timing.Stack != "Java CentOS" || timing.Duration <= 0 {
+			t.Errorf("unexpected timing %+v", timing)
+		}
+		recorded = append(recorded, timing.Phase)
+	}
+	if strings.Join(recorded, ",") != "start,import,command,stop,delete" {
+		t.Errorf("unexpected phases %v", recorded)
+	}
+
+	//The fake starts in 50ms, polled every 20ms
+	if start := c.Metrics.Timings()[0].Duration; start < 50*time.Millisecond || start > 500*time.Millisecond {
+		t.Errorf("the start should be timed precisely, got %s", start)
+	}
+}
+
+func TestPhaseOverBudgetFails(t *testing.T) {
+	fake, c := newFakeCheAPI(5)
+	defer fake.Close()
+
+	c.SetStackName("Java CentOS")
+	c.Budgets = []Budget{
+		{Stack: "Node*", Phase: PhaseStart, Max: Duration{time.Millisecond}},
+		{Stack: "Java *", Phase: PhaseStart, Max: Duration{10 * time.Millisecond}},
+	}
+
+	stack := FakeStacks()[1]
+	workspace, err := c.StartWorkspace(context.Background(), stack.Config.EnvironmentConfig, stack.ID)
+	budgetErr, ok := err.(*BudgetError)
+	if !ok {
+		t.Fatalf("expected a BudgetError, got %v", err)
+	}
+	if budgetErr.Budget.Stack != "Java *" || workspace.ID == "" {
+		t.Errorf("the Java budget should have failed after the workspace started: %v", budgetErr)
+	}
+	if !strings.Contains(budgetErr.Error(), "start of stack Java CentOS took") {
+		t.Errorf("unexpected message %q", budgetErr.Error())
+	}
+}
+
+func TestMetricsExports(t *testing.T) {
+	metrics := NewMetrics()
+	started := time.Date(2018, 1, 2, 3, 4, 5, 0, time.UTC)
+	metrics.Record(Timing{Stack: "Java CentOS", Phase: PhaseStart, Started: started, Duration: 40 * time.Second})
+	metrics.Record(Timing{Stack: "Java CentOS", Phase: PhaseStart, Started: started, Duration: 60 * time.Second})
+	metrics.Record(Timing{Stack: `Node "LTS"`, Phase: PhaseImport, Started: started, Duration: 1500 * time.Millisecond})
+
+	var csvOut bytes.Buffer
+	if err := metrics.WriteCSV(&csvOut); err != nil {
+		t.Fatal(err)
+	}
+	expectedCSV := "stack,phase,started,seconds\n" +
+		"Java CentOS,start,2018-01-02T03:04:05Z,40.000\n" +
+		"Java CentOS,start,2018-01-02T03:04:05Z,60.000\n" +
+		"\"Node \"\"LTS\"\"\",import,2018-01-02T03:04:05Z,1.500\n"
+	if csvOut.String() != expectedCSV {
+		t.Errorf("unexpected CSV:\n%s", csvOut.String())
+	}
+
+	var promOut bytes.Buffer
+	if err := metrics.WritePrometheus(&promOut); err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{
+		`che_stack_phase_duration_seconds_sum{stack="Java CentOS",phase="start"} 100.000`,
+		`che_stack_phase_duration_seconds_count{stack="Java CentOS",phase="start"} 2`,
+		`che_stack_phase_duration_seconds_max{stack="Java CentOS",phase="start"} 60.000`,
+		`che_stack_phase_duration_seconds_max{stack="Node \"LTS\"",phase="import"} 1.500`,
+	} {
+		if !strings.Contains(promOut.String(), expected+"\n") {
+			t.Errorf("missing %s in:\n%s", expected, promOut.String())
+		}
+	}
+}
+
+func TestBudgetsAreValidated(t *testing.T) {
+	for _, budget := range []Budget{
+		{Stack: "Java CentOS", Phase: "boot", Max: Duration{time.Minute}},
+		{Stack: "Java CentOS", Phase: PhaseStart},
+		{Stack: "[", Phase: PhaseStart, Max: Duration{time.Minute}},
+	} {
+		if err := budget.validate(); err == nil {
+			t.Errorf("%+v should be invalid", budget)
+		}
+	}
+}
@@ -0,0 +1,135 @@
+/*
+Copyright (C) 2017 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+)
+
+//ProcessRecord is everything known about a command run through the Exec Agent
+type ProcessRecord struct {
+	Pid         int
+	Name        string
+	CommandLine string
+	ExitCode    int
+	Alive       bool
+	Ready       bool
+	ReadyReason string
+	Started     time.Time
+	Finished    time.Time
+	Duration    time.Duration
+	Output      []string
+}
+
+//OutputText joins the output lines of the process
+func (p ProcessRecord) OutputText() string {
+	return strings.Join(p.Output, "\n")
+}
+
+//StartProcess creates and runs command using the Exec Agent without waiting for it
+func (c *CheAPI) StartProcess(ctx context.Context, command Command) (ProcessRecord, error) {
+	commandMarshalled, marshalErr := json.MarshalIndent(command, "", "    ")
+
+	if marshalErr != nil {
+		return ProcessRecord{}, marshalErr
+	}
+
+	started := time.Now()
+	processJSON, _, reqErr := c.doRequest(ctx, http.MethodPost, c.ExecAgentURL, string(commandMarshalled))
+
+	if reqErr != nil {
+		return ProcessRecord{}, reqErr
+	}
+
+	var processData ProcessStruct
+	unmarshalErr := json.Unmarshal(processJSON, &processData)
+	if unmarshalErr != nil {
+		return ProcessRecord{}, unmarshalErr
+	}
+
+	return ProcessRecord{
+		Pid:         processData.Pid,
+		Name:        command.Name,
+		CommandLine: command.CommandLine,
+		ExitCode:    processData.ExitCode,
+		Alive:       true,
+		Started:     started,
+	}, nil
+}
+
+//WaitForProcess follows process until it exits and returns its exit code, duration and output.
+//Events are streamed over the exec agent WebSocket when the runtime has one (Che6), otherwise the REST API is polled.
+func (c *CheAPI) WaitForProcess(ctx context.Context, process ProcessRecord) (ProcessRecord, error) {
+	if c.ExecAgentWSURL != "" {
+		streamed, streamErr := c.waitForProcessEvents(ctx, process)
+		if streamErr == nil || ctx.Err() != nil {
+			return streamed, streamErr
+		}
+		c.logf("Streaming events of process %d failed, falling back to polling: %v", process.Pid, streamErr)
+	}
+
+	pollInterval := durationOrDefault(c.ProcessPollInterval, 15*time.Second)
+
+	for {
+		processData, processErr := c.GetCommandExitCode(ctx, process.Pid)
+		if processErr != nil {
+			return process, processErr
+		}
+
+		if !processData.Alive {
+			return c.refreshProcess(ctx, process)
+		}
+
+		if sleepErr := sleep(ctx, pollInterval); sleepErr != nil {
+			return process, sleepErr
+		}
+	}
+}
+
+//refreshProcess updates process with the current state and output reported by the Exec Agent
+func (c *CheAPI) refreshProcess(ctx context.Context, process ProcessRecord) (ProcessRecord, error) {
+	processData, processErr := c.GetCommandExitCode(ctx, process.Pid)
+	if processErr != nil {
+		return process, processErr
+	}
+
+	process.Alive = processData.Alive
+	process.ExitCode = processData.ExitCode
+
+	if process.Alive {
+		process.Duration = time.Since(process.Started)
+	} else if process.Finished.IsZero() {
+		process.Finished = time.Now()
+		process.Duration = process.Finished.Sub(process.Started)
+	}
+
+	logs, logsErr := c.GetExecLogs(ctx, process.Pid)
+	if logsErr != nil {
+		return process, logsErr
+	}
+
+	process.Output = make([]string, 0, len(logs))
+	for _, logItem := range logs {
+		process.Output = append(process.Output, logItem.Text)
+	}
+
+	return process, nil
+}
@@ -0,0 +1,88 @@
+/*
+Copyright (C) 2017 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+//startFakeWorkspace starts a workspace on fake and points c at its agents
+func startFakeWorkspace(t *testing.T, fake *FakeChe, c *CheAPI) {
+	stack := FakeStacks()[0]
+	workspace, err := c.StartWorkspace(context.Background(), stack.Config.EnvironmentConfig, stack.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.SetWorkspaceID(workspace.ID)
+
+	agents, err := c.GetHTTPAgents(context.Background(), workspace.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.SetAgentsURL(agents)
+}
+
+func TestPostCommandRecordsRealExitCode(t *testing.T) {
+	fake, c := newFakeCheAPI(6)
+	defer fake.Close()
+	startFakeWorkspace(t, fake, &c)
+
+	fake.SetProcess("mvn clean install", FakeProcess{
+		ExitCode: 1,
+		Output:   []string{"[INFO] Building", "[ERROR] BUILD FAILURE"},
+		Duration: 30 * time.Millisecond,
+	})
+
+	process, err := c.PostCommandToWorkspace(context.Background(), Command{Name: "build", CommandLine: "mvn clean install"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if process.Alive {
+		t.Errorf("a failed build should have exited: %+v", process)
+	}
+	if process.ExitCode != 1 {
+		t.Errorf("expected exit code 1, got %d", process.ExitCode)
+	}
+	if process.Duration < 30*time.Millisecond {
+		t.Errorf("expected the duration to cover the run, got %s", process.Duration)
+	}
+	if process.OutputText() != "[INFO] Building\n[ERROR] BUILD FAILURE" {
+		t.Errorf("unexpected output %q", process.OutputText())
+	}
+}
+
+func TestPostCommandWaitsForServersWithoutProbe(t *testing.T) {
+	fake, c := newFakeCheAPI(5)
+	defer fake.Close()
+	startFakeWorkspace(t, fake, &c)
+
+	fake.SetProcess("mvn vertx:run", FakeProcess{Output: []string{"Succeeded in deploying verticle"}, Forever: true})
+
+	//Without a readiness probe a quiet server is not taken for ready, it is waited for like a build
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	process, err := c.PostCommandToWorkspace(ctx, Command{Name: "run", CommandLine: "mvn vertx:run"})
+	if err == nil {
+		t.Fatal("a server without readiness probe should be waited for until it exits")
+	}
+	if process.Ready {
+		t.Errorf("a server without readiness probe cannot be ready: %+v", process)
+	}
+}
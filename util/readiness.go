@@ -0,0 +1,229 @@
+/*
+Copyright (C) 2017 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const (
+	//ProbeHTTP is ready once a GET on a workspace server answers with a status below 400
+	ProbeHTTP = "http"
+	//ProbeTCP is ready once a workspace server port accepts connections
+	ProbeTCP = "tcp"
+	//ProbeLog is ready once a process output line matches a regex
+	ProbeLog = "log"
+)
+
+//ReadinessProbe tells when a long running command such as a server is ready.
+//Server is the name, ref or port of a server in the workspace runtime and is used by the http and tcp probes.
+type ReadinessProbe struct {
+	Type    string   `json:"type" yaml:"type"`
+	Server  string   `json:"server" yaml:"server"`
+	Path    string   `json:"path" yaml:"path"`
+	Pattern string   `json:"pattern" yaml:"pattern"`
+	Timeout Duration `json:"timeout" yaml:"timeout"`
+}
+
+//ReadinessError is returned when a command fails to become ready
+type ReadinessError struct {
+	Command string
+	Reason  string
+}
+
+func (e *ReadinessError) Error() string {
+	return fmt.Sprintf("Command %q failed to become ready: %s", e.Command, e.Reason)
+}
+
+//WaitUntilReady runs probe against process until it passes, the process exits or the probe times out
+func (c *CheAPI) WaitUntilReady(ctx context.Context, process ProcessRecord, probe ReadinessProbe) (ProcessRecord, error) {
+	check, checkErr := c.readinessCheck(ctx, probe, func() ([]string, error) {
+		logs, logsErr := c.GetExecLogs(ctx, process.Pid)
+		var lines []string
+		for _, logItem := range logs {
+			lines = append(lines, logItem.Text)
+		}
+		return lines, logsErr
+	})
+	if checkErr != nil {
+		return process, &ReadinessError{Command: process.CommandLine, Reason: checkErr.Error()}
+	}
+
+	timeout := durationOrDefault(probe.Timeout.Duration, 5*time.Minute)
+	pollInterval := durationOrDefault(c.ProcessPollInterval, 15*time.Second)
+	deadline := time.Now().Add(timeout)
+	lastReason := "the probe has not run"
+
+	for {
+		processData, processErr := c.GetCommandExitCode(ctx, process.Pid)
+		if processErr != nil {
+			return process, processErr
+		}
+
+		if !processData.Alive {
+			process, _ = c.refreshProcess(ctx, process)
+			return process, &ReadinessError{
+				Command: process.CommandLine,
+				Reason:  fmt.Sprintf("the process exited with %d before becoming ready", process.ExitCode),
+			}
+		}
+
+		ready, reason := check()
+		if ready {
+			var refreshErr error
+			process, refreshErr = c.refreshProcess(ctx, process)
+			process.Ready = true
+			process.ReadyReason = reason
+			return process, refreshErr
+		}
+		lastReason = reason
+
+		if time.Now().After(deadline) {
+			process, _ = c.refreshProcess(ctx, process)
+			return process, &ReadinessError{
+				Command: process.CommandLine,
+				Reason:  fmt.Sprintf("not ready within %s, %s", timeout, lastReason),
+			}
+		}
+
+		if sleepErr := sleep(ctx, pollInterval); sleepErr != nil {
+			return process, sleepErr
+		}
+	}
+}
+
+//readinessCheck builds the check for probe. The check reports whether the command is ready and why.
+//The log probe matches the output lines of the command returned by logs.
+func (c *CheAPI) readinessCheck(ctx context.Context, probe ReadinessProbe, logs func() ([]string, error)) (func() (bool, string), error) {
+	switch probe.Type {
+	case ProbeHTTP:
+		server, serverErr := c.findServer(probe.Server)
+		if serverErr != nil {
+			return nil, serverErr
+		}
+		probeURL := strings.TrimSuffix(server.URL, "/") + "/" + strings.TrimPrefix(probe.Path, "/")
+		client := http.Client{Timeout: 10 * time.Second}
+
+		return func() (bool, string) {
+			req, reqErr := http.NewRequest(http.MethodGet, probeURL, nil)
+			if reqErr != nil {
+				return false, reqErr.Error()
+			}
+			res, getErr := client.Do(req.WithContext(ctx))
+			if getErr != nil {
+				return false, fmt.Sprintf("GET %s failed: %v", probeURL, getErr)
+			}
+			res.Body.Close()
+			if res.StatusCode >= 400 {
+				return false, fmt.Sprintf("GET %s returned %d", probeURL, res.StatusCode)
+			}
+			return true, fmt.Sprintf("GET %s returned %d", probeURL, res.StatusCode)
+		}, nil
+
+	case ProbeTCP:
+		server, serverErr := c.findServer(probe.Server)
+		if serverErr != nil {
+			return nil, serverErr
+		}
+		address, addressErr := hostPort(server.URL)
+		if addressErr != nil {
+			return nil, addressErr
+		}
+
+		return func() (bool, string) {
+			dialer := net.Dialer{Timeout: 10 * time.Second}
+			conn, dialErr := dialer.DialContext(ctx, "tcp", address)
+			if dialErr != nil {
+				return false, fmt.Sprintf("connecting to %s failed: %v", address, dialErr)
+			}
+			conn.Close()
+			return true, fmt.Sprintf("%s accepts connections", address)
+		}, nil
+
+	case ProbeLog:
+		pattern, patternErr := regexp.Compile(probe.Pattern)
+		if patternErr != nil {
+			return nil, fmt.Errorf("invalid log pattern: %v", patternErr)
+		}
+
+		return func() (bool, string) {
+			lines, logsErr := logs()
+			if logsErr != nil {
+				return false, fmt.Sprintf("reading the logs failed: %v", logsErr)
+			}
+			for _, line := range lines {
+				if pattern.MatchString(line) {
+					return true, fmt.Sprintf("log line %q matches %q", line, probe.Pattern)
+				}
+			}
+			return false, fmt.Sprintf("no log line matches %q", probe.Pattern)
+		}, nil
+	}
+
+	return nil, fmt.Errorf("unknown readiness probe type %q", probe.Type)
+}
+
+//findServer looks up a runtime server by name (Che6), by ref (Che5) or by port
+func (c *CheAPI) findServer(name string) (ServerURL, error) {
+	return findServer(c.Servers, name)
+}
+
+func findServer(servers map[string]ServerURL, name string) (ServerURL, error) {
+	if server, ok := servers[name]; ok {
+		return server, nil
+	}
+
+	for key, server := range servers {
+		if server.Ref == name || strings.TrimSuffix(key, "/tcp") == name {
+			return server, nil
+		}
+	}
+
+	return ServerURL{}, fmt.Errorf("the workspace runtime has no server %q", name)
+}
+
+//hostPort returns the host:port a server URL points to
+func hostPort(serverURL string) (string, error) {
+	parsed, parseErr := url.Parse(serverURL)
+	if parseErr != nil {
+		return "", parseErr
+	}
+
+	if parsed.Port() != "" {
+		return parsed.Host, nil
+	}
+
+	switch parsed.Scheme {
+	case "https", "wss":
+		return net.JoinHostPort(parsed.Hostname(), "443"), nil
+	case "http", "ws":
+		return net.JoinHostPort(parsed.Hostname(), "80"), nil
+	}
+
+	if parsed.Host == "" {
+		//Che5 servers may be plain host:port addresses
+		return serverURL, nil
+	}
+	return parsed.Host, nil
+}
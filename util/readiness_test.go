@@ -0,0 +1,124 @@
+/*
+Copyright (C) 2017 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReadinessProbes(t *testing.T) {
+	probes := map[string]ReadinessProbe{
+		"http by Che6 server name": {Type: ProbeHTTP, Server: "tomcat8", Path: "/"},
+		"tcp by port":              {Type: ProbeTCP, Server: "tomcat8"},
+		"log pattern":              {Type: ProbeLog, Pattern: "Succeeded in deploying"},
+	}
+
+	for name, probe := range probes {
+		fake, c := newFakeCheAPI(6)
+		startFakeWorkspace(t, fake, &c)
+
+		fake.SetProcess("mvn vertx:run", FakeProcess{Output: []string{"Succeeded in deploying verticle"}, Forever: true})
+		c.Readiness = map[string]ReadinessProbe{"run": probe}
+
+		process, err := c.PostCommandToWorkspace(context.Background(), Command{Name: "run", CommandLine: "mvn vertx:run"})
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+		if !process.Ready || process.ReadyReason == "" {
+			t.Errorf("%s: expected the command to be ready: %+v", name, process)
+		}
+
+		fake.Close()
+	}
+}
+
+func TestReadinessFailsWhenProcessCrashes(t *testing.T) {
+	fake, c := newFakeCheAPI(5)
+	defer fake.Close()
+	startFakeWorkspace(t, fake, &c)
+
+	fake.SetProcess("mvn vertx:run", FakeProcess{ExitCode: 1, Output: []string{"Address already in use"}, Duration: 30 * time.Millisecond})
+	c.Readiness = map[string]ReadinessProbe{"run": {Type: ProbeHTTP, Server: "8080", Timeout: Duration{time.Second}}}
+
+	process, err := c.PostCommandToWorkspace(context.Background(), Command{Name: "run", CommandLine: "mvn vertx:run"})
+	readinessErr, ok := err.(*ReadinessError)
+	if !ok {
+		t.Fatalf("expected a ReadinessError, got %v", err)
+	}
+	if !strings.Contains(readinessErr.Reason, "exited with 1") {
+		t.Errorf("unexpected reason %q", readinessErr.Reason)
+	}
+	if process.Ready || process.ExitCode != 1 {
+		t.Errorf("unexpected process %+v", process)
+	}
+}
+
+func TestReadinessTimesOut(t *testing.T) {
+	fake, c := newFakeCheAPI(6)
+	defer fake.Close()
+	startFakeWorkspace(t, fake, &c)
+
+	fake.SetProcess("mvn vertx:run", FakeProcess{Output: []string{"Downloading dependencies"}, Forever: true})
+	c.Readiness = map[string]ReadinessProbe{"run": {Type: ProbeLog, Pattern: "Succeeded", Timeout: Duration{100 * time.Millisecond}}}
+
+	_, err := c.PostCommandToWorkspace(context.Background(), Command{Name: "run", CommandLine: "mvn vertx:run"})
+	if err == nil || !strings.Contains(err.Error(), "not ready within 100ms") {
+		t.Errorf("expected a timeout, got %v", err)
+	}
+}
+
+func TestReadinessProbesOnChe7(t *testing.T) {
+	probes := map[string]ReadinessProbe{
+		"http by server name": {Type: ProbeHTTP, Server: "tomcat8", Path: "/"},
+		"tcp by server name":  {Type: ProbeTCP, Server: "tomcat8"},
+		"log pattern":         {Type: ProbeLog, Pattern: "Succeeded in deploying"},
+	}
+
+	for name, probe := range probes {
+		fake, c := newFakeCheAPI(7)
+		devfile, err := ParseDevfile([]byte(FakeDevfiles()[0].Content))
+		if err != nil {
+			t.Fatal(err)
+		}
+		workspace, err := c.StartDevfileWorkspace(context.Background(), devfile, "java-maven")
+		if err != nil {
+			t.Fatal(err)
+		}
+		agents, err := c.GetHTTPAgents(context.Background(), workspace.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c.SetWorkspaceID(workspace.ID)
+		c.SetAgentsURL(agents)
+
+		fake.SetProcess("mvn vertx:run", FakeProcess{Output: []string{"Succeeded in deploying verticle"}, Forever: true})
+		c.Readiness = map[string]ReadinessProbe{"run": probe}
+
+		process, err := c.PostCommandToWorkspace(context.Background(), Command{Name: "run", CommandLine: "mvn vertx:run"})
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+		if !process.Ready || !process.Alive || process.ReadyReason == "" {
+			t.Errorf("%s: expected the command to be ready: %+v", name, process)
+		}
+
+		fake.Close()
+	}
+}
@@ -0,0 +1,304 @@
+/*
+Copyright (C) 2017 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+//RecordedCall is a call made to a RecordingClient
+type RecordedCall struct {
+	Method string
+	Args   []interface{}
+}
+
+//RecordingClient is a CheClient that answers from memory and records every call made to it.
+//Workspaces start and stop straight away, commands exit with 0 unless Processes says otherwise.
+type RecordingClient struct {
+	Stacks  []Workspace
+	Samples []Sample
+	//Processes are what PostCommandToWorkspace returns, by command line
+	Processes map[string]ProcessRecord
+	//Errors make the calls to the methods with these names fail
+	Errors map[string]error
+	Calls  []RecordedCall
+	Logs   []string
+
+	workspaceID     string
+	stackName       string
+	process         ProcessRecord
+	statuses        map[string]string
+	statusHistory   []WorkspaceEvent
+	projects        []Sample
+	stackConfigMap  map[string]Workspace
+	sampleConfigMap map[string]Sample
+	nextID          int
+	nextPid         int
+}
+
+//NewRecordingClient creates a RecordingClient serving the stacks and samples
+func NewRecordingClient(stacks []Workspace, samples []Sample) *RecordingClient {
+	return &RecordingClient{
+		Stacks:    stacks,
+		Samples:   samples,
+		Processes: make(map[string]ProcessRecord),
+		Errors:    make(map[string]error),
+		statuses:  make(map[string]string),
+	}
+}
+
+//CallsTo returns the calls made to method, in the order they were made
+func (r *RecordingClient) CallsTo(method string) []RecordedCall {
+	var calls []RecordedCall
+	for _, call := range r.Calls {
+		if call.Method == method {
+			calls = append(calls, call)
+		}
+	}
+	return calls
+}
+
+//SetStatus changes the status of the workspace workspaceID as if Che did
+func (r *RecordingClient) SetStatus(workspaceID, status string) {
+	r.statusHistory = append(r.statusHistory, WorkspaceEvent{
+		Type:        WorkspaceStatusChanged,
+		WorkspaceID: workspaceID,
+		Status:      status,
+		PrevStatus:  r.statuses[workspaceID],
+		Time:        time.Now(),
+	})
+	r.statuses[workspaceID] = status
+}
+
+//record adds the call to Calls and returns the error it was scripted to fail with
+func (r *RecordingClient) record(method string, args ...interface{}) error {
+	r.Calls = append(r.Calls, RecordedCall{Method: method, Args: args})
+	return r.Errors[method]
+}
+
+func (r *RecordingClient) GetStackInformation(ctx context.Context) ([]Workspace, error) {
+	if err := r.record("GetStackInformation"); err != nil {
+		return nil, err
+	}
+	return r.Stacks, nil
+}
+
+func (r *RecordingClient) GetSamplesInformation(ctx context.Context) ([]Sample, error) {
+	if err := r.record("GetSamplesInformation"); err != nil {
+		return nil, err
+	}
+	return r.Samples, nil
+}
+
+func (r *RecordingClient) GenerateDataForWorkspaces(stackData []Workspace, samples []Sample) {
+	r.record("GenerateDataForWorkspaces", stackData, samples)
+	r.stackConfigMap = make(map[string]Workspace)
+	for _, stack := range stackData {
+		r.stackConfigMap[stack.Name] = stack
+	}
+	r.sampleConfigMap = make(map[string]Sample)
+	for _, sample := range samples {
+		r.sampleConfigMap[sample.Source.Location] = sample
+	}
+}
+
+func (r *RecordingClient) GetStackConfigMap() map[string]Workspace {
+	return r.stackConfigMap
+}
+
+func (r *RecordingClient) GetSamplesConfigMap() map[string]Sample {
+	return r.sampleConfigMap
+}
+
+func (r *RecordingClient) CompatibleSample(stackName string) (SampleMatch, []SampleMatch, error) {
+	if err := r.record("CompatibleSample", stackName); err != nil {
+		return SampleMatch{}, nil, err
+	}
+	return compatibleSample(r.stackConfigMap, r.sampleConfigMap, stackName)
+}
+
+func (r *RecordingClient) CreateStack(ctx context.Context, stack Workspace) (Workspace, error) {
+	if err := r.record("CreateStack", stack); err != nil {
+		return Workspace{}, err
+	}
+	r.nextID++
+	stack.ID = fmt.Sprintf("stack%d", r.nextID)
+	r.Stacks = append(r.Stacks, stack)
+	return stack, nil
+}
+
+func (r *RecordingClient) SearchStacks(ctx context.Context, tags ...string) ([]Workspace, error) {
+	if err := r.record("SearchStacks", tags); err != nil {
+		return nil, err
+	}
+	var stacks []Workspace
+	for _, stack := range r.Stacks {
+		if hasTags(stack, tags) {
+			stacks = append(stacks, stack)
+		}
+	}
+	return stacks, nil
+}
+
+func (r *RecordingClient) DeleteStack(ctx context.Context, stackID string) error {
+	if err := r.record("DeleteStack", stackID); err != nil {
+		return err
+	}
+	for index, stack := range r.Stacks {
+		if stack.ID == stackID {
+			r.Stacks = append(append([]Workspace(nil), r.Stacks[:index]...), r.Stacks[index+1:]...)
+			return nil
+		}
+	}
+	return fmt.Errorf("Stack with id '%s' was not found", stackID)
+}
+
+func (r *RecordingClient) StartWorkspace(ctx context.Context, workspaceConfiguration interface{}, stackID string) (Workspace2, error) {
+	if err := r.record("StartWorkspace", workspaceConfiguration, stackID); err != nil {
+		return Workspace2{}, err
+	}
+	return r.startWorkspace(), nil
+}
+
+func (r *RecordingClient) StartDevfileWorkspace(ctx context.Context, devfile Devfile, stackID string) (Workspace2, error) {
+	if err := r.record("StartDevfileWorkspace", devfile, stackID); err != nil {
+		return Workspace2{}, err
+	}
+	return r.startWorkspace(), nil
+}
+
+func (r *RecordingClient) startWorkspace() Workspace2 {
+	r.nextID++
+	workspace := Workspace2{ID: fmt.Sprintf("workspace%d", r.nextID)}
+	r.SetStatus(workspace.ID, "STARTING")
+	r.SetStatus(workspace.ID, "RUNNING")
+	return workspace
+}
+
+func (r *RecordingClient) GetHTTPAgents(ctx context.Context, workspaceID string) (Agent, error) {
+	if err := r.record("GetHTTPAgents", workspaceID); err != nil {
+		return Agent{}, err
+	}
+	return Agent{servers: make(map[string]ServerURL)}, nil
+}
+
+func (r *RecordingClient) GetWorkspaceStatusByID(ctx context.Context, workspaceID string) (WorkspaceStatus, error) {
+	if err := r.record("GetWorkspaceStatusByID", workspaceID); err != nil {
+		return WorkspaceStatus{}, err
+	}
+	status, ok := r.statuses[workspaceID]
+	if !ok {
+		return WorkspaceStatus{}, fmt.Errorf("Workspace with id '%s' doesn't exist", workspaceID)
+	}
+	return WorkspaceStatus{WorkspaceStatus: status}, nil
+}
+
+func (r *RecordingClient) StopWorkspace(ctx context.Context, workspaceID string) error {
+	if err := r.record("StopWorkspace", workspaceID); err != nil {
+		return err
+	}
+	r.SetStatus(workspaceID, "STOPPING")
+	r.SetStatus(workspaceID, "STOPPED")
+	return nil
+}
+
+func (r *RecordingClient) RemoveWorkspace(ctx context.Context, workspaceID string) error {
+	if err := r.record("RemoveWorkspace", workspaceID); err != nil {
+		return err
+	}
+	delete(r.statuses, workspaceID)
+	return nil
+}
+
+func (r *RecordingClient) CheckWorkspaceDeletion(ctx context.Context, workspaceID string) error {
+	if err := r.record("CheckWorkspaceDeletion", workspaceID); err != nil {
+		return err
+	}
+	if _, ok := r.statuses[workspaceID]; ok {
+		return fmt.Errorf("Workspace was not deleted")
+	}
+	return nil
+}
+
+func (r *RecordingClient) AddSamplesToProject(ctx context.Context, sample []Sample) error {
+	if err := r.record("AddSamplesToProject", sample); err != nil {
+		return err
+	}
+	r.projects = append(r.projects, sample...)
+	return nil
+}
+
+func (r *RecordingClient) GetNumberOfProjects(ctx context.Context) (int, error) {
+	if err := r.record("GetNumberOfProjects"); err != nil {
+		return -1, err
+	}
+	return len(r.projects), nil
+}
+
+//PostCommandToWorkspace returns the process even when the call is scripted to fail, like a command
+//that ran but did not succeed
+func (r *RecordingClient) PostCommandToWorkspace(ctx context.Context, sampleCommand Command) (ProcessRecord, error) {
+	err := r.record("PostCommandToWorkspace", sampleCommand)
+
+	r.nextPid++
+	process, scripted := r.Processes[sampleCommand.CommandLine]
+	if !scripted {
+		process = ProcessRecord{Name: sampleCommand.Name, CommandLine: sampleCommand.CommandLine}
+	}
+	if process.Pid == 0 {
+		process.Pid = r.nextPid
+	}
+	return process, err
+}
+
+func (r *RecordingClient) SetWorkspaceID(workspaceID string) {
+	r.workspaceID = workspaceID
+}
+
+func (r *RecordingClient) GetWorkspaceID() string {
+	return r.workspaceID
+}
+
+func (r *RecordingClient) SetStackName(stackName string) {
+	r.stackName = stackName
+}
+
+func (r *RecordingClient) GetStackName() string {
+	return r.stackName
+}
+
+func (r *RecordingClient) SetAgentsURL(agents Agent) {
+	r.record("SetAgentsURL", agents)
+}
+
+func (r *RecordingClient) SetProcess(process ProcessRecord) {
+	r.process = process
+}
+
+func (r *RecordingClient) GetProcess() ProcessRecord {
+	return r.process
+}
+
+func (r *RecordingClient) GetStatusHistory() []WorkspaceEvent {
+	return r.statusHistory
+}
+
+func (r *RecordingClient) Logf(format string, args ...interface{}) {
+	r.Logs = append(r.Logs, fmt.Sprintf(format, args...))
+}
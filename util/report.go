@@ -0,0 +1,365 @@
+/*
+Copyright (C) 2017 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+//Statuses of steps and test cases in reports
+const (
+	StatusPassed = "passed"
+	StatusFailed = "failed"
+)
+
+//Report collects the results of the scenarios run against a Che server.
+//Scenarios running concurrently may add their cases at the same time.
+type Report struct {
+	Endpoint string `json:"endpoint"`
+	//CheVersion is the version detected on the server before the run
+	CheVersion string `json:"cheVersion,omitempty"`
+	//SamplesSource is where the samples catalog was read from
+	SamplesSource string        `json:"samplesSource,omitempty"`
+	Started       time.Time     `json:"started"`
+	Seconds       float64       `json:"seconds"`
+	Cases         []*CaseReport `json:"cases"`
+
+	mu sync.Mutex
+}
+
+//CaseReport is the result of a single scenario with the Che context it ran in
+type CaseReport struct {
+	Feature        string                `json:"feature"`
+	Name           string                `json:"name"`
+	Status         string                `json:"status"`
+	Error          string                `json:"error,omitempty"`
+	APIError       *CheAPIError          `json:"apiError,omitempty"`
+	StatusError    *WorkspaceStatusError `json:"statusError,omitempty"`
+	Stack          string                `json:"stack,omitempty"`
+	Sample         string                `json:"sample,omitempty"`
+	WorkspaceID    string                `json:"workspaceId,omitempty"`
+	CheVersion     string                `json:"cheVersion,omitempty"`
+	Started        time.Time             `json:"started"`
+	Seconds        float64               `json:"seconds"`
+	Steps          []StepReport          `json:"steps"`
+	FailedCommands []CommandReport       `json:"failedCommands,omitempty"`
+	Transitions    []WorkspaceEvent      `json:"transitions,omitempty"`
+
+	stepStarted time.Time
+}
+
+//StepReport is the result of a single step
+type StepReport struct {
+	Text    string  `json:"text"`
+	Status  string  `json:"status"`
+	Error   string  `json:"error,omitempty"`
+	Seconds float64 `json:"seconds"`
+}
+
+//CommandReport is a command that failed, with its exec agent logs
+type CommandReport struct {
+	Name        string   `json:"name"`
+	CommandLine string   `json:"commandLine"`
+	ExitCode    int      `json:"exitCode"`
+	Alive       bool     `json:"alive"`
+	Output      []string `json:"output"`
+}
+
+//NewReport starts a report of a run against endpoint
+func NewReport(endpoint string) *Report {
+	return &Report{Endpoint: endpoint, Started: time.Now()}
+}
+
+//StartCase adds the scenario name of feature to the report
+func (r *Report) StartCase(feature, name string) *CaseReport {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	cr := &CaseReport{Feature: feature, Name: name, Status: StatusPassed, Started: time.Now()}
+	r.Cases = append(r.Cases, cr)
+	return cr
+}
+
+//Finish records how long the whole run took
+func (r *Report) Finish() {
+	r.Seconds = time.Since(r.Started).Seconds()
+}
+
+//StartStep records that a step started
+func (cr *CaseReport) StartStep() {
+	cr.stepStarted = time.Now()
+}
+
+//EndStep records the result of the step that started last
+func (cr *CaseReport) EndStep(text string, err error) {
+	step := StepReport{Text: text, Status: StatusPassed, Seconds: time.Since(cr.stepStarted).Seconds()}
+	if err != nil {
+		step.Status = StatusFailed
+		step.Error = err.Error()
+	}
+	cr.Steps = append(cr.Steps, step)
+}
+
+//End records the result of the scenario and the Che context c ran it in. The last command is
+//reported with its logs when it failed or was running when the scenario failed.
+func (cr *CaseReport) End(c *CheAPI, err error) {
+	cr.Seconds = time.Since(cr.Started).Seconds()
+	if err != nil {
+		cr.Status = StatusFailed
+		cr.Error = err.Error()
+		var apiErr *CheAPIError
+		if errors.As(err, &apiErr) {
+			cr.APIError = apiErr
+		}
+		var statusErr *WorkspaceStatusError
+		if errors.As(err, &statusErr) {
+			cr.StatusError = statusErr
+		}
+	}
+
+	cr.Stack = c.StackName
+	cr.Sample = c.SampleLocation
+	cr.WorkspaceID = c.WorkspaceID
+	cr.CheVersion = c.CheVersion
+	cr.Transitions = c.StatusHistory
+
+	process := c.Process
+	if process.Pid != 0 && ((!process.Alive && process.ExitCode != 0) || err != nil) {
+		cr.FailedCommands = append(cr.FailedCommands, CommandReport{
+			Name:        process.Name,
+			CommandLine: process.CommandLine,
+			ExitCode:    process.ExitCode,
+			Alive:       process.Alive,
+			Output:      process.Output,
+		})
+	}
+}
+
+//WriteJSON writes the report as JSON
+func (r *Report) WriteJSON(w io.Writer) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(r)
+}
+
+type junitTestSuites struct {
+	XMLName  xml.Name         `xml:"testsuites"`
+	Tests    int              `xml:"tests,attr"`
+	Failures int              `xml:"failures,attr"`
+	Time     string           `xml:"time,attr"`
+	Suites   []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name       string          `xml:"name,attr"`
+	Tests      int             `xml:"tests,attr"`
+	Failures   int             `xml:"failures,attr"`
+	Time       string          `xml:"time,attr"`
+	Timestamp  string          `xml:"timestamp,attr"`
+	Properties []junitProperty `xml:"properties>property"`
+	Cases      []junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	ClassName  string          `xml:"classname,attr"`
+	Name       string          `xml:"name,attr"`
+	Time       string          `xml:"time,attr"`
+	Properties []junitProperty `xml:"properties>property,omitempty"`
+	Failure    *junitFailure   `xml:"failure,omitempty"`
+	SystemOut  string          `xml:"system-out,omitempty"`
+}
+
+type junitProperty struct {
+	Name  string `xml:"name,attr"`
+	Value string `xml:"value,attr"`
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Type    string `xml:"type,attr"`
+	Text    string `xml:",chardata"`
+}
+
+//WriteJUnit writes the report as JUnit XML with a test suite per feature and a test case per scenario.
+//The Che context of a case is written as its properties and, with the step timings and the logs of
+//failed commands, as its output.
+func (r *Report) WriteJUnit(w io.Writer) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	suites := junitTestSuites{Time: seconds(r.Seconds)}
+	suiteIndex := make(map[string]int)
+
+	for _, cr := range r.Cases {
+		index, ok := suiteIndex[cr.Feature]
+		if !ok {
+			index = len(suites.Suites)
+			suiteIndex[cr.Feature] = index
+			suites.Suites = append(suites.Suites, junitTestSuite{
+				Name:       cr.Feature,
+				Timestamp:  cr.Started.Format("2006-01-02T15:04:05"),
+				Properties: r.properties(),
+			})
+		}
+		suite := &suites.Suites[index]
+
+		testCase := junitTestCase{
+			ClassName:  cr.Feature,
+			Name:       cr.Name,
+			Time:       seconds(cr.Seconds),
+			Properties: cr.properties(),
+			SystemOut:  cr.output(),
+		}
+		if cr.Status == StatusFailed {
+			testCase.Failure = &junitFailure{Message: cr.Error, Type: "step", Text: cr.failedStep()}
+			suite.Failures++
+			suites.Failures++
+		}
+
+		suite.Cases = append(suite.Cases, testCase)
+		suite.Tests++
+		suites.Tests++
+	}
+
+	for index := range suites.Suites {
+		var total float64
+		for _, cr := range r.Cases {
+			if cr.Feature == suites.Suites[index].Name {
+				total += cr.Seconds
+			}
+		}
+		suites.Suites[index].Time = seconds(total)
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	if err := encoder.Encode(suites); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+//WriteFiles writes the JUnit XML and JSON reports to the paths that are not empty
+func (r *Report) WriteFiles(junitPath, jsonPath string) error {
+	writers := map[string]func(io.Writer) error{junitPath: r.WriteJUnit, jsonPath: r.WriteJSON}
+
+	var paths []string
+	for path := range writers {
+		if path != "" {
+			paths = append(paths, path)
+		}
+	}
+	sort.Strings(paths)
+
+	for _, path := range paths {
+		file, createErr := os.Create(path)
+		if createErr != nil {
+			return createErr
+		}
+		writeErr := writers[path](file)
+		closeErr := file.Close()
+		if writeErr != nil {
+			return fmt.Errorf("Could not write report %s: %v", path, writeErr)
+		}
+		if closeErr != nil {
+			return closeErr
+		}
+	}
+	return nil
+}
+
+func (r *Report) properties() []junitProperty {
+	properties := []junitProperty{{Name: "che.endpoint", Value: r.Endpoint}}
+	if r.CheVersion != "" {
+		properties = append(properties, junitProperty{Name: "che.version", Value: r.CheVersion})
+	}
+	if r.SamplesSource != "" {
+		properties = append(properties, junitProperty{Name: "samples.source", Value: r.SamplesSource})
+	}
+	return properties
+}
+
+func (cr *CaseReport) properties() []junitProperty {
+	var properties []junitProperty
+	for _, property := range []junitProperty{
+		{Name: "stack", Value: cr.Stack},
+		{Name: "sample", Value: cr.Sample},
+		{Name: "workspaceId", Value: cr.WorkspaceID},
+		{Name: "cheVersion", Value: cr.CheVersion},
+	} {
+		if property.Value != "" {
+			properties = append(properties, property)
+		}
+	}
+	return properties
+}
+
+func (cr *CaseReport) failedStep() string {
+	for _, step := range cr.Steps {
+		if step.Status == StatusFailed {
+			return step.Text + "\n" + step.Error
+		}
+	}
+	return cr.Error
+}
+
+//output describes the context, the steps and the failed commands of the case as text
+func (cr *CaseReport) output() string {
+	var lines []string
+	for _, property := range cr.properties() {
+		lines = append(lines, property.Name+": "+property.Value)
+	}
+
+	for _, step := range cr.Steps {
+		lines = append(lines, fmt.Sprintf("[%s] %s (%ss)", step.Status, step.Text, seconds(step.Seconds)))
+	}
+
+	for _, event := range cr.Transitions {
+		lines = append(lines, fmt.Sprintf("%s workspace %s %s", event.Time.Format("15:04:05.000"), event.WorkspaceID, event))
+	}
+
+	for _, command := range cr.FailedCommands {
+		state := fmt.Sprintf("exit code %d", command.ExitCode)
+		if command.Alive {
+			state = "still running"
+		}
+		lines = append(lines, fmt.Sprintf("Command %s %q, %s:", command.Name, command.CommandLine, state))
+		lines = append(lines, command.Output...)
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+func seconds(s float64) string {
+	return fmt.Sprintf("%.3f", s)
+}
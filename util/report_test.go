@@ -0,0 +1,113 @@
+/*
+Copyright (C) 2017 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func sampleReport() *Report {
+	report := NewReport("http://che:8080/api")
+
+	passed := report.StartCase("Che add-on", "Vert.x builds")
+	passed.StartStep()
+	passed.EndStep(`starting a workspace with stack "Eclipse Vert.x" succeeds`, nil)
+	passed.End(&CheAPI{StackName: "Eclipse Vert.x", WorkspaceID: "workspace0001", CheVersion: "6"}, nil)
+
+	failed := report.StartCase("Che add-on", "Java builds")
+	failed.StartStep()
+	failed.EndStep(`starting a workspace with stack "Java CentOS" succeeds`, nil)
+	failed.StartStep()
+	failed.EndStep("exit code should be 0", fmt.Errorf("Command exited with 1"))
+	failed.End(&CheAPI{
+		StackName:      "Java CentOS",
+		SampleLocation: "https://github.com/che-samples/console-java-simple.git",
+		WorkspaceID:    "workspace0002",
+		CheVersion:     "6",
+		Process:        ProcessRecord{Pid: 3, Name: "build", CommandLine: "mvn install", ExitCode: 1, Output: []string{"BUILD FAILURE"}},
+	}, fmt.Errorf("Command exited with 1"))
+
+	report.Finish()
+	return report
+}
+
+func TestJUnitReport(t *testing.T) {
+	var out bytes.Buffer
+	if err := sampleReport().WriteJUnit(&out); err != nil {
+		t.Fatal(err)
+	}
+
+	var suites junitTestSuites
+	if err := xml.Unmarshal(out.Bytes(), &suites); err != nil {
+		t.Fatalf("invalid XML: %v\n%s", err, out.String())
+	}
+
+	if suites.Tests != 2 || suites.Failures != 1 || len(suites.Suites) != 1 {
+		t.Fatalf("unexpected totals %+v", suites)
+	}
+
+	failedCase := suites.Suites[0].Cases[1]
+	if failedCase.Failure == nil || !strings.Contains(failedCase.Failure.Text, "exit code should be 0") {
+		t.Errorf("the failing step should be reported: %+v", failedCase.Failure)
+	}
+	for _, expected := range []string{"stack: Java CentOS", "workspaceId: workspace0002", "cheVersion: 6", "BUILD FAILURE"} {
+		if !strings.Contains(failedCase.SystemOut, expected) {
+			t.Errorf("the output is missing %q:\n%s", expected, failedCase.SystemOut)
+		}
+	}
+	if len(failedCase.Properties) != 4 {
+		t.Errorf("expected the Che context as properties, got %+v", failedCase.Properties)
+	}
+}
+
+func TestJSONReport(t *testing.T) {
+	var out bytes.Buffer
+	if err := sampleReport().WriteJSON(&out); err != nil {
+		t.Fatal(err)
+	}
+
+	var report struct {
+		Endpoint string
+		Cases    []CaseReport
+	}
+	if err := json.Unmarshal(out.Bytes(), &report); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(report.Cases) != 2 || report.Cases[0].Status != StatusPassed || report.Cases[1].Status != StatusFailed {
+		t.Fatalf("unexpected cases %+v", report.Cases)
+	}
+
+	failed := report.Cases[1]
+	if failed.Sample != "https://github.com/che-samples/console-java-simple.git" || len(failed.Steps) != 2 {
+		t.Errorf("unexpected case %+v", failed)
+	}
+	if len(failed.FailedCommands) != 1 || failed.FailedCommands[0].Output[0] != "BUILD FAILURE" {
+		t.Errorf("the failed command logs should be reported: %+v", failed.FailedCommands)
+	}
+	if failed.APIError != nil {
+		t.Errorf("a failed command is not a Che API error: %+v", failed.APIError)
+	}
+	if len(report.Cases[0].FailedCommands) != 0 {
+		t.Errorf("a passing case has no failed commands: %+v", report.Cases[0].FailedCommands)
+	}
+}
@@ -0,0 +1,94 @@
+/*
+Copyright (C) 2017 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"errors"
+	"math/rand"
+	"net"
+	"net/http"
+	"syscall"
+	"time"
+)
+
+//RetryPolicy says how often and how far apart transient failures of requests are retried.
+//Agents refuse connections or answer 502/503 for a few seconds while a workspace starts.
+type RetryPolicy struct {
+	//MaxAttempts is the number of attempts including the first one, 1 or less never retries
+	MaxAttempts    int      `json:"maxAttempts" yaml:"maxAttempts"`
+	InitialBackoff Duration `json:"initialBackoff" yaml:"initialBackoff"`
+	MaxBackoff     Duration `json:"maxBackoff" yaml:"maxBackoff"`
+}
+
+//DefaultRetryPolicy retries a few times over about ten seconds
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts:    5,
+		InitialBackoff: Duration{500 * time.Millisecond},
+		MaxBackoff:     Duration{8 * time.Second},
+	}
+}
+
+//Backoff is the wait before the retry following the failed attempt (starting at 1). It doubles
+//with every attempt up to MaxBackoff, and a random half of it is jitter so that concurrent
+//scenarios do not retry in lockstep.
+func (p RetryPolicy) Backoff(attempt int) time.Duration {
+	backoff := durationOrDefault(p.InitialBackoff.Duration, 500*time.Millisecond)
+	maxBackoff := durationOrDefault(p.MaxBackoff.Duration, 8*time.Second)
+	for i := 1; i < attempt && backoff < maxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > maxBackoff {
+		backoff = maxBackoff
+	}
+
+	return backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1))
+}
+
+//shouldRetry tells whether the attempt that ended in statusCode or err is worth retrying.
+//A refused connection never reached the server, so any request can be retried. Other transient
+//failures might have been processed, so only idempotent requests are retried.
+func shouldRetry(method string, statusCode int, err error) bool {
+	if err != nil && connectionRefused(err) {
+		return true
+	}
+	if !idempotent(method) {
+		return false
+	}
+	if err != nil {
+		var netErr net.Error
+		return errors.As(err, &netErr) && netErr.Timeout()
+	}
+
+	switch statusCode {
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+func idempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPut, http.MethodDelete, http.MethodOptions:
+		return true
+	}
+	return false
+}
+
+func connectionRefused(err error) bool {
+	return errors.Is(err, syscall.ECONNREFUSED)
+}
@@ -0,0 +1,123 @@
+/*
+Copyright (C) 2017 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func countRequests(fake *FakeChe, request string) int {
+	count := 0
+	for _, served := range fake.Requests() {
+		if served == request {
+			count++
+		}
+	}
+	return count
+}
+
+func TestTransientFailuresAreRetried(t *testing.T) {
+	fake, c := newFakeCheAPI(6)
+	defer fake.Close()
+
+	var logs bytes.Buffer
+	c.Logger = log.New(&logs, "", 0)
+
+	fake.Fail(http.MethodGet, "/api/stack", http.StatusBadGateway, "Bad gateway", 2)
+
+	stacks, err := c.GetStackInformation(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stacks) == 0 {
+		t.Error("the stacks should have been read after the retries")
+	}
+	if countRequests(fake, "GET /api/stack") != 3 {
+		t.Errorf("expected 3 attempts, got %d", countRequests(fake, "GET /api/stack"))
+	}
+	if strings.Count(logs.String(), "Retrying GET") != 2 || !strings.Contains(logs.String(), "status 502") {
+		t.Errorf("every retry should be logged:\n%s", logs.String())
+	}
+}
+
+func TestNonIdempotentRequestsAreNotRetried(t *testing.T) {
+	fake, c := newFakeCheAPI(6)
+	defer fake.Close()
+
+	fake.Fail(http.MethodPost, "/api/workspace", http.StatusServiceUnavailable, "Service unavailable", 1)
+
+	_, status, err := c.doRequest(context.Background(), http.MethodPost, c.CheAPIEndpoint+"/workspace", "{}")
+	if !IsStatus(err, http.StatusServiceUnavailable) || countRequests(fake, "POST /api/workspace") != 1 {
+		t.Errorf("a POST that reached the server must not be repeated, got %d", status)
+	}
+}
+
+func TestRefusedConnectionsAreRetried(t *testing.T) {
+	fake, c := newFakeCheAPI(6)
+	fake.Close()
+
+	var logs bytes.Buffer
+	c.Logger = log.New(&logs, "", 0)
+	c.Retry.MaxAttempts = 3
+
+	_, status, err := c.doRequest(context.Background(), http.MethodPost, c.CheAPIEndpoint+"/workspace", "{}")
+	if err == nil || status != 0 {
+		t.Fatalf("expected a connection error, got %d %v", status, err)
+	}
+	if !connectionRefused(err) {
+		t.Errorf("expected a refused connection, got %v", err)
+	}
+	if strings.Count(logs.String(), "Retrying POST") != 2 {
+		t.Errorf("a refused POST never reached the server and should be retried:\n%s", logs.String())
+	}
+}
+
+func TestTimeoutsAreRetried(t *testing.T) {
+	fake, c := newFakeCheAPI(6)
+	defer fake.Close()
+
+	c.RequestTimeout = 20 * time.Millisecond
+	c.Retry.MaxAttempts = 2
+	fake.Delay(http.MethodGet, "/api/stack", 200*time.Millisecond)
+
+	_, _, err := c.doRequest(context.Background(), http.MethodGet, c.CheAPIEndpoint+"/stack", "")
+	if err == nil {
+		t.Fatal("expected a timeout")
+	}
+	if countRequests(fake, "GET /api/stack") != 2 {
+		t.Errorf("expected the timed out GET to be retried once, got %d attempts", countRequests(fake, "GET /api/stack"))
+	}
+}
+
+func TestBackoffGrowsWithJitter(t *testing.T) {
+	policy := RetryPolicy{MaxAttempts: 10, InitialBackoff: Duration{time.Second}, MaxBackoff: Duration{5 * time.Second}}
+
+	for attempt, maxBackoff := range map[int]time.Duration{1: time.Second, 2: 2 * time.Second, 3: 4 * time.Second, 6: 5 * time.Second} {
+		for i := 0; i < 20; i++ {
+			backoff := policy.Backoff(attempt)
+			if backoff < maxBackoff/2 || backoff > maxBackoff {
+				t.Errorf("attempt %d: backoff %s is outside [%s, %s]", attempt, backoff, maxBackoff/2, maxBackoff)
+			}
+		}
+	}
+}
@@ -0,0 +1,110 @@
+/*
+Copyright (C) 2017 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"strings"
+)
+
+//releaseSamples is where eclipse/che kept samples.json at a release tag
+const releaseSamples = "https://raw.githubusercontent.com/eclipse/che/%s/ide/che-core-ide-templates/src/main/resources/samples.json"
+
+//SamplesSource is where the samples.json catalog is read from
+type SamplesSource interface {
+	//Read returns the catalog, c is used for requests
+	Read(ctx context.Context, c *CheAPI) ([]byte, error)
+	//String describes the source in logs and reports
+	String() string
+}
+
+//URLSamples reads the catalog from a URL
+type URLSamples struct {
+	URL string
+}
+
+func (s URLSamples) Read(ctx context.Context, c *CheAPI) ([]byte, error) {
+	samplesJSON, _, reqErr := c.doPublicRequest(ctx, s.URL)
+	return samplesJSON, reqErr
+}
+
+func (s URLSamples) String() string {
+	return s.URL
+}
+
+//ReleaseSamples reads the catalog of a Che release so it does not change under the tests
+type ReleaseSamples struct {
+	Tag string
+}
+
+func (s ReleaseSamples) Read(ctx context.Context, c *CheAPI) ([]byte, error) {
+	return URLSamples{URL: s.url()}.Read(ctx, c)
+}
+
+func (s ReleaseSamples) String() string {
+	return fmt.Sprintf("release %s (%s)", s.Tag, s.url())
+}
+
+func (s ReleaseSamples) url() string {
+	return fmt.Sprintf(releaseSamples, s.Tag)
+}
+
+//FileSamples reads the catalog from a local file
+type FileSamples struct {
+	Path string
+}
+
+func (s FileSamples) Read(ctx context.Context, c *CheAPI) ([]byte, error) {
+	return ioutil.ReadFile(s.Path)
+}
+
+func (s FileSamples) String() string {
+	return "file " + s.Path
+}
+
+//EmbeddedSamples is the catalog built into the binary, for runs without access to GitHub
+type EmbeddedSamples struct{}
+
+func (EmbeddedSamples) Read(ctx context.Context, c *CheAPI) ([]byte, error) {
+	return embeddedSamples, nil
+}
+
+func (EmbeddedSamples) String() string {
+	return "embedded"
+}
+
+//ParseSamplesSource parses the samples setting: a http(s) URL, file:<path>, release:<Che tag> or embedded
+func ParseSamplesSource(value string) (SamplesSource, error) {
+	switch {
+	case value == "embedded":
+		return EmbeddedSamples{}, nil
+	case strings.HasPrefix(value, "file:") && len(value) > len("file:"):
+		return FileSamples{Path: strings.TrimPrefix(value, "file:")}, nil
+	case strings.HasPrefix(value, "release:") && len(value) > len("release:"):
+		return ReleaseSamples{Tag: strings.TrimPrefix(value, "release:")}, nil
+	case strings.HasPrefix(value, "http://") || strings.HasPrefix(value, "https://"):
+		return URLSamples{URL: value}, nil
+	}
+	return nil, fmt.Errorf("Unknown samples source %q, expected a URL, file:<path>, release:<tag> or embedded", value)
+}
+
+//SamplesSource returns where c reads the samples catalog from
+func (c *CheAPI) SamplesSource() (SamplesSource, error) {
+	return ParseSamplesSource(c.samplesURL())
+}
@@ -0,0 +1,95 @@
+/*
+Copyright (C) 2017 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseSamplesSource(t *testing.T) {
+	for value, expected := range map[string]string{
+		"https://example.com/samples.json": "https://example.com/samples.json",
+		"file:/tmp/samples.json":           "file /tmp/samples.json",
+		"release:6.19.0":                   "release 6.19.0 (https://raw.githubusercontent.com/eclipse/che/6.19.0/ide/che-core-ide-templates/src/main/resources/samples.json)",
+		"embedded":                         "embedded",
+	} {
+		source, err := ParseSamplesSource(value)
+		if err != nil {
+			t.Errorf("%s: %v", value, err)
+			continue
+		}
+		if source.String() != expected {
+			t.Errorf("%s: expected %q, got %q", value, expected, source.String())
+		}
+	}
+
+	for _, value := range []string{"", "samples.json", "file:", "release:"} {
+		if _, err := ParseSamplesSource(value); err == nil {
+			t.Errorf("%q should be rejected", value)
+		}
+	}
+}
+
+func TestEmbeddedSamplesHaveTheSamplesOfTheFeatures(t *testing.T) {
+	var samples []Sample
+	if err := json.Unmarshal(embeddedSamples, &samples); err != nil {
+		t.Fatal(err)
+	}
+
+	locations := make(map[string]bool)
+	for _, sample := range samples {
+		locations[sample.Source.Location] = len(sample.Commands) > 0
+	}
+	for _, sample := range FakeSamples() {
+		if !locations[sample.Source.Location] {
+			t.Errorf("expected %s with a command in the embedded catalog", sample.Source.Location)
+		}
+	}
+}
+
+func TestGetSamplesInformationFromFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "samples")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	catalog, _ := json.Marshal(FakeSamples()[:1])
+	path := filepath.Join(dir, "samples.json")
+	if err := ioutil.WriteFile(path, catalog, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	c := CheAPI{SamplesURL: "file:" + path}
+	samples, err := c.GetSamplesInformation(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(samples) != 1 || samples[0].Name != "vertx-http-booster" {
+		t.Errorf("expected the sample of the file, got %+v", samples)
+	}
+
+	c.SamplesURL = "file:" + filepath.Join(dir, "missing.json")
+	if _, err := c.GetSamplesInformation(context.Background()); err == nil {
+		t.Error("a missing file should fail")
+	}
+}
@@ -0,0 +1,98 @@
+/*
+Copyright (C) 2017 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+//embeddedSamples is the samples.json catalog of the samples the features of this repo run.
+//It is kept in the source instead of embedded from a file so that toolchains before Go 1.16 build it.
+var embeddedSamples = []byte(`[
+  {
+    "name": "console-java-simple",
+    "displayName": "console-java-simple",
+    "path": "/console-java-simple",
+    "description": "A hello world Java application.",
+    "projectType": "maven",
+    "mixins": [],
+    "attributes": {
+      "language": [
+        "java"
+      ]
+    },
+    "modules": [],
+    "problems": [],
+    "source": {
+      "type": "git",
+      "location": "https://github.com/che-samples/console-java-simple.git",
+      "parameters": {}
+    },
+    "commands": [
+      {
+        "name": "console-java-simple:build",
+        "type": "mvn",
+        "commandLine": "mvn clean install -f ${current.project.path}",
+        "attributes": {
+          "goal": "Build",
+          "previewUrl": ""
+        }
+      }
+    ],
+    "links": [],
+    "category": "Samples",
+    "tags": [
+      "java",
+      "maven"
+    ]
+  },
+  {
+    "name": "vertx-http-booster",
+    "displayName": "vertx-http-booster",
+    "path": "/vertx-http-booster",
+    "description": "A simple HTTP endpoint built with Eclipse Vert.x.",
+    "projectType": "maven",
+    "mixins": [],
+    "attributes": {
+      "language": [
+        "java"
+      ]
+    },
+    "modules": [],
+    "problems": [],
+    "source": {
+      "type": "git",
+      "location": "https://github.com/openshiftio-vertx-boosters/vertx-http-booster",
+      "parameters": {}
+    },
+    "commands": [
+      {
+        "name": "run",
+        "type": "custom",
+        "commandLine": "cd ${current.project.path} && mvn compile vertx:run",
+        "attributes": {
+          "goal": "Run",
+          "previewUrl": "${server.8080/tcp}"
+        }
+      }
+    ],
+    "links": [],
+    "category": "Samples",
+    "tags": [
+      "vertx",
+      "java",
+      "maven"
+    ]
+  }
+]
+`)
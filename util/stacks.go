@@ -0,0 +1,297 @@
+/*
+Copyright (C) 2017 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"reflect"
+)
+
+//StackIcon is the icon of a stack, Data is only set on a downloaded icon
+type StackIcon struct {
+	Name      string `json:"name"`
+	MediaType string `json:"mediaType"`
+	Data      []byte `json:"-"`
+}
+
+//plainWorkspace is a Workspace without its JSON methods
+type plainWorkspace Workspace
+
+//UnmarshalJSON keeps the JSON the stack was read from next to the fields Workspace models
+func (w *Workspace) UnmarshalJSON(data []byte) error {
+	var plain plainWorkspace
+	if err := json.Unmarshal(data, &plain); err != nil {
+		return err
+	}
+	*w = Workspace(plain)
+	w.raw = append(json.RawMessage(nil), data...)
+	return nil
+}
+
+//MarshalJSON sends a stack that was read from JSON back as that JSON, with only the fields changed since patched in.
+//What Workspace does not model, such as other environments, projects, attributes and links, is kept that way.
+func (w Workspace) MarshalJSON() ([]byte, error) {
+	if w.raw == nil {
+		return json.Marshal(plainWorkspace(w))
+	}
+
+	var document map[string]interface{}
+	if jsonErr := json.Unmarshal(w.raw, &document); jsonErr != nil || document == nil {
+		return json.Marshal(plainWorkspace(w))
+	}
+	var read plainWorkspace
+	if jsonErr := json.Unmarshal(w.raw, &read); jsonErr != nil {
+		return nil, jsonErr
+	}
+
+	readFields, readErr := jsonFields(read)
+	if readErr != nil {
+		return nil, readErr
+	}
+	changedFields, changedErr := jsonFields(plainWorkspace(w))
+	if changedErr != nil {
+		return nil, changedErr
+	}
+	patchJSON(document, readFields, changedFields)
+
+	return json.Marshal(document)
+}
+
+//jsonFields is the JSON object value marshals to
+func jsonFields(value interface{}) (map[string]interface{}, error) {
+	marshalled, marshallErr := json.Marshal(value)
+	if marshallErr != nil {
+		return nil, marshallErr
+	}
+	var fields map[string]interface{}
+	return fields, json.Unmarshal(marshalled, &fields)
+}
+
+//patchJSON applies to document the fields that differ between read and changed, descending into objects
+//so that the fields of document neither of them has are left alone
+func patchJSON(document, read, changed map[string]interface{}) {
+	keys := make(map[string]bool)
+	for key := range read {
+		keys[key] = true
+	}
+	for key := range changed {
+		keys[key] = true
+	}
+
+	for key := range keys {
+		readValue, wasRead := read[key]
+		changedValue, isChanged := changed[key]
+		if wasRead == isChanged && reflect.DeepEqual(readValue, changedValue) {
+			continue
+		}
+		if !isChanged {
+			delete(document, key)
+			continue
+		}
+
+		readObject, readIsObject := readValue.(map[string]interface{})
+		changedObject, changedIsObject := changedValue.(map[string]interface{})
+		documentObject, documentIsObject := document[key].(map[string]interface{})
+		if readIsObject && changedIsObject && documentIsObject {
+			patchJSON(documentObject, readObject, changedObject)
+			continue
+		}
+		document[key] = changedValue
+	}
+}
+
+//CreateStack creates stack on the server and returns it with the ID the server gave it.
+//Teardown deletes the stacks created this way.
+func (c *CheAPI) CreateStack(ctx context.Context, stack Workspace) (Workspace, error) {
+	marshalled, marshallErr := json.Marshal(stack)
+	if marshallErr != nil {
+		return Workspace{}, marshallErr
+	}
+
+	created, createErr := c.postStack(ctx, string(marshalled))
+	if createErr != nil {
+		return Workspace{}, createErr
+	}
+	c.createdStacks = append(c.createdStacks, created.ID)
+
+	return created, nil
+}
+
+//postStack POSTs the stack JSON stackJSON to /stack
+func (c *CheAPI) postStack(ctx context.Context, stackJSON string) (Workspace, error) {
+	createdJSON, _, reqErr := c.doRequest(ctx, http.MethodPost, c.CheAPIEndpoint+"/stack", stackJSON)
+	if reqErr != nil {
+		return Workspace{}, reqErr
+	}
+
+	var created Workspace
+	if jsonErr := json.Unmarshal(createdJSON, &created); jsonErr != nil {
+		return Workspace{}, jsonErr
+	}
+	return created, nil
+}
+
+//GetStack gets the stack with stackID
+func (c *CheAPI) GetStack(ctx context.Context, stackID string) (Workspace, error) {
+	stackJSON, _, reqErr := c.doRequest(ctx, http.MethodGet, c.stackURL(stackID), "")
+	if reqErr != nil {
+		return Workspace{}, reqErr
+	}
+
+	var stack Workspace
+	if jsonErr := json.Unmarshal(stackJSON, &stack); jsonErr != nil {
+		return Workspace{}, jsonErr
+	}
+	return stack, nil
+}
+
+//UpdateStack replaces the stack with the ID of stack by stack and returns it as the server stored it.
+//A stack got from the server keeps what Workspace does not model, see MarshalJSON.
+func (c *CheAPI) UpdateStack(ctx context.Context, stack Workspace) (Workspace, error) {
+	if stack.ID == "" {
+		return Workspace{}, fmt.Errorf("Stack %q has no ID to update", stack.Name)
+	}
+
+	marshalled, marshallErr := json.Marshal(stack)
+	if marshallErr != nil {
+		return Workspace{}, marshallErr
+	}
+
+	updatedJSON, _, reqErr := c.doRequest(ctx, http.MethodPut, c.stackURL(stack.ID), string(marshalled))
+	if reqErr != nil {
+		return Workspace{}, reqErr
+	}
+
+	var updated Workspace
+	if jsonErr := json.Unmarshal(updatedJSON, &updated); jsonErr != nil {
+		return Workspace{}, jsonErr
+	}
+	return updated, nil
+}
+
+//DeleteStack deletes the stack with stackID
+func (c *CheAPI) DeleteStack(ctx context.Context, stackID string) error {
+	_, _, reqErr := c.doRequest(ctx, http.MethodDelete, c.stackURL(stackID), "")
+	if reqErr != nil {
+		return reqErr
+	}
+
+	for index, created := range c.createdStacks {
+		if created == stackID {
+			c.createdStacks = append(c.createdStacks[:index], c.createdStacks[index+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+//SearchStacks gets the stacks having every one of tags
+func (c *CheAPI) SearchStacks(ctx context.Context, tags ...string) ([]Workspace, error) {
+	query := url.Values{"tags": tags}
+	stacksJSON, _, reqErr := c.doRequest(ctx, http.MethodGet, c.CheAPIEndpoint+"/stack?"+query.Encode(), "")
+	if reqErr != nil {
+		return nil, reqErr
+	}
+
+	var stacks []Workspace
+	if jsonErr := json.Unmarshal(stacksJSON, &stacks); jsonErr != nil {
+		return nil, jsonErr
+	}
+	return stacks, nil
+}
+
+//UploadStackIcon uploads icon as the icon of the stack with stackID
+func (c *CheAPI) UploadStackIcon(ctx context.Context, stackID string, icon StackIcon) error {
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+
+	header := make(textproto.MIMEHeader)
+	header.Set("Content-Disposition", fmt.Sprintf(`form-data; name="file"; filename=%q`, icon.Name))
+	header.Set("Content-Type", icon.MediaType)
+	part, partErr := form.CreatePart(header)
+	if partErr != nil {
+		return partErr
+	}
+	if _, writeErr := part.Write(icon.Data); writeErr != nil {
+		return writeErr
+	}
+	if closeErr := form.Close(); closeErr != nil {
+		return closeErr
+	}
+
+	_, _, _, reqErr := c.doContentRequest(ctx, http.MethodPost, c.stackURL(stackID)+"/icon", form.FormDataContentType(), body.Bytes())
+	return reqErr
+}
+
+//GetStackIcon downloads the icon of the stack with stackID
+func (c *CheAPI) GetStackIcon(ctx context.Context, stackID string) (StackIcon, error) {
+	stack, stackErr := c.GetStack(ctx, stackID)
+	if stackErr != nil {
+		return StackIcon{}, stackErr
+	}
+
+	data, header, _, reqErr := c.doContentRequest(ctx, http.MethodGet, c.stackURL(stackID)+"/icon", "application/json", nil)
+	if reqErr != nil {
+		return StackIcon{}, reqErr
+	}
+
+	icon := StackIcon{MediaType: header.Get("Content-Type"), Data: data}
+	if stack.StackIcon != nil {
+		icon.Name = stack.StackIcon.Name
+	}
+	return icon, nil
+}
+
+//DeleteStackIcon removes the icon of the stack with stackID
+func (c *CheAPI) DeleteStackIcon(ctx context.Context, stackID string) error {
+	_, _, reqErr := c.doRequest(ctx, http.MethodDelete, c.stackURL(stackID)+"/icon", "")
+	return reqErr
+}
+
+//teardownStacks deletes the stacks created by CreateStack that still exist
+func (c *CheAPI) teardownStacks(ctx context.Context) []string {
+	var failures []string
+	for _, stackID := range c.createdStacks {
+		_, _, reqErr := c.doRequest(ctx, http.MethodDelete, c.stackURL(stackID), "")
+		if reqErr != nil && !IsStatus(reqErr, http.StatusNotFound) {
+			failures = append(failures, fmt.Sprintf("stack %s: %v", stackID, reqErr))
+		}
+	}
+	c.createdStacks = nil
+	return failures
+}
+
+//hasTags tells whether stack has every one of tags
+func hasTags(stack Workspace, tags []string) bool {
+	for _, tag := range tags {
+		if !containsString(stack.Tags, tag) {
+			return false
+		}
+	}
+	return true
+}
+
+func (c *CheAPI) stackURL(stackID string) string {
+	return c.CheAPIEndpoint + "/stack/" + url.PathEscape(stackID)
+}
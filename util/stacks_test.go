@@ -0,0 +1,184 @@
+/*
+Copyright (C) 2017 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestStackLifecycle(t *testing.T) {
+	fake, c := newFakeCheAPI(6)
+	defer fake.Close()
+	ctx := context.Background()
+
+	stack := FakeStacks()[1]
+	stack.ID = ""
+	stack.Name = "Java CentOS custom"
+	stack.Tags = append(stack.Tags, "custom")
+
+	created, err := c.CreateStack(ctx, stack)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if created.ID == "" {
+		t.Fatal("expected the server to give the stack an ID")
+	}
+	if _, err := c.CreateStack(ctx, stack); !IsStatus(err, http.StatusConflict) {
+		t.Errorf("expected a conflict for the second stack with the same name, got %v", err)
+	}
+
+	created.Description = "Java CentOS with our tools"
+	if _, err := c.UpdateStack(ctx, created); err != nil {
+		t.Fatal(err)
+	}
+	got, err := c.GetStack(ctx, created.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Description != "Java CentOS with our tools" || got.Name != "Java CentOS custom" {
+		t.Errorf("expected the updated stack, got %+v", got)
+	}
+
+	found, err := c.SearchStacks(ctx, "Java", "custom")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(found) != 1 || found[0].ID != created.ID {
+		t.Errorf("expected only the stack with both tags, got %+v", found)
+	}
+	if all, err := c.SearchStacks(ctx, "Java"); err != nil || len(all) != 3 {
+		t.Errorf("expected every Java stack, got %+v %v", all, err)
+	}
+
+	if err := c.DeleteStack(ctx, created.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.GetStack(ctx, created.ID); !IsStatus(err, http.StatusNotFound) {
+		t.Errorf("expected the deleted stack to be gone, got %v", err)
+	}
+}
+
+func TestStackIcon(t *testing.T) {
+	fake, c := newFakeCheAPI(6)
+	defer fake.Close()
+	ctx := context.Background()
+
+	stackID := FakeStacks()[0].ID
+	if _, err := c.GetStackIcon(ctx, stackID); !IsStatus(err, http.StatusNotFound) {
+		t.Errorf("a stack without icon should have none to download, got %v", err)
+	}
+
+	svg := []byte(`<svg xmlns="http://www.w3.org/2000/svg"/>`)
+	if err := c.UploadStackIcon(ctx, stackID, StackIcon{Name: "vertx.svg", MediaType: "image/svg+xml", Data: svg}); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.UploadStackIcon(ctx, stackID, StackIcon{Name: "vertx.txt", MediaType: "text/plain", Data: svg}); !IsStatus(err, http.StatusBadRequest) {
+		t.Errorf("only images should be accepted, got %v", err)
+	}
+
+	icon, err := c.GetStackIcon(ctx, stackID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if icon.Name != "vertx.svg" || icon.MediaType != "image/svg+xml" || !bytes.Equal(icon.Data, svg) {
+		t.Errorf("expected the uploaded icon, got %+v", icon)
+	}
+
+	if err := c.DeleteStackIcon(ctx, stackID); err != nil {
+		t.Fatal(err)
+	}
+	if stack, err := c.GetStack(ctx, stackID); err != nil || stack.StackIcon != nil {
+		t.Errorf("expected the icon to be removed, got %+v %v", stack, err)
+	}
+}
+
+func TestTeardownDeletesCreatedStacks(t *testing.T) {
+	fake, c := newFakeCheAPI(6)
+	defer fake.Close()
+	ctx := context.Background()
+
+	kept, err := c.CreateStack(ctx, Workspace{Name: "kept"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.DeleteStack(ctx, kept.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.CreateStack(ctx, Workspace{Name: "left behind"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c.Teardown(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if len(fake.Stacks()) != len(FakeStacks()) {
+		t.Errorf("expected the created stacks to be deleted, got %+v", fake.Stacks())
+	}
+}
+
+func TestUpdateStackKeepsWhatIsNotModelled(t *testing.T) {
+	fake, c := newFakeCheAPI(6)
+	defer fake.Close()
+	ctx := context.Background()
+
+	var stack Workspace
+	if err := json.Unmarshal([]byte(`{
+  "name": "Java CentOS full",
+  "tags": ["Java"],
+  "workspaceConfig": {
+    "name": "default",
+    "defaultEnv": "default",
+    "environments": {
+      "default": {"recipe": {"type": "dockerimage", "content": "centos-jdk8"}},
+      "debug": {"recipe": {"type": "dockerimage", "content": "centos-jdk8-debug"}}
+    },
+    "projects": [{"name": "console-java-simple", "path": "/console-java-simple"}],
+    "commands": [{"name": "run", "type": "custom", "commandLine": "java -jar app.jar", "attributes": {"goal": "Run"}}]
+  },
+  "links": [{"rel": "remove stack", "href": "http://che/api/stack"}]
+}`), &stack); err != nil {
+		t.Fatal(err)
+	}
+	created, err := c.CreateStack(ctx, stack)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := c.GetStack(ctx, created.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got.Description = "Java CentOS with a debug environment"
+	if _, err := c.UpdateStack(ctx, got); err != nil {
+		t.Fatal(err)
+	}
+
+	stored, _, err := c.doRequest(ctx, http.MethodGet, c.stackURL(created.ID), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, kept := range []string{`"description":"Java CentOS with a debug environment"`, `"debug":`, `"projects":[{`, `"goal":"Run"`, `"links":[{`} {
+		if !strings.Contains(string(stored), kept) {
+			t.Errorf("expected %s in the stored stack, got %s", kept, stored)
+		}
+	}
+}
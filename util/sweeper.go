@@ -0,0 +1,210 @@
+/*
+Copyright (C) 2017 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	//MarkerAttribute is the workspace attribute StartWorkspace marks the workspaces it creates with
+	MarkerAttribute = "stack-tests"
+	//testWorkspaceSuffix ends the name of every workspace StartWorkspace creates, see testWorkspaceName
+	testWorkspaceSuffix = "-stack-test"
+	workspacePageSize   = 30
+)
+
+//testWorkspaceName names a workspace of stackID. Che refuses a second workspace of the same name in a
+//namespace, so a random part keeps scenarios that test the same stack at the same time apart.
+func testWorkspaceName(stackID string) string {
+	if stackID == "" {
+		stackID = "stack"
+	}
+	suffix := make([]byte, 3)
+	if _, randErr := rand.Read(suffix); randErr != nil {
+		return fmt.Sprintf("%s-%x%s", stackID, time.Now().UnixNano(), testWorkspaceSuffix)
+	}
+	return fmt.Sprintf("%s-%x%s", stackID, suffix, testWorkspaceSuffix)
+}
+
+//SweepConfig selects the leftover test workspaces that are stopped and removed by the sweeper
+type SweepConfig struct {
+	//Before sweeps before the suite runs
+	Before bool `json:"before" yaml:"before"`
+	//DryRun only reports what would be removed
+	DryRun bool `json:"dryRun" yaml:"dryRun"`
+	//MaxAge keeps workspaces younger than this, so that runs in progress are left alone. It defaults to an hour,
+	//well past the scenario timeout within which a run removes its own workspaces.
+	MaxAge Duration `json:"maxAge" yaml:"maxAge"`
+	//Include and Exclude are glob patterns matched against the workspace name
+	Include []string `json:"include" yaml:"include"`
+	Exclude []string `json:"exclude" yaml:"exclude"`
+}
+
+//WorkspaceSummary is a workspace as listed by the Che master
+type WorkspaceSummary struct {
+	ID        string `json:"id"`
+	Namespace string `json:"namespace"`
+	Status    string `json:"status"`
+	Config    struct {
+		Name string `json:"name"`
+	} `json:"config"`
+	Devfile struct {
+		Metadata DevfileMetadata `json:"metadata"`
+	} `json:"devfile"`
+	Attributes map[string]string `json:"attributes"`
+}
+
+//Name is the name of the workspace, from its config or, on Che7, its devfile
+func (w WorkspaceSummary) Name() string {
+	if w.Config.Name == "" {
+		return w.Devfile.Metadata.Name
+	}
+	return w.Config.Name
+}
+
+//Created is when the workspace was created, the zero time when the server did not say
+func (w WorkspaceSummary) Created() time.Time {
+	millis, err := strconv.ParseInt(w.Attributes["created"], 10, 64)
+	if err != nil {
+		return time.Time{}
+	}
+	return time.Unix(0, millis*int64(time.Millisecond))
+}
+
+//IsTestWorkspace tells whether the workspace was created by the suite, by its marker attribute
+//or, for workspaces created before the marker was added, by its name
+func (w WorkspaceSummary) IsTestWorkspace() bool {
+	return w.Attributes[MarkerAttribute] == "true" || strings.HasSuffix(w.Name(), testWorkspaceSuffix)
+}
+
+//ListWorkspaces lists every workspace of the user, page by page
+func (c *CheAPI) ListWorkspaces(ctx context.Context) ([]WorkspaceSummary, error) {
+	var workspaces []WorkspaceSummary
+	for {
+		url := fmt.Sprintf("%s/workspace?skipCount=%d&maxItems=%d", c.CheAPIEndpoint, len(workspaces), workspacePageSize)
+		workspacesJSON, _, reqErr := c.doRequest(ctx, http.MethodGet, url, "")
+		if reqErr != nil {
+			return workspaces, reqErr
+		}
+
+		var page []WorkspaceSummary
+		if jsonErr := json.Unmarshal(workspacesJSON, &page); jsonErr != nil {
+			return workspaces, jsonErr
+		}
+		workspaces = append(workspaces, page...)
+
+		if len(page) < workspacePageSize {
+			return workspaces, nil
+		}
+	}
+}
+
+//SweepFailure is a workspace a sweep could not remove and why
+type SweepFailure struct {
+	Workspace WorkspaceSummary
+	Err       error
+}
+
+//SweepError lists the workspaces a sweep could not remove
+type SweepError struct {
+	Failures []SweepFailure
+}
+
+func (e *SweepError) Error() string {
+	failures := make([]string, 0, len(e.Failures))
+	for _, failure := range e.Failures {
+		failures = append(failures, fmt.Sprintf("%s: %v", failure.Workspace.ID, failure.Err))
+	}
+	return fmt.Sprintf("Could not sweep workspaces: %s", strings.Join(failures, "; "))
+}
+
+//SweepWorkspaces finds the test workspaces in the namespace that match cfg and, unless it is a dry run,
+//stops and removes them. It returns the workspaces that were removed, or would be on a dry run.
+//The ones that could not be removed are listed by a *SweepError.
+func (c *CheAPI) SweepWorkspaces(ctx context.Context, cfg SweepConfig) ([]WorkspaceSummary, error) {
+	workspaces, listErr := c.ListWorkspaces(ctx)
+	if listErr != nil {
+		return nil, listErr
+	}
+
+	var swept []WorkspaceSummary
+	var failures []SweepFailure
+	for _, workspace := range workspaces {
+		if !cfg.matches(workspace, c.namespace(), time.Now()) {
+			continue
+		}
+
+		if cfg.DryRun {
+			c.logf("Would remove workspace %s (%s) in status %s", workspace.ID, workspace.Name(), workspace.Status)
+			swept = append(swept, workspace)
+			continue
+		}
+
+		c.logf("Sweeping workspace %s (%s) in status %s", workspace.ID, workspace.Name(), workspace.Status)
+		if teardownErr := c.teardownWorkspace(ctx, workspace.ID); teardownErr != nil {
+			failures = append(failures, SweepFailure{Workspace: workspace, Err: teardownErr})
+			continue
+		}
+		swept = append(swept, workspace)
+	}
+
+	if len(failures) > 0 {
+		return swept, &SweepError{Failures: failures}
+	}
+	return swept, nil
+}
+
+//matches tells whether workspace is a test workspace in namespace that cfg selects at now
+func (cfg SweepConfig) matches(workspace WorkspaceSummary, namespace string, now time.Time) bool {
+	if !workspace.IsTestWorkspace() {
+		return false
+	}
+	if workspace.Namespace != "" && workspace.Namespace != namespace {
+		return false
+	}
+
+	//Without a creation time the age is unknown, so the workspace could belong to a run in progress
+	if cfg.MaxAge.Duration > 0 {
+		created := workspace.Created()
+		if created.IsZero() || now.Sub(created) < cfg.MaxAge.Duration {
+			return false
+		}
+	}
+
+	if len(cfg.Include) > 0 && !matchesAny(cfg.Include, workspace.Name()) {
+		return false
+	}
+	return !matchesAny(cfg.Exclude, workspace.Name())
+}
+
+func matchesAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(pattern, name); matched {
+			return true
+		}
+	}
+	return false
+}
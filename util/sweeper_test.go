@@ -0,0 +1,218 @@
+/*
+Copyright (C) 2017 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func seedLeftoverWorkspaces(fake *FakeChe) map[string]string {
+	old := time.Now().Add(-3 * time.Hour)
+	return map[string]string{
+		"marked":  fake.AddWorkspace(Post{Name: "custom-name", Namespace: "che"}, old, true, map[string]string{MarkerAttribute: "true"}),
+		"named":   fake.AddWorkspace(Post{Name: "java-default-stack-test", Namespace: "che"}, old, false, nil),
+		"fresh":   fake.AddWorkspace(Post{Name: "node-default-stack-test", Namespace: "che"}, time.Now(), true, nil),
+		"foreign": fake.AddWorkspace(Post{Name: "my-project", Namespace: "che"}, old, true, nil),
+		"other":   fake.AddWorkspace(Post{Name: "java-default-stack-test", Namespace: "someone"}, old, false, nil),
+	}
+}
+
+func sweptIDs(swept []WorkspaceSummary) string {
+	var ids []string
+	for _, workspace := range swept {
+		ids = append(ids, workspace.ID)
+	}
+	sort.Strings(ids)
+	return strings.Join(ids, ",")
+}
+
+func TestSweeperDryRunOnlyReports(t *testing.T) {
+	fake, c := newFakeCheAPI(6)
+	defer fake.Close()
+	ids := seedLeftoverWorkspaces(fake)
+
+	swept, err := c.SweepWorkspaces(context.Background(), SweepConfig{DryRun: true, MaxAge: Duration{time.Hour}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if expected := ids["marked"] + "," + ids["named"]; sweptIDs(swept) != expected {
+		t.Errorf("expected %s to be swept, got %s", expected, sweptIDs(swept))
+	}
+	if fake.WorkspaceCount() != 5 {
+		t.Errorf("a dry run should not remove anything, %d workspaces left", fake.WorkspaceCount())
+	}
+}
+
+func TestSweeperRemovesMatchingWorkspaces(t *testing.T) {
+	fake, c := newFakeCheAPI(5)
+	defer fake.Close()
+	ids := seedLeftoverWorkspaces(fake)
+
+	swept, err := c.SweepWorkspaces(context.Background(), SweepConfig{Exclude: []string{"java-*"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if expected := ids["marked"] + "," + ids["fresh"]; sweptIDs(swept) != expected {
+		t.Errorf("expected %s to be swept, got %s", expected, sweptIDs(swept))
+	}
+
+	left, err := c.ListWorkspaces(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := ids["named"] + "," + ids["foreign"] + "," + ids["other"]; sweptIDs(left) != expected {
+		t.Errorf("expected %s to be left, got %s", expected, sweptIDs(left))
+	}
+}
+
+func TestListWorkspacesPages(t *testing.T) {
+	fake, c := newFakeCheAPI(6)
+	defer fake.Close()
+
+	for i := 0; i < workspacePageSize+5; i++ {
+		fake.AddWorkspace(Post{Name: "ws", Namespace: "che"}, time.Now(), false, nil)
+	}
+
+	workspaces, err := c.ListWorkspaces(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(workspaces) != workspacePageSize+5 {
+		t.Errorf("expected every page to be listed, got %d workspaces", len(workspaces))
+	}
+}
+
+func TestStartedWorkspacesAreMarked(t *testing.T) {
+	fake, c := newFakeCheAPI(6)
+	defer fake.Close()
+
+	stack := FakeStacks()[0]
+	if _, err := c.StartWorkspace(context.Background(), stack.Config.EnvironmentConfig, stack.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	workspaces, err := c.ListWorkspaces(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(workspaces) != 1 || !workspaces[0].IsTestWorkspace() || workspaces[0].Attributes[MarkerAttribute] != "true" {
+		t.Errorf("the started workspace should carry the marker attribute: %+v", workspaces)
+	}
+}
+
+func TestSweepKeepsRecentWorkspacesByDefault(t *testing.T) {
+	dir, err := ioutil.TempDir("", "che-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	configFile := filepath.Join(dir, "config.yaml")
+	if err := ioutil.WriteFile(configFile, []byte("sweep:\n  before: true\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := LoadConfigFile(configFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Sweep.MaxAge.Duration != time.Hour {
+		t.Fatalf("expected the default max age, got %s", cfg.Sweep.MaxAge)
+	}
+
+	recent := WorkspaceSummary{Attributes: map[string]string{MarkerAttribute: "true", "created": strconv.FormatInt(time.Now().Add(-time.Minute).UnixNano()/int64(time.Millisecond), 10)}}
+	if cfg.Sweep.matches(recent, "", time.Now()) {
+		t.Error("a workspace of a run in progress should not be swept")
+	}
+}
+
+func TestWorkspacesOfTheSameStackGetTheirOwnNames(t *testing.T) {
+	fake, c := newFakeCheAPI(6)
+	defer fake.Close()
+
+	fake.AddWorkspace(Post{Name: "taken-stack-test", Namespace: "che"}, time.Now(), false, nil)
+	if _, _, err := c.doRequest(context.Background(), http.MethodPost, c.CheAPIEndpoint+"/workspace", `{"name": "taken-stack-test", "namespace": "che"}`); !IsStatus(err, http.StatusConflict) {
+		t.Fatalf("expected a second workspace of the same name to be rejected, got %v", err)
+	}
+
+	//Scenarios of the matrix start the same stack for different samples at the same time
+	stack := FakeStacks()[0]
+	var wg sync.WaitGroup
+	errs := make([]error, 2)
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			scenario := fake.Config(DefaultConfig()).NewCheAPI()
+			_, errs[i] = scenario.StartWorkspace(context.Background(), stack.Config.EnvironmentConfig, stack.ID)
+		}(i)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	workspaces, err := c.ListWorkspaces(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := map[string]bool{}
+	for _, workspace := range workspaces {
+		if workspace.Name() != "taken-stack-test" {
+			names[workspace.Name()] = workspace.IsTestWorkspace() && strings.HasPrefix(workspace.Name(), stack.ID+"-")
+		}
+	}
+	if len(names) != 2 {
+		t.Errorf("expected two test workspaces named after the stack, got %v", names)
+	}
+	for name, ok := range names {
+		if !ok {
+			t.Errorf("unexpected workspace name %q", name)
+		}
+	}
+}
+
+func TestSweepOnlyReturnsTheRemovedWorkspaces(t *testing.T) {
+	fake, c := newFakeCheAPI(5)
+	defer fake.Close()
+	ids := seedLeftoverWorkspaces(fake)
+
+	fake.Fail(http.MethodDelete, "/api/workspace/"+ids["marked"], http.StatusInternalServerError, "Database is down", 100)
+	swept, err := c.SweepWorkspaces(context.Background(), SweepConfig{Exclude: []string{"java-*"}})
+
+	var failed *SweepError
+	if !errors.As(err, &failed) || len(failed.Failures) != 1 || failed.Failures[0].Workspace.ID != ids["marked"] {
+		t.Fatalf("expected the removal of %s to fail, got %v", ids["marked"], err)
+	}
+	if sweptIDs(swept) != ids["fresh"] {
+		t.Errorf("expected only %s to be reported as removed, got %s", ids["fresh"], sweptIDs(swept))
+	}
+}
@@ -0,0 +1,93 @@
+/*
+Copyright (C) 2017 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+//CreatedWorkspaces returns the IDs of the workspaces this CheAPI has created
+func (c *CheAPI) CreatedWorkspaces() []string {
+	return append([]string(nil), c.createdWorkspaces...)
+}
+
+//Teardown stops and removes every workspace this CheAPI has created that still exists,
+//so that a failed scenario does not leave workspaces running on the server, and deletes the stacks it created
+func (c *CheAPI) Teardown(ctx context.Context) error {
+	var failures []string
+	for _, workspaceID := range c.createdWorkspaces {
+		if teardownErr := c.teardownWorkspace(ctx, workspaceID); teardownErr != nil {
+			failures = append(failures, fmt.Sprintf("%s: %v", workspaceID, teardownErr))
+		}
+	}
+	c.createdWorkspaces = nil
+	failures = append(failures, c.teardownStacks(ctx)...)
+
+	if len(failures) > 0 {
+		return fmt.Errorf("Could not tear down workspaces and stacks: %s", strings.Join(failures, "; "))
+	}
+	return nil
+}
+
+//teardownWorkspace stops workspaceID when it is not stopped yet and removes it
+func (c *CheAPI) teardownWorkspace(ctx context.Context, workspaceID string) error {
+	workspaceJSON, _, reqErr := c.doRequest(ctx, http.MethodGet, c.CheAPIEndpoint+"/workspace/"+workspaceID, "")
+	if IsStatus(reqErr, http.StatusNotFound) {
+		return nil
+	}
+	if reqErr != nil {
+		return reqErr
+	}
+
+	var status WorkspaceStatus
+	if jsonErr := json.Unmarshal(workspaceJSON, &status); jsonErr != nil {
+		return jsonErr
+	}
+
+	if status.WorkspaceStatus != "STOPPED" {
+		c.logf("Stopping workspace %s left in status %s", workspaceID, status.WorkspaceStatus)
+
+		//A runtime that is still being stopped only has to be waited for
+		var stopErr error
+		if status.WorkspaceStatus == "RUNNING" || status.WorkspaceStatus == "STARTING" {
+			stopErr = c.stopWorkspace(ctx, workspaceID)
+		} else {
+			stopErr = c.waitWhileStopping(ctx, workspaceID)
+		}
+
+		//A workspace that is no longer stopping can be removed whatever status it ended up in
+		var statusErr *WorkspaceStatusError
+		if errors.As(stopErr, &statusErr) {
+			c.logf("Removing workspace %s anyway: %v", workspaceID, stopErr)
+		} else if stopErr != nil {
+			return stopErr
+		}
+	}
+
+	c.logf("Removing workspace %s", workspaceID)
+	_, _, reqErr = c.doRequest(ctx, http.MethodDelete, c.CheAPIEndpoint+"/workspace/"+workspaceID, "")
+	if reqErr != nil && !IsStatus(reqErr, http.StatusNotFound) {
+		return reqErr
+	}
+
+	return nil
+}
@@ -0,0 +1,68 @@
+/*
+Copyright (C) 2017 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"context"
+	"testing"
+)
+
+func TestTeardownRemovesRunningWorkspaces(t *testing.T) {
+	fake, c := newFakeCheAPI(6)
+	defer fake.Close()
+
+	stack := FakeStacks()[0]
+	for i := 0; i < 2; i++ {
+		if _, err := c.StartWorkspace(context.Background(), stack.Config.EnvironmentConfig, stack.ID); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if fake.WorkspaceCount() != 2 {
+		t.Fatalf("expected 2 workspaces, got %d", fake.WorkspaceCount())
+	}
+
+	if err := c.Teardown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if fake.WorkspaceCount() != 0 {
+		t.Errorf("the workspaces should have been removed, %d left", fake.WorkspaceCount())
+	}
+	if len(c.CreatedWorkspaces()) != 0 {
+		t.Errorf("the workspaces should be forgotten after teardown: %v", c.CreatedWorkspaces())
+	}
+}
+
+func TestTeardownSkipsRemovedWorkspaces(t *testing.T) {
+	fake, c := newFakeCheAPI(5)
+	defer fake.Close()
+
+	stack := FakeStacks()[0]
+	workspace, err := c.StartWorkspace(context.Background(), stack.Config.EnvironmentConfig, stack.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.StopWorkspace(context.Background(), workspace.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.RemoveWorkspace(context.Background(), workspace.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c.Teardown(context.Background()); err != nil {
+		t.Errorf("a removed workspace needs no teardown: %v", err)
+	}
+}
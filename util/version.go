@@ -0,0 +1,247 @@
+/*
+Copyright (C) 2017 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+//VersionedClient is what differs between the Che major versions the tests run against
+type VersionedClient interface {
+	//Version is the major version of Che the client talks to
+	Version() string
+	//Agents finds the agents and servers in the runtime of the workspace in workspaceJSON
+	Agents(workspaceJSON []byte) (Agent, error)
+	//StoppingStatuses are the statuses a workspace passes through while it is stopped
+	StoppingStatuses() []string
+}
+
+//ServiceDescriptor is what the root of the Che API describes itself with
+type ServiceDescriptor struct {
+	Description string `json:"description"`
+	Version     string `json:"version"`
+}
+
+//NewVersionedClient returns the client for the Che major version
+func NewVersionedClient(version string) (VersionedClient, error) {
+	switch version {
+	case "5":
+		return che5Client{}, nil
+	case "6":
+		return che6Client{}, nil
+	case "7":
+		return che7Client{}, nil
+	}
+	return nil, fmt.Errorf("Unsupported Che version %q, expected 5, 6 or 7", version)
+}
+
+//DetectVersion asks the server which version of Che it runs and picks the client talking to it.
+//The version of the API root service descriptor is used if it has one, otherwise the version is
+//told from the endpoints the server has: system/state from Che6 and the devfile registry from Che7.
+func (c *CheAPI) DetectVersion(ctx context.Context) (string, error) {
+	version, source, detectErr := c.detectVersion(ctx)
+	if detectErr != nil {
+		return "", detectErr
+	}
+
+	major := strings.SplitN(version, ".", 2)[0]
+	client, clientErr := NewVersionedClient(major)
+	if clientErr != nil {
+		return "", clientErr
+	}
+
+	c.versioned = client
+	c.CheVersion = major
+	c.CheBuild = version
+	c.logf("Detected Che %s from the %s of %s", version, source, c.CheAPIEndpoint)
+	return version, nil
+}
+
+func (c *CheAPI) detectVersion(ctx context.Context) (string, string, error) {
+	rootJSON, _, rootErr := c.doRequest(ctx, http.MethodGet, c.CheAPIEndpoint+"/", "")
+	if rootErr != nil && !IsStatus(rootErr, http.StatusNotFound) {
+		return "", "", rootErr
+	}
+	if rootErr == nil {
+		var descriptor ServiceDescriptor
+		if json.Unmarshal(rootJSON, &descriptor) == nil && descriptor.Version != "" {
+			return descriptor.Version, "service descriptor", nil
+		}
+	}
+
+	_, _, stateErr := c.doRequest(ctx, http.MethodGet, c.CheAPIEndpoint+"/system/state", "")
+	if IsStatus(stateErr, http.StatusNotFound) {
+		return "5", "missing system service", nil
+	}
+	if stateErr != nil {
+		return "", "", stateErr
+	}
+
+	settingsJSON, _, settingsErr := c.doRequest(ctx, http.MethodGet, c.CheAPIEndpoint+"/workspace/settings", "")
+	if settingsErr != nil && !IsStatus(settingsErr, http.StatusNotFound) {
+		return "", "", settingsErr
+	}
+	var settings workspaceSettings
+	if settingsErr == nil && json.Unmarshal(settingsJSON, &settings) == nil && settings.DevfileRegistryURL != "" {
+		return "7", "workspace settings", nil
+	}
+	return "6", "workspace settings", nil
+}
+
+//versionedClient returns the client for the version of the server, detecting the version the first time
+func (c *CheAPI) versionedClient(ctx context.Context) (VersionedClient, error) {
+	if c.versioned != nil {
+		return c.versioned, nil
+	}
+
+	if c.CheVersion != "" {
+		client, clientErr := NewVersionedClient(c.CheVersion)
+		if clientErr != nil {
+			return nil, clientErr
+		}
+		c.versioned = client
+		return client, nil
+	}
+
+	if _, detectErr := c.DetectVersion(ctx); detectErr != nil {
+		return nil, fmt.Errorf("Could not detect the Che version of %s: %w", c.CheAPIEndpoint, detectErr)
+	}
+	return c.versioned, nil
+}
+
+//che5Client talks to Che5, whose machines are a list and whose servers are keyed by port
+type che5Client struct{}
+
+func (che5Client) Version() string {
+	return "5"
+}
+
+func (che5Client) Agents(workspaceJSON []byte) (Agent, error) {
+	var runtime Che5RuntimeStruct
+	if jsonErr := json.Unmarshal(workspaceJSON, &runtime); jsonErr != nil {
+		return Agent{}, fmt.Errorf("Could not read the Che5 runtime: %v", jsonErr)
+	}
+
+	agents := Agent{servers: make(map[string]ServerURL), cheVersion: "5"}
+	for index := range runtime.Runtime.Machines {
+		for port, server := range runtime.Runtime.Machines[index].Runtime.Servers {
+
+			agents.servers[port] = server
+
+			if server.Ref == "exec-agent" {
+				agents.execAgentURL = server.URL + "/process"
+			}
+
+			if server.Ref == "wsagent" {
+				agents.wsAgentURL = server.URL
+			}
+		}
+	}
+
+	return agents, nil
+}
+
+//StoppingStatuses of Che5 include SNAPSHOTTING, its machines are saved before they are stopped
+func (che5Client) StoppingStatuses() []string {
+	return []string{"SNAPSHOTTING", "STOPPING"}
+}
+
+//che6Client talks to Che6, whose machines and servers are keyed by name
+type che6Client struct{}
+
+func (che6Client) Version() string {
+	return "6"
+}
+
+func (che6Client) Agents(workspaceJSON []byte) (Agent, error) {
+	var runtime RuntimeStruct
+	if jsonErr := json.Unmarshal(workspaceJSON, &runtime); jsonErr != nil {
+		return Agent{}, fmt.Errorf("Could not read the Che6 runtime: %v", jsonErr)
+	}
+
+	agents := Agent{servers: make(map[string]ServerURL), cheVersion: "6"}
+	for key := range runtime.Runtime.Machines {
+		for serverName, installer := range runtime.Runtime.Machines[key].Servers {
+
+			agents.servers[serverName] = installer
+
+			if serverName == "exec-agent/http" {
+				agents.execAgentURL = installer.URL
+			}
+
+			if serverName == "exec-agent/ws" {
+				agents.execAgentWSURL = installer.URL
+			}
+
+			if serverName == "wsagent/http" {
+				agents.wsAgentURL = installer.URL
+			}
+		}
+	}
+
+	return agents, nil
+}
+
+func (che6Client) StoppingStatuses() []string {
+	return []string{"STOPPING"}
+}
+
+//che7Client talks to Che7, which has the runtime of Che6 but runs commands through its plugins
+type che7Client struct{}
+
+func (che7Client) Version() string {
+	return "7"
+}
+
+func (che7Client) Agents(workspaceJSON []byte) (Agent, error) {
+	//Workspaces created from a config still have the installers of Che6
+	agents, agentsErr := che6Client{}.Agents(workspaceJSON)
+	if agentsErr != nil {
+		return Agent{}, agentsErr
+	}
+	agents.cheVersion = "7"
+
+	var runtime RuntimeStruct
+	json.Unmarshal(workspaceJSON, &runtime)
+
+	for key := range runtime.Runtime.Machines {
+		//Che7 tells the servers of its plugins apart by their type
+		for _, server := range runtime.Runtime.Machines[key].Servers {
+			switch server.Attributes["type"] {
+			case "terminal":
+				agents.machineExecURL = server.URL
+			case "ide":
+				agents.editorURL = server.URL
+			}
+		}
+
+		//Commands run in the containers of the devfile, not in those of the plugins
+		if runtime.Runtime.Machines[key].Attributes["source"] == "recipe" && (agents.devMachine == "" || key < agents.devMachine) {
+			agents.devMachine = key
+		}
+	}
+
+	return agents, nil
+}
+
+func (che7Client) StoppingStatuses() []string {
+	return []string{"STOPPING"}
+}
@@ -0,0 +1,111 @@
+/*
+Copyright (C) 2017 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestDetectVersion(t *testing.T) {
+	for _, tc := range []struct {
+		version  int
+		build    string
+		expected string
+	}{
+		{version: 5, expected: "5"},
+		{version: 6, build: "6.19.0", expected: "6.19.0"},
+		{version: 6, expected: "6"},
+		{version: 7, build: "7.3.0", expected: "7.3.0"},
+		{version: 7, expected: "7"},
+	} {
+		fake, c := newFakeCheAPI(tc.version)
+		fake.Build = tc.build
+
+		version, err := c.DetectVersion(context.Background())
+		fake.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if version != tc.expected || c.CheVersion != tc.expected[:1] || c.versioned.Version() != c.CheVersion {
+			t.Errorf("Che%d with build %q: expected %s, got %s and the client of Che%s", tc.version, tc.build, tc.expected, version, c.versioned.Version())
+		}
+	}
+}
+
+func TestVersionedClientsReadOnlyTheirRuntime(t *testing.T) {
+	fake, c := newFakeCheAPI(6)
+	defer fake.Close()
+
+	stack := FakeStacks()[0]
+	workspace, err := c.StartWorkspace(context.Background(), stack.Config.EnvironmentConfig, stack.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	workspaceJSON, _, err := c.doRequest(context.Background(), "GET", c.CheAPIEndpoint+"/workspace/"+workspace.ID, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := (che5Client{}).Agents(workspaceJSON); err == nil {
+		t.Error("the Che5 client should not read the runtime of Che6")
+	}
+	agents, err := (che6Client{}).Agents(workspaceJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if agents.execAgentURL == "" || agents.wsAgentURL == "" || agents.cheVersion != "6" {
+		t.Errorf("expected the agents of the Che6 runtime, got %+v", agents)
+	}
+}
+
+func TestOnlyChe5Snapshots(t *testing.T) {
+	for _, version := range []string{"5", "6", "7"} {
+		client, err := NewVersionedClient(version)
+		if err != nil {
+			t.Fatal(err)
+		}
+		snapshots := containsString(client.StoppingStatuses(), "SNAPSHOTTING")
+		if snapshots != (version == "5") {
+			t.Errorf("Che%s stopping statuses %v", version, client.StoppingStatuses())
+		}
+	}
+
+	if _, err := NewVersionedClient("4"); err == nil {
+		t.Error("Che4 is not supported")
+	}
+}
+
+func TestStopFailsWhenTheWorkspaceDoesNotStop(t *testing.T) {
+	fake, c := newFakeCheAPI(6)
+	defer fake.Close()
+	fake.SetStopTransitions(FakeTransition{Status: "STOPPING"}, FakeTransition{Status: "RUNNING", After: 50 * time.Millisecond, Error: "Could not stop the machine"})
+
+	stack := FakeStacks()[0]
+	workspace, err := c.StartWorkspace(context.Background(), stack.Config.EnvironmentConfig, stack.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = c.StopWorkspace(context.Background(), workspace.ID)
+	var statusErr *WorkspaceStatusError
+	if !errors.As(err, &statusErr) || statusErr.Status != "RUNNING" {
+		t.Errorf("expected the workspace to be reported as still running, got %v", err)
+	}
+}